@@ -0,0 +1,422 @@
+package bot
+
+// Headless clients for load testing. Each bot logs in through the proxy with the same protocol as the real client, sends input every network tick, acks and decodes the world updates, and chats now and then
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"syscall"
+	"os/signal"
+	"math/rand"
+	"crypto/tls"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/flow/net"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/auth"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/reliable"
+	"github.com/unitoftime/mmo/transport"
+)
+
+type Config struct {
+	ProxyUrl string // The url that the proxy listens for clients on
+	TokenSecret []byte // Every bot signs its own login token, so this must match the proxy's
+	Count int // The number of bots
+	FirstUserId uint64 // The bots use the user ids from here up
+	Ramp time.Duration // The time between each bot connecting
+	Duration time.Duration // How long to run for once the first bot connects, zero to run until interrupted
+	Script []mmo.Input // The inputs that every bot repeats, the bots random walk if this is empty (See: ParseScript)
+	ChatInterval time.Duration // How often each bot says something, zero to disable chat
+	ReportInterval time.Duration // How often the statistics are logged, zero to only log them at the end
+	Insecure bool // Skips verifying the proxy's certificate (ie for a self signed test certificate)
+	Seed int64 // Seeds the random walks and chat
+}
+
+func Main(config Config) {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel) // Note: Every bot logs its connection errors at the debug level, which is too much with a lot of bots
+
+	if len(config.TokenSecret) == 0 {
+		panic("Bots require the proxy's TokenSecret to sign their logins")
+	}
+
+	log.Info().
+		Str("ProxyUrl", config.ProxyUrl).
+		Int("Count", config.Count).
+		Dur("Ramp", config.Ramp).
+		Dur("Duration", config.Duration).
+		Msg("Starting bots")
+
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Print("Terminating: ", sig)
+		close(stop)
+	}()
+
+	report := Run(config, stop)
+	report.Log("Final bot report")
+}
+
+// Connects the bots one at a time and runs them until the duration passes (or stop is closed). Returns the statistics of the whole run
+func Run(config Config, stop <-chan struct{}) Report {
+	stats := NewStats()
+	bots := make([]*Bot, 0, config.Count)
+
+	var deadline <-chan time.Time
+	if config.Duration > 0 {
+		deadline = time.After(config.Duration)
+	}
+
+	var reports <-chan time.Time
+	if config.ReportInterval > 0 {
+		ticker := time.NewTicker(config.ReportInterval)
+		defer ticker.Stop()
+		reports = ticker.C
+	}
+
+	var connect <-chan time.Time
+	if config.Count > 0 {
+		connect = time.After(0)
+	}
+
+Loop:
+	for {
+		select {
+		case <-connect:
+			userId := config.FirstUserId + uint64(len(bots))
+			bot, err := NewBot(&config, userId, stats)
+			if err != nil {
+				log.Error().Err(err).Uint64("UserId", userId).Msg("Failed to start bot")
+				break Loop
+			}
+			bots = append(bots, bot)
+
+			connect = nil
+			if len(bots) < config.Count {
+				connect = time.After(config.Ramp)
+			}
+		case <-reports:
+			stats.Report().Log("Bot report")
+		case <-deadline:
+			break Loop
+		case <-stop:
+			break Loop
+		}
+	}
+
+	// Note: Take the report before closing the bots, so that closing them doesn't count as disconnects
+	report := stats.Report()
+	for _, bot := range bots {
+		bot.Close()
+	}
+	return report
+}
+
+var chatLines = []string{
+	"hello",
+	"hi everyone",
+	"anyone want to group up?",
+	"brb",
+	"lol",
+	"where is the portal?",
+	"gg",
+}
+
+type pendingInput struct {
+	tick uint16
+	input mmo.Input
+	sent time.Time
+}
+
+// The most inputs that are remembered while waiting for the server to process them. If the server stops processing them, then the oldest ones are forgotten
+const maxPendingInputs = 256
+
+// A simulated player
+type Bot struct {
+	UserId uint64
+	config *Config
+	stats *Stats
+	driver Driver
+	rng *rand.Rand
+	token string
+
+	sock transport.Socket
+	conn *reliable.Conn
+	done chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	loggedIn bool
+	rejected bool // Set once the proxy rejects us, we stop reconnecting afterwards
+	kicked bool // Set once an admin kicks us, we stop reconnecting afterwards
+	playerTick uint16
+	pending []pendingInput // The inputs that the server hasn't processed yet, oldest first
+	ackTick uint16
+	acked bool
+}
+
+// Starts a bot, which keeps reconnecting to the proxy until it is closed
+func NewBot(config *Config, userId uint64, stats *Stats) (*Bot, error) {
+	// Note: The bots need to be long lived, so the token never expires before the run ends
+	token, err := auth.NewToken(config.TokenSecret, userId, time.Now().Add(24 * time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(config.Seed + int64(userId)))
+	var driver Driver = NewRandomWalk(rng)
+	if len(config.Script) > 0 {
+		driver = NewScript(config.Script)
+	}
+
+	b := &Bot{
+		UserId: userId,
+		config: config,
+		stats: stats,
+		driver: driver,
+		rng: rng,
+		token: token,
+		done: make(chan struct{}),
+		pending: make([]pendingInput, 0),
+	}
+
+	connReady := make(chan struct{})
+	proxyNet := transport.Config{
+		Url: config.ProxyUrl,
+		Serdes: serdes.New(),
+		TlsConfig: &tls.Config{
+			InsecureSkipVerify: config.Insecure,
+		},
+		ReconnectHandler: func(sock transport.Socket) error {
+			<-connReady // Note: The reconnect loop starts inside of Dial, so wait for the conn to get created below
+			return b.serve()
+		},
+	}
+
+	sock, err := proxyNet.Dial()
+	if err != nil {
+		return nil, err
+	}
+	b.sock = sock
+	b.conn = reliable.NewConn(sock, reliable.DefaultConfig())
+	close(connReady)
+
+	go b.sendLoop()
+	return b, nil
+}
+
+func (b *Bot) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		err := b.conn.Close()
+		if err != nil {
+			log.Debug().Err(err).Uint64("UserId", b.UserId).Msg("Failed to close bot")
+		}
+	})
+}
+
+func (b *Bot) LoggedIn() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loggedIn
+}
+
+// Logs in and handles everything that the proxy sends until the connection breaks
+func (b *Bot) serve() error {
+	b.stats.connect()
+	defer func() {
+		b.mu.Lock()
+		loggedIn, rejected, kicked := b.loggedIn, b.rejected, b.kicked
+		b.loggedIn = false
+		b.mu.Unlock()
+		b.stats.disconnect(loggedIn, rejected, kicked)
+	}()
+
+	// The proxy starts a new channel for every connection
+	b.conn.Reset()
+	err := b.conn.Send(serdes.NewHello())
+	if err != nil {
+		return err
+	}
+	err = b.conn.Send(serdes.ClientAuth{b.token})
+	if err != nil {
+		return err
+	}
+
+	// The server delta encodes updates against snapshots that we have acknowledged, so we start fresh every connection
+	snapshots := serdes.NewSnapshotBuffer(mmo.MaxSnapshotAge)
+	b.mu.Lock()
+	b.acked = false
+	b.mu.Unlock()
+
+	for {
+		msg, err := b.conn.Recv()
+		if errors.Is(err, net.ErrNetwork) {
+			log.Debug().Err(err).Uint64("UserId", b.UserId).Msg("Bot disconnected")
+			return err
+		} else if errors.Is(err, net.ErrSerdes) {
+			log.Debug().Err(err).Uint64("UserId", b.UserId).Msg("Bot SerdesErr")
+			continue
+		} else if err != nil {
+			return err // Note: Anything else is unexpected, so start over instead of spinning on it
+		}
+		if msg == nil { continue }
+
+		switch t := msg.(type) {
+		case serdes.WorldUpdate:
+			err := snapshots.Decode(&t)
+			if err != nil {
+				b.stats.update(true, nil)
+				continue
+			}
+			b.stats.update(false, b.handleUpdate(t))
+
+		case serdes.ClientLoginResp:
+			// Note: This also arrives after a zone transfer or a server restart, which means we are talking to a new server and the old baselines are useless
+			snapshots = serdes.NewSnapshotBuffer(mmo.MaxSnapshotAge)
+			b.mu.Lock()
+			alreadyLoggedIn := b.loggedIn
+			b.loggedIn = true
+			b.pending = b.pending[:0]
+			b.acked = false
+			b.mu.Unlock()
+			b.stats.login(alreadyLoggedIn)
+
+		case serdes.ChatMessage:
+			b.stats.chat(false, true, false)
+		case serdes.ChatReject:
+			b.stats.chat(false, false, true)
+
+		case serdes.UserKick:
+			return b.kick(t.Reason)
+		case serdes.HelloReject:
+			return b.reject(fmt.Errorf("Hello rejected: %s", t.Reason))
+		case serdes.ClientAuthReject:
+			return b.reject(fmt.Errorf("Login rejected: %s", t.Reason))
+		}
+	}
+}
+
+// Stops the bot for good, because reconnecting would just get rejected again
+func (b *Bot) reject(err error) error {
+	log.Warn().Err(err).Uint64("UserId", b.UserId).Msg("Bot rejected")
+	b.mu.Lock()
+	b.rejected = true
+	b.mu.Unlock()
+	go b.Close() // Note: This runs inside of the reconnect handler, so close it from the outside
+	return err
+}
+
+// Stops the bot for good, like a player that got kicked would. The proxy closes the connection after the kick anyway
+func (b *Bot) kick(reason string) error {
+	log.Warn().Str("Reason", reason).Uint64("UserId", b.UserId).Msg("Bot kicked")
+	b.mu.Lock()
+	loggedIn := b.loggedIn
+	b.loggedIn = false
+	b.kicked = true
+	b.mu.Unlock()
+	b.stats.kick(loggedIn)
+	go b.Close()
+	return fmt.Errorf("Kicked: %s", reason)
+}
+
+// Acks the update and returns the round trip times of every input that the server processed since the last one
+func (b *Bot) handleUpdate(update serdes.WorldUpdate) []time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ackTick = update.Tick
+	b.acked = true
+
+	now := time.Now()
+	rtts := make([]time.Duration, 0)
+	cut := 0
+	for cut < len(b.pending) && mmo.TickDiff(b.pending[cut].tick, update.PlayerTick) <= 0 {
+		rtts = append(rtts, now.Sub(b.pending[cut].sent))
+		cut++
+	}
+	b.pending = b.pending[:copy(b.pending, b.pending[cut:])]
+	return rtts
+}
+
+// Sends an input every network tick, like the client does, and chats now and then
+func (b *Bot) sendLoop() {
+	ticker := time.NewTicker(mmo.NetworkTickDivider * mmo.FixedTimeStep)
+	defer ticker.Stop()
+
+	nextChat := time.Now().Add(b.chatDelay())
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !b.sock.IsConnected() { continue }
+
+		b.mu.Lock()
+		if !b.loggedIn {
+			b.mu.Unlock()
+			continue
+		}
+		input := b.driver.Next()
+		b.playerTick = (b.playerTick + 1) % mmo.TickModulus
+		b.pending = append(b.pending, pendingInput{b.playerTick, input, time.Now()})
+		if len(b.pending) > maxPendingInputs {
+			b.pending = b.pending[:copy(b.pending, b.pending[1:])]
+		}
+
+		// Note: Like the client, every message repeats the inputs that the server hasn't acknowledged, so the server can fill in any that were lost
+		start := len(b.pending) - mmo.InputRedundancy
+		if start < 0 { start = 0 }
+		inputs := make([]serdes.TickInput, 0, len(b.pending) - start)
+		for _, p := range b.pending[start:] {
+			inputs = append(inputs, serdes.TickInput{p.tick, p.input})
+		}
+		update := serdes.PlayerInput{
+			AckTick: b.ackTick,
+			RenderTick: b.ackTick, // Note: Bots don't interpolate, so they see the newest tick
+			Acked: b.acked,
+			Inputs: inputs,
+		}
+		b.mu.Unlock()
+
+		err := b.conn.Send(update)
+		if err != nil {
+			log.Debug().Err(err).Uint64("UserId", b.UserId).Msg("Bot failed to send input")
+			continue
+		}
+		b.stats.inputSent()
+
+		if b.config.ChatInterval > 0 && time.Now().After(nextChat) {
+			nextChat = time.Now().Add(b.chatDelay())
+			err := b.conn.Send(serdes.ChatMessage{
+				Channel: mmo.ChannelSay,
+				Text: chatLines[b.rng.Intn(len(chatLines))],
+			})
+			if err != nil {
+				log.Debug().Err(err).Uint64("UserId", b.UserId).Msg("Bot failed to send chat")
+				continue
+			}
+			b.stats.chat(true, false, false)
+		}
+	}
+}
+
+// Returns the time until the next chat message, spread out so that the bots don't all talk at once
+func (b *Bot) chatDelay() time.Duration {
+	if b.config.ChatInterval <= 0 {
+		return 0
+	}
+	return b.config.ChatInterval/2 + time.Duration(b.rng.Int63n(int64(b.config.ChatInterval)))
+}
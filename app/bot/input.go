@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"math/rand"
+
+	"github.com/unitoftime/mmo"
+)
+
+// Decides what a bot presses on each network tick
+type Driver interface {
+	Next() mmo.Input
+}
+
+// Walks in a random direction (or stands still) for a random number of network ticks, then picks again
+type RandomWalk struct {
+	MinTicks, MaxTicks int
+	rng *rand.Rand
+	input mmo.Input
+	left int // The number of ticks until we pick a new direction
+}
+
+func NewRandomWalk(rng *rand.Rand) *RandomWalk {
+	return &RandomWalk{
+		MinTicks: 8,
+		MaxTicks: 64,
+		rng: rng,
+	}
+}
+
+func (w *RandomWalk) Next() mmo.Input {
+	if w.left <= 0 {
+		w.input = mmo.Input{}
+		switch w.rng.Intn(5) {
+		case 0: w.input.Up = true
+		case 1: w.input.Down = true
+		case 2: w.input.Left = true
+		case 3: w.input.Right = true
+		// case 4: Stand still
+		}
+		w.left = w.MinTicks + w.rng.Intn(w.MaxTicks - w.MinTicks + 1)
+	}
+	w.left--
+	return w.input
+}
+
+// Repeats the same inputs forever
+type Script struct {
+	Inputs []mmo.Input
+	step int
+}
+
+func NewScript(inputs []mmo.Input) *Script {
+	return &Script{Inputs: inputs}
+}
+
+func (s *Script) Next() mmo.Input {
+	if len(s.Inputs) == 0 {
+		return mmo.Input{}
+	}
+	input := s.Inputs[s.step % len(s.Inputs)]
+	s.step++
+	return input
+}
+
+// Parses a script of comma separated steps, where each step is a direction and the number of network ticks to hold it for (ie "up:10,right+down:5,stop:20")
+// Directions are up, down, left, right and stop, and can be combined with a +
+func ParseScript(script string) ([]mmo.Input, error) {
+	ret := make([]mmo.Input, 0)
+	for _, step := range strings.Split(script, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" { continue }
+
+		dir, count, found := strings.Cut(step, ":")
+		ticks := 1
+		if found {
+			var err error
+			ticks, err = strconv.Atoi(count)
+			if err != nil || ticks < 1 {
+				return nil, fmt.Errorf("Invalid tick count in script step: %s", step)
+			}
+		}
+
+		input := mmo.Input{}
+		for _, d := range strings.Split(dir, "+") {
+			switch strings.TrimSpace(d) {
+			case "up": input.Up = true
+			case "down": input.Down = true
+			case "left": input.Left = true
+			case "right": input.Right = true
+			case "stop":
+			default:
+				return nil, fmt.Errorf("Invalid direction in script step: %s", step)
+			}
+		}
+
+		for i := 0; i < ticks; i++ {
+			ret = append(ret, input)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("Script is empty")
+	}
+	return ret, nil
+}
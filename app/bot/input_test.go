@@ -0,0 +1,43 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/unitoftime/mmo"
+)
+
+func TestParseScript(t *testing.T) {
+	inputs, err := ParseScript("up:2, right+down:1,stop")
+	if err != nil { panic(err) }
+
+	expected := []mmo.Input{
+		{Up: true},
+		{Up: true},
+		{Right: true, Down: true},
+		{},
+	}
+	if len(inputs) != len(expected) {
+		t.Fatalf("Expected %d inputs, got %d", len(expected), len(inputs))
+	}
+	for i := range expected {
+		if inputs[i] != expected[i] {
+			t.Errorf("Input %d: Expected %+v, got %+v", i, expected[i], inputs[i])
+		}
+	}
+
+	// The script repeats once it runs out
+	script := NewScript(inputs)
+	for i := 0; i < 2 * len(inputs); i++ {
+		input := script.Next()
+		if input != expected[i % len(expected)] {
+			t.Errorf("Step %d: Expected %+v, got %+v", i, expected[i % len(expected)], input)
+		}
+	}
+
+	for _, bad := range []string{"", "up:0", "up:x", "sideways:1"} {
+		_, err := ParseScript(bad)
+		if err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The counters that every bot adds to. Counts are totals since the run started, except for the round trip times and updates, which are since the last report
+type Stats struct {
+	mu sync.Mutex
+	start time.Time
+	lastReport time.Time
+
+	connects int // Connections to the proxy, including reconnects
+	logins int // Includes the logins after a zone transfer or a server restart
+	disconnects int // Connections that broke after they were made
+	rejects int // Hello or login rejections, the bot gives up afterwards
+	kicks int // Kicks by an admin, the bot gives up afterwards
+
+	inputsSent, chatSent, chatReceived, chatRejected int
+	updates, totalUpdates, droppedUpdates int // Dropped updates couldn't be decoded (ie their baseline was lost)
+	rtts []time.Duration
+	connected, loggedIn int // The bots that are connected and logged in right now
+}
+
+func NewStats() *Stats {
+	now := time.Now()
+	return &Stats{
+		start: now,
+		lastReport: now,
+		rtts: make([]time.Duration, 0),
+	}
+}
+
+// The statistics of every bot together
+type Report struct {
+	Elapsed time.Duration // Since the run started
+	Interval time.Duration // Since the last report
+
+	Connected, LoggedIn int
+	Connects, Logins, Disconnects, Rejects, Kicks int
+	InputsSent, ChatSent, ChatReceived, ChatRejected int
+
+	Updates, DroppedUpdates int
+	UpdateRate float64 // The world updates per second that each logged in bot received over the interval
+
+	RttSamples int // The inputs that the server acknowledged over the interval
+	RttP50, RttP95, RttP99, RttMax time.Duration
+}
+
+func (s *Stats) connect() {
+	s.mu.Lock()
+	s.connects++
+	s.connected++
+	s.mu.Unlock()
+}
+
+// Called once a connection breaks. Rejected and kicked connections don't count as disconnects
+func (s *Stats) disconnect(loggedIn, rejected, kicked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected--
+	if loggedIn {
+		s.loggedIn--
+	}
+	if rejected {
+		s.rejects++
+	} else if !kicked {
+		s.disconnects++
+	}
+}
+
+// Called when the bot gets a login response. The bot might already be logged in if it was transferred to a new zone
+func (s *Stats) login(alreadyLoggedIn bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins++
+	if !alreadyLoggedIn {
+		s.loggedIn++
+	}
+}
+
+// Called when the bot gets kicked. The bot is logged out right away, so disconnect doesn't count it again
+func (s *Stats) kick(loggedIn bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kicks++
+	if loggedIn {
+		s.loggedIn--
+	}
+}
+
+func (s *Stats) update(dropped bool, rtts []time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dropped {
+		s.droppedUpdates++
+		return
+	}
+	s.updates++
+	s.totalUpdates++
+	s.rtts = append(s.rtts, rtts...)
+}
+
+func (s *Stats) inputSent() {
+	s.mu.Lock()
+	s.inputsSent++
+	s.mu.Unlock()
+}
+
+func (s *Stats) chat(sent, received, rejected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sent { s.chatSent++ }
+	if received { s.chatReceived++ }
+	if rejected { s.chatRejected++ }
+}
+
+// Returns the statistics since the last report, and starts a new interval
+func (s *Stats) Report() Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	report := Report{
+		Elapsed: now.Sub(s.start),
+		Interval: now.Sub(s.lastReport),
+
+		Connected: s.connected,
+		LoggedIn: s.loggedIn,
+		Connects: s.connects,
+		Logins: s.logins,
+		Disconnects: s.disconnects,
+		Rejects: s.rejects,
+		Kicks: s.kicks,
+		InputsSent: s.inputsSent,
+		ChatSent: s.chatSent,
+		ChatReceived: s.chatReceived,
+		ChatRejected: s.chatRejected,
+
+		Updates: s.totalUpdates,
+		DroppedUpdates: s.droppedUpdates,
+		RttSamples: len(s.rtts),
+	}
+
+	if s.loggedIn > 0 && report.Interval > 0 {
+		report.UpdateRate = float64(s.updates) / report.Interval.Seconds() / float64(s.loggedIn)
+	}
+
+	if len(s.rtts) > 0 {
+		sort.Slice(s.rtts, func(i, j int) bool { return s.rtts[i] < s.rtts[j] })
+		percentile := func(p float64) time.Duration {
+			return s.rtts[int(p * float64(len(s.rtts)-1))]
+		}
+		report.RttP50 = percentile(0.50)
+		report.RttP95 = percentile(0.95)
+		report.RttP99 = percentile(0.99)
+		report.RttMax = s.rtts[len(s.rtts)-1]
+	}
+
+	s.lastReport = now
+	s.updates = 0
+	s.rtts = s.rtts[:0]
+	return report
+}
+
+func (r Report) Log(msg string) {
+	log.Info().
+		Dur("Elapsed", r.Elapsed).
+		Int("Connected", r.Connected).
+		Int("LoggedIn", r.LoggedIn).
+		Int("Connects", r.Connects).
+		Int("Logins", r.Logins).
+		Int("Disconnects", r.Disconnects).
+		Int("Rejects", r.Rejects).
+		Int("Kicks", r.Kicks).
+		Float64("UpdateRate", r.UpdateRate).
+		Int("DroppedUpdates", r.DroppedUpdates).
+		Dur("RttP50", r.RttP50).
+		Dur("RttP95", r.RttP95).
+		Dur("RttP99", r.RttP99).
+		Dur("RttMax", r.RttMax).
+		Int("ChatSent", r.ChatSent).
+		Int("ChatReceived", r.ChatReceived).
+		Int("ChatRejected", r.ChatRejected).
+		Msg(msg)
+}
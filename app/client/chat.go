@@ -0,0 +1,99 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"strings"
+	"strconv"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// The number of chat messages that the client remembers
+const chatHistorySize = 50
+
+// Stores the most recent chat messages that the client has received
+type ChatHistory struct {
+	mu sync.RWMutex
+	messages []serdes.ChatMessage
+}
+
+func NewChatHistory() *ChatHistory {
+	return &ChatHistory{
+		messages: make([]serdes.ChatMessage, 0, chatHistorySize),
+	}
+}
+
+func (h *ChatHistory) Add(msg serdes.ChatMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.messages) >= chatHistorySize {
+		copy(h.messages, h.messages[1:])
+		h.messages = h.messages[:len(h.messages)-1]
+	}
+	h.messages = append(h.messages, msg)
+}
+
+// Adds a message that only this client sees (ie command usage errors)
+func (h *ChatHistory) System(text string) {
+	h.Add(serdes.ChatMessage{
+		Channel: mmo.ChannelSystem,
+		Text: text,
+	})
+}
+
+// Returns up to the last n messages, oldest first
+func (h *ChatHistory) Last(n int) []serdes.ChatMessage {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if n > len(h.messages) {
+		n = len(h.messages)
+	}
+	ret := make([]serdes.ChatMessage, n)
+	copy(ret, h.messages[len(h.messages)-n:])
+	return ret
+}
+
+// Returns the line that is shown in the chat history for the message
+func FormatChat(msg serdes.ChatMessage) string {
+	switch msg.Channel {
+	case mmo.ChannelSay:
+		return fmt.Sprintf("%d: %s", msg.From, msg.Text)
+	case mmo.ChannelWhisper:
+		return fmt.Sprintf("[Whisper] %d -> %d: %s", msg.From, msg.Target, msg.Text)
+	case mmo.ChannelSystem:
+		return fmt.Sprintf("[System] %s", msg.Text)
+	}
+	return fmt.Sprintf("[%s] %d: %s", msg.Channel, msg.From, msg.Text)
+}
+
+// Parses the chat commands for the non-say channels:
+//   /g <message>
+//   /w <user id> <message>
+// Returns false if the text isn't a chat command, and an error if it is but was used incorrectly
+func ParseChatCommand(text string) (serdes.ChatMessage, bool, error) {
+	command, rest, _ := strings.Cut(text, " ")
+	switch command {
+	case "/g", "/global":
+		if rest == "" {
+			return serdes.ChatMessage{}, true, fmt.Errorf("Usage: /g <message>")
+		}
+		return serdes.ChatMessage{
+			Channel: mmo.ChannelGlobal,
+			Text: rest,
+		}, true, nil
+	case "/w", "/whisper":
+		target, msg, _ := strings.Cut(rest, " ")
+		targetId, err := strconv.ParseUint(target, 10, 64)
+		if err != nil || msg == "" {
+			return serdes.ChatMessage{}, true, fmt.Errorf("Usage: /w <user id> <message>")
+		}
+		return serdes.ChatMessage{
+			Channel: mmo.ChannelWhisper,
+			Target: targetId,
+			Text: msg,
+		}, true, nil
+	}
+	return serdes.ChatMessage{}, false, nil
+}
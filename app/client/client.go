@@ -5,15 +5,13 @@ package client
 import (
 	"os"
 	"time"
-	// "fmt"
+	"fmt"
 	"embed"
 	// "math"
 	"strings"
 	"flag"
 	"crypto/tls"
 
-	"github.com/zyedidia/generic/queue"
-
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
@@ -27,10 +25,13 @@ import (
 	"github.com/unitoftime/flow/render"
 	"github.com/unitoftime/flow/phy2"
 	"github.com/unitoftime/flow/tile"
-	"github.com/unitoftime/flow/net"
 
 	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/netcode"
+	"github.com/unitoftime/mmo/reliable"
+	"github.com/unitoftime/mmo/transport"
+	"github.com/unitoftime/mmo/app/client/session"
 )
 
 //go:embed assets/*
@@ -38,10 +39,12 @@ var fs embed.FS
 
 type Config struct {
 	ProxyUri string
+	Token string // The signed login token that is sent to the proxy
 	Test bool
 }
 
 var skipMenu = flag.Bool("skip", false, "skip the login menu (for testing)")
+var loginToken = flag.String("token", "", "the login token to use (overrides the config)")
 
 var globalConfig Config
 func Main(config Config) {
@@ -51,6 +54,9 @@ func Main(config Config) {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
 	flag.Parse()
+	if *loginToken != "" {
+		globalConfig.Token = *loginToken
+	}
 
 	glitch.Run(launch)
 }
@@ -170,7 +176,15 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 	networkChannel := make(chan serdes.WorldUpdate, 1024) // TODO - arbitrary 1024
 
 	// This is the player's ID, by default we set this to invalid
-	playerData := NewPlayerData()
+	playerData := session.NewPlayerData()
+	chat := NewChatHistory()
+
+	// Corrects the player's predicted position when the server disagrees with it
+	reconciler := netcode.NewReconciler(netcode.ReconcileConfig{
+		SnapThreshold: 2 * 16, // Two tiles
+		SmoothDuration: 250 * time.Millisecond,
+		TickDuration: time.Duration(mmo.NetworkTickDivider) * mmo.FixedTimeStep,
+	})
 
 	// TODO - Do this for local testing (Right now I'm doing insecure skip verify)
 	// Ref: https://github.com/jcbsmpsn/golang-https-example
@@ -184,14 +198,29 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 	// 	RootCAs: caCertPool,
 	// }
 
-	proxyNet := net.Config{
+	// Note: The hello, logins and chat go over the reliable channel, so that they survive packet loss (See: serdes.IsReliable)
+	var conn *reliable.Conn
+	connReady := make(chan struct{})
+	proxyNet := transport.Config{
 		Url: globalConfig.ProxyUri,
 		Serdes: serdes.New(),
 		TlsConfig: &tls.Config{
 			InsecureSkipVerify: globalConfig.Test, // If test mode, then we don't care about the cert
 		},
-		ReconnectHandler: func(sock *net.Socket) error {
-			return ClientReceive(sock, playerData, networkChannel)
+		ReconnectHandler: func(sock transport.Socket) error {
+			<-connReady // Note: The reconnect loop starts inside of Dial, so wait for the conn to get created below
+
+			// The proxy starts a new channel for every connection
+			conn.Reset()
+			err := conn.Send(serdes.NewHello())
+			if err != nil {
+				return err
+			}
+			err = conn.Send(serdes.ClientAuth{globalConfig.Token})
+			if err != nil {
+				return err
+			}
+			return ClientReceive(conn, playerData, networkChannel, chat)
 		},
 	}
 
@@ -199,7 +228,8 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 	if err != nil {
 		panic(err)
 	}
-	sock.Packetloss = 0.0
+	conn = reliable.NewConn(sock, reliable.DefaultConfig())
+	close(connReady)
 
 	// Note: This requires a system to update the framebuffer if the window is resized. The system should essentially recreate the framebuffer with the new dimensions, This might be a good target for the framebuffer callback, but for now I'm just going to poll win.Bounds
 	renderBounds := win.Bounds()
@@ -211,7 +241,8 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 	pass.SoftwareSort = glitch.SoftwareSortY
 	tilemapPass := glitch.NewRenderPass(shader)
 
-	tilemap := mmo.LoadGame(world)
+	loadedZone := mmo.DefaultZone
+	tilemap := mmo.LoadZone(world, loadedZone)
 
 	grassTile, err := spritesheet.Get("grass0.png")
 	if err != nil { panic(err) }
@@ -229,11 +260,26 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 		mmo.DirtTile: dirtTile,
 		mmo.WaterTile: waterTile,
 		mmo.ConcreteTile: concreteTile,
+		mmo.PortalTile: waterTile, // TODO - Needs a portal sprite
 	}, tilemapPass)
 
 	tmapRender.Clear()
 	tmapRender.Batch(tilemap)
 
+	// Replaces everything in the world with the new zone
+	// Note: The tilemap is overwritten in place because the systems hold onto the pointer
+	loadZone := func(zone mmo.ZoneId) {
+		if zone == loadedZone { return }
+		log.Print("Loading Zone: ", zone)
+		loadedZone = zone
+
+		*world = *ecs.NewWorld()
+		*tilemap = *mmo.LoadZone(world, zone)
+
+		tmapRender.Clear()
+		tmapRender.Batch(tilemap)
+	}
+
 	debugMode := false
 
 	textInputMode := false
@@ -246,18 +292,33 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 	camera := render.NewCamera(win.Bounds(), 0, 0)
 	camera.Zoom = 2.0
 
-	updateQueue := queue.New[serdes.WorldUpdate]()
+	// Other entities are rendered a little bit in the past, so that they can be interpolated between the server's snapshots
+	// Note: The delay grows and shrinks with the jitter and packet loss of the connection
+	snapshots := netcode.NewInterpBuffer[serdes.WorldUpdate](netcode.InterpConfig{
+		Jitter: netcode.JitterConfig{
+			TickDuration: time.Duration(mmo.NetworkTickDivider) * mmo.FixedTimeStep,
+			MinSize: mmo.ClientDefaultUpdateQueueSize,
+			MaxSize: 8, // ~500ms
+			JitterScale: 2,
+			LossScale: 10,
+			ShrinkAfter: 5 * time.Second,
+		},
+		Capacity: 64,
+	})
 
 	quit := ecs.Signal{}
 	quit.Set(false)
 
+	// Note: This is created before the systems, so that the debug overlay can show the profile
+	schedule := mmo.GetScheduler()
+
 	inputSystems := []ecs.System{
-		ClientPollNetworkSystem(networkChannel, updateQueue),
-		ClientPullFromUpdateQueue(world, updateQueue, playerData),
+		ClientPollNetworkSystem(world, networkChannel, snapshots, playerData, loadZone),
+		ClientPlaySnapshots(world, snapshots, playerData, loadZone),
 		ecs.System{"ManageEntityTimeout", func(dt time.Duration) {
 			timeout := 5 * time.Second
 			now := time.Now()
-			ecs.Map(world, func(id ecs.Id, lastUpdate *LastUpdate) {
+			ecs.Map(world, func(id ecs.Id, lastUpdate *session.LastUpdate) {
 				if now.Sub(lastUpdate.Time) > timeout {
 					ecs.Delete(world, id)
 				}
@@ -339,7 +400,21 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 			}
 		}},
 		ecs.System{"SetAnimationFromState", func(dt time.Duration) {
+			playerId := playerData.Id()
 			ecs.Map2(world, func(id ecs.Id, pos *phy2.Pos, netPos *NetPos) {
+				// The player's position comes from reconciling their prediction with the server
+				if id == playerId {
+					*pos = reconciler.Step(dt)
+					return
+				}
+
+				// Everyone else is interpolated between the server's snapshots
+				interpPos, ok := snapshots.Position(id)
+				if ok {
+					*pos = interpPos
+					return
+				}
+
 				// Option 1
 /*
 				netPos.Remaining -= dt
@@ -396,7 +471,7 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 		}},
 	}
 
-	physicsSystems := CreateClientSystems(world, sock, playerData, tilemap)
+	physicsSystems := CreateClientSystems(world, sock, conn, playerData, reconciler, tilemap)
 
 	panelSprite, err := spritesheet.GetNinePanel("ui_panel0.png", glitch.R(2, 2, 2, 2))
 	if err != nil { panic(err) }
@@ -582,7 +657,7 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 				connectedRect := win.Bounds()
 				connectedRect = connectedRect.Pad(paddingRect)
 				textScale := float32(0.5)
-				if sock.Connected.Load() {
+				if sock.IsConnected() {
 					group.SetColor(glitch.RGBA{0, 1, 0, 1})
 					group.FixedText("Connected", connectedRect, glitch.Vec2{1, 0}, textScale)
 					rtt := playerData.RoundTripTimes()
@@ -597,12 +672,42 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 					if debugMode {
 						group.SetColor(glitch.RGBA{0, 0, 1, 1})
 						group.LineGraph(rttRect, rttPoints)
+
+						stats := reconciler.Stats()
+						statsRect := rttRect.Moved(glitch.Vec2{0, -rttRect.H()})
+						group.SetColor(glitch.RGBA{1, 1, 1, 1})
+						group.FixedText(fmt.Sprintf("Prediction Error: %.1f (Avg %.1f, Max %.1f, Snaps %d)", stats.Last, stats.Avg, stats.Max, stats.Snaps), statsRect, glitch.Vec2{1, 1}, textScale)
+
+						interpStats := snapshots.Stats()
+						interpRect := statsRect.Moved(glitch.Vec2{0, -atlas.LineHeight() * textScale})
+						group.FixedText(fmt.Sprintf("Update Queue: %d/%d (Jitter %s, Loss %.0f%%, Underruns %d)", interpStats.Buffered, interpStats.Target, interpStats.Jitter.Round(time.Millisecond), 100 * interpStats.Loss, interpStats.Underruns), interpRect, glitch.Vec2{1, 1}, textScale)
+
+						profile := schedule.Profiler.Report()
+						tickRect := interpRect.Moved(glitch.Vec2{0, -atlas.LineHeight() * textScale})
+						group.FixedText(fmt.Sprintf("Tick: %s p50, %s p99 (Overruns %d/%d)", profile.Tick.P50, profile.Tick.P99, profile.Overruns, profile.Ticks), tickRect, glitch.Vec2{1, 1}, textScale)
+						slowest, ok := profile.Slowest()
+						if ok {
+							slowestRect := tickRect.Moved(glitch.Vec2{0, -atlas.LineHeight() * textScale})
+							group.FixedText(fmt.Sprintf("Slowest: %s (%s p50, %s p99)", slowest.Name, slowest.P50, slowest.P99), slowestRect, glitch.Vec2{1, 1}, textScale)
+						}
 					}
 				} else {
 					group.SetColor(glitch.RGBA{1, 0, 0, 1})
 					group.FixedText("Disconnected", connectedRect, glitch.Vec2{1, 0}, textScale)
 				}
 
+				// Draw the chat history, newest message on the bottom
+				{
+					chatLines := chat.Last(8)
+					lineHeight := atlas.LineHeight() * textScale
+					group.SetColor(glitch.RGBA{1, 1, 1, 1})
+					for i := range chatLines {
+						line := chatLines[len(chatLines) - 1 - i]
+						lineRect := connectedRect.Moved(glitch.Vec2{0, float32(i) * lineHeight})
+						group.FixedText(FormatChat(line), lineRect, glitch.Vec2{0, 0}, textScale)
+					}
+				}
+
 				if !textInputMode && win.JustPressed(glitch.KeyEnter) {
 					textInputMode = true
 				} else if !textInputMode && win.JustPressed(glitch.KeySlash) {
@@ -619,18 +724,27 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 					group.TextInput(panelSprite, &textInputString, inputRect, glitch.Vec2{0.5, 0.5}, textScale)
 					if win.JustPressed(glitch.KeyEnter) {
 						if strings.HasPrefix(textInputString, "/") {
-							if strings.HasPrefix(textInputString, "/debug") {
+							if msg, ok, err := ParseChatCommand(textInputString); ok {
+								if err != nil {
+									chat.System(err.Error())
+								} else {
+									err := conn.Send(msg)
+									if err != nil {
+										log.Warn().Err(err).Msg("Failed to send chat")
+									}
+								}
+							} else if strings.HasPrefix(textInputString, "/debug") {
 								debugMode = !debugMode
 							} else if strings.HasPrefix(textInputString, "/sim i") {
-								sock.Packetloss = 0.15
+								conn.SetPacketloss(0.15)
 								// sock.MinDelay = 160 * time.Millisecond
 								// sock.MaxDelay = 240 * time.Millisecond
 							} else if strings.HasPrefix(textInputString, "/sim l") {
-								sock.Packetloss = 0.05
+								conn.SetPacketloss(0.05)
 								// sock.MinDelay = 25 * time.Millisecond
 								// sock.MaxDelay = 50 * time.Millisecond
 							} else if strings.HasPrefix(textInputString, "/sim n") {
-								sock.Packetloss = 0.0
+								conn.SetPacketloss(0.0)
 								// sock.MinDelay = 0
 								// sock.MaxDelay = 0
 							}
@@ -652,8 +766,6 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 		}},
 	}
 
-	schedule := mmo.GetScheduler()
-
 	// physicsSystems = append(physicsSystems, ecs.System{"UpdateWindow", func(dt time.Duration) {
 	// 	syslog := schedule.Syslog()
 	// 	for i := range syslog {
@@ -669,5 +781,5 @@ func runGame(win *glitch.Window, load *asset.Load, spritesheet *asset.Spriteshee
 	log.Print("Finished ecs.RunGame")
 
 	// TODO - I'm not sure if this is the proper way to close because `ClientReceive` is still reading, so closing here will cause that to fail
-	sock.Close()
+	conn.Close()
 }
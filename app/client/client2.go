@@ -1,6 +1,7 @@
 package client
 
 import (
+	"fmt"
 	"time"
 	// "math"
 	"errors"
@@ -17,6 +18,10 @@ import (
 
 	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/netcode"
+	"github.com/unitoftime/mmo/reliable"
+	"github.com/unitoftime/mmo/transport"
+	"github.com/unitoftime/mmo/app/client/session"
 )
 
 // This is mostly for debug, but maybe its a good thing to track
@@ -34,15 +39,18 @@ type NetPos struct {
 	ExtrapolatedPos, PreExtInterpTo phy2.Pos // The interpolation destination before the extrap value was added
 }
 
-func CreateClientSystems(world *ecs.World, sock *net.Socket, playerData *PlayerData, tilemap *tile.Tilemap) []ecs.System {
+func CreateClientSystems(world *ecs.World, sock transport.Socket, conn *reliable.Conn, playerData *session.PlayerData, reconciler *netcode.Reconciler, tilemap *tile.Tilemap) []ecs.System {
+	reconciledSession := uint64(0)
 	clientSystems := []ecs.System{
 		ecs.System{"ClientSendUpdate", func(dt time.Duration) {
-			ClientSendUpdate(world, sock, playerData)
+			ClientSendUpdate(world, sock, conn, playerData)
 		}},
 		ecs.System{"InterpolateSpritePositions", func(dt time.Duration) {
 			// TODO - hack. We needed a way to create the transform component for other players (because we did a change which makes us set NextTransform over the wire instead of transform. So those were never being set
 
 			playerId := playerData.Id()
+			reconciledSession = session.ClearReconciler(playerData, reconciler, reconciledSession)
+
 			ecs.Map(world, func(id ecs.Id, serverTransform *ServerTransform) {
 				pos, ok := ecs.Read[phy2.Pos](world, id)
 				if !ok {
@@ -78,11 +86,17 @@ func CreateClientSystems(world *ecs.World, sock *net.Socket, playerData *PlayerD
 						collider, ok := ecs.Read[phy2.CircleCollider](world, playerId)
 						if !ok { return } // Skip if player doesn't have a collider
 
+						// Note: The input buffer only holds the inputs after the one that the server last applied
+						tick := serverTransform.PlayerTick
 						for i := range inputBuffer {
 							for ii := 0; ii < mmo.NetworkTickDivider; ii++ {
 							mmo.MoveCharacter(&inputBuffer[i].Input, &netPos.ExtrapolatedPos, &collider, tilemap, mmo.FixedTimeStep)
 							}
+							tick = (tick + 1) % mmo.TickModulus
+							reconciler.Predict(tick, netPos.ExtrapolatedPos)
 						}
+
+						reconciler.Reconcile(serverTransform.PlayerTick, serverTransform.Pos, netPos.ExtrapolatedPos)
 					}
 				}
 
@@ -108,7 +122,7 @@ func CreateClientSystems(world *ecs.World, sock *net.Socket, playerData *PlayerD
 }
 
 var everyOther int
-func ClientSendUpdate(world *ecs.World, clientConn *net.Socket, playerData *PlayerData) {
+func ClientSendUpdate(world *ecs.World, sock transport.Socket, clientConn *reliable.Conn, playerData *session.PlayerData) {
 	// TODO! - Not sure if this is okay
 	everyOther = (everyOther + 1) % mmo.NetworkTickDivider
 	if everyOther != 0 {
@@ -118,58 +132,46 @@ func ClientSendUpdate(world *ecs.World, clientConn *net.Socket, playerData *Play
 	playerId := playerData.Id()
 	// if clientConn is closed for some reason, then we won't be able to send
 	// TODO - With the atomic this fast enough?
-	connected := clientConn.Connected.Load()
+	connected := sock.IsConnected()
 	if !connected { return } // Exit early because we are not connected
 
 	input, ok := ecs.Read[mmo.Input](world, playerId)
 	if !ok { return } // If we can't find the players input just exit early
 
-	playerTick := playerData.AppendInputTick(input)
+	playerData.AppendInputTick(input)
+	ackTick, acked := playerData.AckTick()
+	renderTick, rendering := playerData.RenderTick()
+	if !rendering {
+		renderTick = ackTick // Note: We haven't started rendering yet, so the best guess is the newest tick
+	}
 
-	compSlice := []ecs.Component{
-		ecs.C(input),
+	// Note: Every message repeats the inputs that the server hasn't acknowledged, so the server can fill in any that were lost
+	update := serdes.PlayerInput{
+		AckTick: ackTick,
+		RenderTick: renderTick,
+		Acked: acked,
+		Inputs: playerData.UnackedInputs(mmo.InputRedundancy),
 	}
+	// log.Print("ClientSendUpdate:", update)
 
-	// lastMsg := playerData.GetLastMessage()
-	// // log.Print(lastMsg)
-	// var messages []mmo.ChatMessage
-	// if lastMsg != nil {
-	// 	messages = []mmo.ChatMessage{
-	// 		mmo.ChatMessage{
-	// 			Username: "", // Note: Can't trust the username that the client sends
-	// 			Message: lastMsg.Message,
-	// 		},
-	// 	}
-	// }
+	err := clientConn.Send(update)
+	if err != nil {
+		log.Warn().Err(err).Msg("ClientSendUpdate")
+	}
 
 	// If we can't find a speech, that's okay
 	speech, speechFound := ecs.Read[mmo.Speech](world, playerId)
 	if speechFound {
 		if speech.HandleSent() {
-			compSlice = append(compSlice, ecs.C(speech))
 			ecs.Write(world, playerId, ecs.C(speech))
-		}
-	}
-
-	// log.Print(messages)
 
-	update := serdes.WorldUpdate{
-		PlayerTick: playerTick,
-		WorldData: map[ecs.Id][]ecs.Component{
-			playerId: compSlice,
-		},
-		// Messages: messages,
-	}
-	// log.Print("ClientSendUpdate:", update)
-
-	// log.Print(update)
-
-	// Duplicate Sends to counter packet loss
-	// TODO - Maybe make this more intricate, send the last N inputs in one big packet at 1/N the rate
-	for i := 0; i < mmo.ClientInputResendRate; i++ {
-		err := clientConn.Send(update)
-		if err != nil {
-			log.Warn().Err(err).Msg("ClientSendUpdate")
+			err := clientConn.Send(serdes.ChatMessage{
+				Channel: mmo.ChannelSay,
+				Text: speech.Text,
+			})
+			if err != nil {
+				log.Warn().Err(err).Msg("ClientSendUpdate: Chat")
+			}
 		}
 	}
 
@@ -189,7 +191,7 @@ func ClientSendUpdate(world *ecs.World, clientConn *net.Socket, playerData *Play
 }
 
 var AvgWorldUpdateTime time.Duration
-func ClientReceive(sock *net.Socket, playerData *PlayerData, networkChannel chan serdes.WorldUpdate) error {
+func ClientReceive(conn *reliable.Conn, playerData *session.PlayerData, networkChannel chan serdes.WorldUpdate, chat *ChatHistory) error {
 	// lastWorldUpdate := time.Now()
 	bufLen := 100
 	worldUpdateTimes := ds.NewRingBuffer[time.Duration](bufLen)
@@ -197,8 +199,12 @@ func ClientReceive(sock *net.Socket, playerData *PlayerData, networkChannel chan
 		worldUpdateTimes.Add(mmo.NetworkTickDivider * mmo.FixedTimeStep)
 	}
 
+	// The server delta encodes updates against snapshots that we have acknowledged, so we start fresh every connection
+	snapshots := serdes.NewSnapshotBuffer(mmo.MaxSnapshotAge)
+	playerData.ClearAckTick()
+
 	for {
-		msg, err := sock.Recv()
+		msg, err := conn.Recv()
 		if errors.Is(err, net.ErrNetwork) {
 			// Handle errors where we should stop (ie connection closed or something)
 			log.Warn().Err(err).Msg("ClientReceive NetworkErr")
@@ -212,6 +218,14 @@ func ClientReceive(sock *net.Socket, playerData *PlayerData, networkChannel chan
 
 		switch t := msg.(type) {
 		case serdes.WorldUpdate:
+			// Reconstruct the full world state from our stored baseline
+			err := snapshots.Decode(&t)
+			if err != nil {
+				log.Warn().Err(err).Uint16("BaseTick", t.BaseTick).Msg("ClientReceive Dropping WorldUpdate")
+				continue
+			}
+			playerData.SetAckTick(t.Tick)
+
 			// log.Print("Ticks: ", t.Tick, t.PlayerTick)
 			// {
 			// 	worldUpdateTimes.Add(time.Since(lastWorldUpdate))
@@ -297,24 +311,49 @@ func ClientReceive(sock *net.Socket, playerData *PlayerData, networkChannel chan
 			// 	},
 			// }
 
-			playerData.SetId(t.Id)
-
-			networkChannel <- serdes.WorldUpdate{
-				UserId: t.UserId,
-				WorldData: map[ecs.Id][]ecs.Component{
-					ecs.Id(t.Id): []ecs.Component{
-						ecs.C(mmo.Input{}),
-						ecs.C(phy2.Pos{}),
-						ecs.C(Keybinds{
-							Up: glitch.KeyW,
-							Down: glitch.KeyS,
-							Left: glitch.KeyA,
-							Right: glitch.KeyD,
-						}),
-					},
-				},
+			// A login response means that we are talking to a new server (ie we were transferred to a new zone, or the server restarted), so the old baselines are useless
+			// Note: The proxy logs us back in whenever it reconnects to the server, so this can arrive at any time
+			snapshots = serdes.NewSnapshotBuffer(mmo.MaxSnapshotAge)
+			networkChannel <- session.Login(playerData, t, ecs.C(Keybinds{
+				Up: glitch.KeyW,
+				Down: glitch.KeyS,
+				Left: glitch.KeyA,
+				Right: glitch.KeyD,
+			}))
+
+		case serdes.ChatMessage:
+			chat.Add(t)
+
+		case serdes.ChatReject:
+			chat.System(fmt.Sprintf("Message not sent: %s", t.Reason))
+
+		case serdes.Hello:
+			err := serdes.CheckHello(t)
+			if err != nil {
+				// Note: Closing the socket stops the reconnect loop, because this build of the client can't talk to the proxy
+				log.Error().Err(err).Msg("Incompatible Proxy")
+				conn.Close()
+				return err
 			}
 
+		case serdes.HelloReject:
+			// Note: Closing the socket stops the reconnect loop, because this build of the client can't talk to the proxy
+			log.Error().Str("Reason", t.Reason).Msg("Proxy rejected client version, please update your client")
+			conn.Close()
+			return fmt.Errorf("Client version rejected: %s", t.Reason)
+
+		case serdes.ClientAuthReject:
+			// Note: Closing the socket stops the reconnect loop, because retrying with the same token won't help
+			log.Error().Str("Reason", t.Reason).Msg("Login Rejected")
+			conn.Close()
+			return fmt.Errorf("Login Rejected: %s", t.Reason)
+
+		case serdes.UserKick:
+			// Note: Closing the socket stops the reconnect loop, otherwise we would log right back in
+			log.Error().Str("Reason", t.Reason).Msg("Kicked")
+			conn.Close()
+			return fmt.Errorf("Kicked: %s", t.Reason)
+
 		default:
 			log.Error().Msg("Unknown message type")
 		}
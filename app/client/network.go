@@ -3,27 +3,45 @@ package client
 import (
 	"time"
 
-	"github.com/zyedidia/generic/queue"
 	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
 
 	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/netcode"
+	"github.com/unitoftime/mmo/app/client/session"
 )
 
-type LastUpdate struct {
-	Time time.Time
+// Returns the server position of every entity in the update
+func updatePositions(update serdes.WorldUpdate) map[ecs.Id]phy2.Pos {
+	positions := make(map[ecs.Id]phy2.Pos)
+	for id, compList := range update.WorldData {
+		for _, c := range compList {
+			serverTransform, ok := c.(ecs.CompBox[ServerTransform])
+			if !ok { continue }
+			positions[id] = serverTransform.Get().Pos
+		}
+	}
+	return positions
 }
 
-func ClientPollNetworkSystem(networkChannel chan serdes.WorldUpdate,
-	updateQueue *queue.Queue[serdes.WorldUpdate]) ecs.System {
+func ClientPollNetworkSystem(world *ecs.World, networkChannel chan serdes.WorldUpdate,
+	snapshots *netcode.InterpBuffer[serdes.WorldUpdate], playerData *session.PlayerData, loadZone func(mmo.ZoneId)) ecs.System {
 
-	// Read everything from the channel and push it into the updateQueue
+	// Read everything from the channel and push it into the snapshot buffer
 	sys := ecs.System{"PollNetwork", func(dt time.Duration) {
 	MainLoop:
 		for {
 			select {
 			case update := <-networkChannel:
-				updateQueue.Enqueue(update)
+				// Login updates don't come from the server so they don't have a tick, they get applied right away.
+				// Note: Logging in means that we are talking to a new server, so the old snapshots are useless
+				if session.IsLoginUpdate(update) {
+					snapshots.Reset()
+					session.ApplyWorldUpdate(world, update, playerData, loadZone)
+					continue
+				}
+				snapshots.Add(update.Tick, time.Now(), updatePositions(update), update)
 
 			default:
 				break MainLoop
@@ -34,58 +52,17 @@ func ClientPollNetworkSystem(networkChannel chan serdes.WorldUpdate,
 	return sys
 }
 
-func ClientPullFromUpdateQueue(world *ecs.World, updateQueue *queue.Queue[serdes.WorldUpdate], playerData *PlayerData) ecs.System {
-	// TODO! - dynamic based on connection
-	targetQueueSize := mmo.ClientDefaultUpdateQueueSize
-
-	var everyOther int
-
-	// Read a single element from the update queue
-	sys := ecs.System{"PullUpdateQueue", func(dt time.Duration) {
-		if targetQueueSize > 0 {
-			//TODO! - IMPORTANT If I pull out like tick 100, then next tick 102, I know that those should be (2 * 64ms) apart and not 64 ms apart. I somehow need to fix that problem for when dropped packets are recv'ed. Or I need to split the difference and enqueue another thing
-			everyOther = (everyOther + 1) % mmo.NetworkTickDivider
-			if everyOther != 0 {
-				return // skip
-			}
-
-			// // TODO - keep track of size
-			queueSize := 0
-			updateQueue.Each(func(u serdes.WorldUpdate) {
-				queueSize++
-			})
-			if queueSize > targetQueueSize {
-				// log.Print("UpdateQueue Desynchronization (TooBig): ", queueSize, targetQueueSize)
-				// We want the next tick to run a little bit faster.
-				// TODO - Optimization Note: The bigger you make the addition, the faster it gets back to target length, but the more dramatic the entity needs to be sped up to interp that distance. I could also change the %4 to %8 to make the speedup even more minimal. Right now this doesn't seem to noticeable
-				everyOther = (everyOther + 1) % mmo.NetworkTickDivider
-			}//  else if queueSize < targetQueueSize {
-			// 	log.Print("UpdateQueue Desynchronization (TooSmall): ", queueSize, targetQueueSize)
-			// 	everyOther = (everyOther + 3) % mmo.NetworkTickDivider // Go back one and rerun
-			// 	return
-			// }
-			// log.Print("UpdateQueueSize: ", queueSize)
-		}
-
-		if updateQueue.Empty() {
-			return
-		}
-
-		update := updateQueue.Dequeue()
-
-		// Update our playerData tick information
-		playerData.SetTicks(update.Tick, update.PlayerTick)
-
-		for id, compList := range update.WorldData {
-			compList = append(compList, ecs.C(LastUpdate{time.Now()}))
-			ecs.Write(world, id, compList...)
+// Applies the snapshots once the render clock reaches their tick
+func ClientPlaySnapshots(world *ecs.World, snapshots *netcode.InterpBuffer[serdes.WorldUpdate], playerData *session.PlayerData, loadZone func(mmo.ZoneId)) ecs.System {
+	sys := ecs.System{"PlaySnapshots", func(dt time.Duration) {
+		updates := snapshots.Step(dt)
+		for _, update := range updates {
+			session.ApplyWorldUpdate(world, update, playerData, loadZone)
 		}
 
-		// Delete all the entities in the deleteList
-		if update.Delete != nil {
-			for _, id := range update.Delete {
-				ecs.Delete(world, id)
-			}
+		// Note: The server rewinds to this tick for lag compensation (See: server.RenderTick)
+		if len(updates) > 0 {
+			playerData.SetRenderTick(snapshots.RenderTick())
 		}
 	}}
 
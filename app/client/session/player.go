@@ -1,4 +1,4 @@
-package client
+package session
 
 import (
 	"time"
@@ -9,6 +9,7 @@ import (
 
 	"github.com/unitoftime/ecs"
 	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
 )
 
 type InputBufferItem struct {
@@ -20,8 +21,13 @@ type InputBufferItem struct {
 type PlayerData struct {
 	mu sync.RWMutex
 	id ecs.Id
+	session uint64 // Counts up every time we log in
 	playerTick uint16
 	serverTick uint16
+	ackTick uint16 // The last server tick that we have fully received
+	acked bool
+	renderTick uint16 // The server tick that other entities are being rendered at
+	rendering bool
 	lastMessage string
 	inputBuffer []InputBufferItem
 	roundTripTimes *ds.RingBuffer[time.Duration]
@@ -48,6 +54,63 @@ func (p *PlayerData) SetId(id ecs.Id) {
 	p.mu.Unlock()
 }
 
+// Starts a new session with the player's new entity (ie after a login, a zone transfer, or the server restarting)
+// Note: The inputs that weren't acknowledged are dropped, because the new server never saw them and would apply them on top of where the character was saved
+func (p *PlayerData) StartSession(id ecs.Id) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.id = id
+	p.session++
+	p.ackTick = 0
+	p.acked = false
+	p.rendering = false
+	p.inputBuffer = p.inputBuffer[:0]
+}
+
+// Returns a number that changes every time a new session starts
+// Note: Use this instead of comparing ids to detect logins, because a restarted server will usually hand out the same id again
+func (p *PlayerData) Session() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.session
+}
+
+// Returns the last server tick that we have fully received, and false if we haven't received any
+func (p *PlayerData) AckTick() (uint16, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.ackTick, p.acked
+}
+
+func (p *PlayerData) SetAckTick(tick uint16) {
+	p.mu.Lock()
+	p.ackTick = tick
+	p.acked = true
+	p.mu.Unlock()
+}
+
+func (p *PlayerData) ClearAckTick() {
+	p.mu.Lock()
+	p.ackTick = 0
+	p.acked = false
+	p.rendering = false
+	p.mu.Unlock()
+}
+
+// Returns the server tick that other entities are being rendered at, and false if we haven't rendered any yet
+func (p *PlayerData) RenderTick() (uint16, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.renderTick, p.rendering
+}
+
+func (p *PlayerData) SetRenderTick(tick uint16) {
+	p.mu.Lock()
+	p.renderTick = tick
+	p.rendering = true
+	p.mu.Unlock()
+}
+
 // func (p *PlayerData) Tick() uint16 {
 // 	p.mu.RLock()
 // 	ret := p.tick
@@ -94,6 +157,25 @@ func (p *PlayerData) AppendInputTick(input mmo.Input) uint16 {
 	return p.playerTick
 }
 
+// Returns the newest inputs that the server hasn't acknowledged yet (up to max), oldest first, with the player tick that each one was captured on
+func (p *PlayerData) UnackedInputs(max int) []serdes.TickInput {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	start := len(p.inputBuffer) - max
+	if start < 0 {
+		start = 0
+	}
+	ret := make([]serdes.TickInput, 0, len(p.inputBuffer) - start)
+	for i := start; i < len(p.inputBuffer); i++ {
+		// Note: The input buffer holds one input per player tick, and ends at the current player tick
+		age := len(p.inputBuffer) - 1 - i
+		tick := uint16((int(p.playerTick) - age + mmo.TickModulus) % mmo.TickModulus)
+		ret = append(ret, serdes.TickInput{tick, p.inputBuffer[i].Input})
+	}
+	return ret
+}
+
 func (p *PlayerData) GetInputBuffer() []InputBufferItem {
 	return p.inputBuffer
 }
@@ -0,0 +1,105 @@
+// Tracks the player's session on the client, and the world updates that change it (ie logging in, a zone transfer, or the server restarting)
+// Note: This doesn't depend on the renderer, so that it can be tested without a window
+package session
+
+import (
+	"time"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/netcode"
+)
+
+type LastUpdate struct {
+	Time time.Time
+}
+
+// This is put into the update queue when the player logs in, so that the zone gets loaded in order with the rest of the updates
+type ZoneChange struct {
+	Zone mmo.ZoneId
+}
+
+// Starts a new session for the login response, and returns the update that sets up the player's entity. The extra components get added to the player's entity
+// Note: The proxy logs us back in whenever it reconnects to the server, so this can happen at any time
+func Login(playerData *PlayerData, resp serdes.ClientLoginResp, extra ...ecs.Component) serdes.WorldUpdate {
+	playerData.StartSession(resp.Id)
+
+	compList := []ecs.Component{
+		ecs.C(ZoneChange{resp.Zone}),
+		ecs.C(mmo.Input{}),
+		ecs.C(phy2.Pos{}),
+	}
+	compList = append(compList, extra...)
+	return serdes.WorldUpdate{
+		UserId: resp.UserId,
+		WorldData: map[ecs.Id][]ecs.Component{
+			resp.Id: compList,
+		},
+	}
+}
+
+// Returns true if the update is the one that the client creates when the player logs in (see Login)
+func IsLoginUpdate(update serdes.WorldUpdate) bool {
+	for _, compList := range update.WorldData {
+		for _, c := range compList {
+			_, ok := c.(ecs.CompBox[ZoneChange])
+			if ok { return true }
+		}
+	}
+	return false
+}
+
+// Deletes every entity that came from the server. The new server doesn't know about any of them, and it can reuse their ids for different entities
+func PurgeServerEntities(world *ecs.World) {
+	ids := make([]ecs.Id, 0)
+	ecs.Map(world, func(id ecs.Id, lastUpdate *LastUpdate) {
+		ids = append(ids, id)
+	})
+	for _, id := range ids {
+		ecs.Delete(world, id)
+	}
+}
+
+// Clears the reconciler if a new session started since the last time it ran. Returns the session that the reconciler is on now
+// Note: Our old predictions are meaningless after logging in again
+func ClearReconciler(playerData *PlayerData, reconciler *netcode.Reconciler, reconciledSession uint64) uint64 {
+	current := playerData.Session()
+	if current != reconciledSession {
+		reconciler.Clear()
+	}
+	return current
+}
+
+// Writes the update into the world
+func ApplyWorldUpdate(world *ecs.World, update serdes.WorldUpdate, playerData *PlayerData, loadZone func(mmo.ZoneId)) {
+	// Update our playerData tick information
+	playerData.SetTicks(update.Tick, update.PlayerTick)
+
+	// Load the zone first, because loading a new zone clears the world
+	for id, compList := range update.WorldData {
+		for i, c := range compList {
+			zoneChange, ok := c.(ecs.CompBox[ZoneChange])
+			if !ok { continue }
+			loadZone(zoneChange.Get().Zone)
+			PurgeServerEntities(world) // Note: If the zone didn't change (ie the server restarted), then the world still has everything from the old session
+			compList = append(compList[:i], compList[i+1:]...)
+			update.WorldData[id] = compList
+			break
+		}
+	}
+
+	for id, compList := range update.WorldData {
+		compList = append(compList, ecs.C(LastUpdate{time.Now()}))
+		ecs.Write(world, id, compList...)
+	}
+
+	// Delete all the entities in the deleteList
+	if update.Delete != nil {
+		for _, id := range update.Delete {
+			ecs.Delete(world, id)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package session
+
+import (
+	"time"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/netcode"
+)
+
+// The server restarts and hands us the same entity id again. That still has to start a new session, and the entities from the old server have to go
+func TestLoginWithSameId(t *testing.T) {
+	world := ecs.NewWorld()
+	playerData := NewPlayerData()
+	reconciler := netcode.NewReconciler(netcode.ReconcileConfig{
+		SnapThreshold: 50,
+		SmoothDuration: 200 * time.Millisecond,
+		TickDuration: 64 * time.Millisecond,
+	})
+	zones := make([]mmo.ZoneId, 0)
+	loadZone := func(zone mmo.ZoneId) {
+		zones = append(zones, zone)
+	}
+
+	playerId := ecs.Id(5)
+	otherId := ecs.Id(7)
+	resp := serdes.ClientLoginResp{UserId: 1, Id: playerId, Zone: mmo.DefaultZone}
+
+	ApplyWorldUpdate(world, Login(playerData, resp), playerData, loadZone)
+	reconciledSession := ClearReconciler(playerData, reconciler, 0)
+	firstSession := playerData.Session()
+
+	// Play for a bit on the first server
+	ApplyWorldUpdate(world, serdes.WorldUpdate{
+		Tick: 10,
+		WorldData: map[ecs.Id][]ecs.Component{
+			playerId: []ecs.Component{ecs.C(phy2.Pos{100, 100})},
+			otherId: []ecs.Component{ecs.C(phy2.Pos{50, 50}), ecs.C(mmo.Body{})},
+		},
+	}, playerData, loadZone)
+	playerData.SetAckTick(10)
+	playerData.SetRenderTick(8)
+	for i := 0; i < 3; i++ {
+		playerData.AppendInputTick(mmo.Input{Left: true})
+	}
+	reconciler.Predict(1, phy2.Pos{100, 100})
+
+	// The server restarts and logs us back in with the same id
+	ApplyWorldUpdate(world, Login(playerData, resp), playerData, loadZone)
+	reconciledSession = ClearReconciler(playerData, reconciler, reconciledSession)
+
+	if playerData.Session() == firstSession {
+		t.Errorf("Expected a new session")
+	}
+	if reconciledSession != playerData.Session() {
+		t.Errorf("Expected the reconciler to be on the new session")
+	}
+	if playerData.Id() != playerId {
+		t.Errorf("Expected player id %d, got %d", playerId, playerData.Id())
+	}
+	if _, acked := playerData.AckTick(); acked {
+		t.Errorf("Expected the ack tick to be cleared")
+	}
+	if _, rendering := playerData.RenderTick(); rendering {
+		t.Errorf("Expected the render tick to be cleared")
+	}
+	if inputs := playerData.UnackedInputs(10); len(inputs) != 0 {
+		t.Errorf("Expected the unacked inputs to be dropped, got %v", inputs)
+	}
+	if _, ok := reconciler.Prediction(1); ok {
+		t.Errorf("Expected the reconciler to be cleared")
+	}
+
+	// The zone gets reloaded, and everything from the old server is gone
+	if len(zones) != 2 {
+		t.Errorf("Expected the zone to be loaded for both logins, got %v", zones)
+	}
+	if _, ok := ecs.Read[phy2.Pos](world, otherId); ok {
+		t.Errorf("Expected the stale entity to be deleted")
+	}
+	pos, ok := ecs.Read[phy2.Pos](world, playerId)
+	if !ok || pos != (phy2.Pos{}) {
+		t.Errorf("Expected the player to be reset, got %v %v", pos, ok)
+	}
+	if _, ok := ecs.Read[ZoneChange](world, playerId); ok {
+		t.Errorf("Expected the zone change not to be written to the player")
+	}
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/mmo/moderation"
+)
+
+// The longest chat message (in characters) that the proxy will forward
+const maxChatLength = 200
+
+// Builds the chat moderation pipeline that the proxy runs every chat message through
+// Note: To get the old behavior back, use moderation.NewPipeline(moderation.FilterChat)
+func NewModerationPipeline(wordListFile string) *moderation.Pipeline {
+	words := moderation.NewWordList()
+	if wordListFile != "" {
+		var err error
+		words, err = moderation.LoadWordList(wordListFile)
+		if err != nil {
+			panic(err)
+		}
+		log.Print("Loaded chat word list: ", wordListFile)
+	}
+
+	return moderation.NewPipeline(
+		// Note: Rate limiting goes first so that rejected messages still count against the user
+		moderation.NewRateLimiter(2, 5, 5, 1 * time.Minute),
+		moderation.Normalize{},
+		moderation.MaxLength(maxChatLength),
+		moderation.ScriptPolicy{moderation.DefaultScripts},
+		words,
+	)
+}
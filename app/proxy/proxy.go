@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	gonet "net"
 	"net/http"
 	"crypto/tls"
 	"sync"
@@ -16,18 +17,31 @@ import (
 	"github.com/unitoftime/flow/net"
 
 	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/auth"
+	"github.com/unitoftime/mmo/moderation"
+	"github.com/unitoftime/mmo/reliable"
 	"github.com/unitoftime/mmo/stat"
 	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
 	"github.com/unitoftime/ecs"
 )
 
 type Config struct {
-	ServerUri string
+	Zones map[mmo.ZoneId]string // Maps each zone to the uri of the server that owns it
+	Url string // The url that clients connect to, defaults to webrtc://:443 (or webrtc://localhost:7777 when testing)
 	KeyFile string
 	CertFile string
+	TokenSecret []byte // The secret used to validate client login tokens
+	ChatWordList string // Optional file of words that get masked out of chat, one per line
+	MetricsAddr string // The local address that metrics are served on at /metrics (ie 127.0.0.1:9102), empty to disable them
 	Test bool
 }
 
+// Note: This makes sure we never print the TokenSecret into the logs
+func (c Config) String() string {
+	return fmt.Sprintf("{Zones:%v Url:%s KeyFile:%s CertFile:%s ChatWordList:%s MetricsAddr:%s Test:%v}", c.Zones, c.Url, c.KeyFile, c.CertFile, c.ChatWordList, c.MetricsAddr, c.Test)
+}
+
 func Main(config Config) {
 	logfile, err := os.OpenFile("proxy.log", os.O_RDWR|os.O_CREATE, 0755)
 	if err != nil { panic(err) }
@@ -38,67 +52,78 @@ func Main(config Config) {
 
 	log.Print("Using Config: ", config)
 
+	if len(config.TokenSecret) == 0 {
+		panic("Proxy requires a TokenSecret to validate logins")
+	}
+
 	room := NewRoom()
 
-	serverNet := net.Config{
-		Url: config.ServerUri,
-		Serdes: serdes.New(),
-		ReconnectHandler: func(sock *net.Socket) error {
-			// After we reconnect the proxy to the server, we want to log all the players into the server who were waiting.
+	if config.MetricsAddr != "" {
+		stat.Default.NewGaugeFunc(stat.RoomSizeKey, "The number of users connected to the proxy", func() float64 {
 			room.mu.RLock()
-			for userId := range room.Map {
-				log.Debug().Uint64(stat.UserId, userId).Msg("Reconnect - Sending Login Message for")
-
-				loginMsg := serdes.ClientLogin{userId}
-				err := sock.Send(loginMsg)
-				if err != nil {
-					log.Error().Err(err).Uint64(stat.UserId, userId).Msg("Failed to send login message")
-				}
-			}
-			room.mu.RUnlock()
-
-			return room.HandleGameUpdates(sock)
-		},
+			defer room.mu.RUnlock()
+			return float64(len(room.Map))
+		})
+		go func() {
+			err := stat.ListenAndServe(config.MetricsAddr)
+			log.Error().Err(err).Msg("Metrics stopped")
+		}()
+		log.Print("Serving metrics on ", config.MetricsAddr)
 	}
 
-	sock, err := serverNet.Dial()
-	if err != nil {
-		panic(err)
-	}
+	moderator := NewModerationPipeline(config.ChatWordList)
 
-	// HTTPS Version
-	certPem, err := os.ReadFile(config.CertFile)
-	if err != nil {
-		panic(err)
-	}
-	keyPem, err := os.ReadFile(config.KeyFile)
-	if err != nil {
-		panic(err)
-	}
-	cert, err := tls.X509KeyPair(certPem, keyPem)
-	if err != nil {
-		panic(err)
+	_, ok := config.Zones[mmo.DefaultZone]
+	if !ok {
+		panic("Proxy requires a server for the default zone, because that is where users log in")
 	}
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
+
+	for zone, uri := range config.Zones {
+		err := room.DialServer(zone, uri)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	hostname := ":443"
-	if config.Test {
-		hostname = "localhost:7777"
+	url := config.Url
+	if url == "" {
+		url = "webrtc://:443"
+		if config.Test {
+			url = "webrtc://localhost:7777"
+		}
 	}
 
-	wsConfig := net.Config{
-		Url: "webrtc://"+hostname,
+	wsConfig := transport.Config{
+		Url: url,
 		// Url: "wss://"+hostname,
 		Serdes: serdes.New(),
-		TlsConfig: tlsConfig,
-		HttpServer: &http.Server{
+		OriginPatterns: []string{"localhost:8081", "mmo.unit.dev", "unit.dev", "www.unit.dev"},
+	}
+
+	// Note: The in-memory transport doesn't need certificates
+	if !transport.IsMem(url) {
+		// HTTPS Version
+		certPem, err := os.ReadFile(config.CertFile)
+		if err != nil {
+			panic(err)
+		}
+		keyPem, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			panic(err)
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+		wsConfig.TlsConfig = tlsConfig
+		wsConfig.HttpServer = &http.Server{
 			TLSConfig: tlsConfig,
 			ReadTimeout: 10 * time.Second,
 			WriteTimeout: 10 * time.Second,
-		},
-		OriginPatterns: []string{"localhost:8081", "mmo.unit.dev", "unit.dev", "www.unit.dev"},
+		}
 	}
 
 	listener, err := wsConfig.Listen()
@@ -109,8 +134,9 @@ func Main(config Config) {
 
 	playerServer := &websocketServer{
 		listener: listener,
-		serverConn: sock,
 		room: room,
+		tokenSecret: config.TokenSecret,
+		moderator: moderator,
 	}
 	playerServer.Start()
 
@@ -124,35 +150,129 @@ func Main(config Config) {
 }
 
 type ClientConnection struct {
-	sock *net.Socket
+	conn *reliable.Conn
+	zone mmo.ZoneId // The zone that the user is currently in
 }
 
 type websocketServer struct {
-	listener net.Listener
-	serverConn *net.Socket
+	listener transport.Listener
 	room *Room
+	tokenSecret []byte
+	moderator *moderation.Pipeline
 }
 
 func (s *websocketServer) Start() {
 	for {
 		sock, err := s.listener.Accept()
 		if err != nil {
+			if errors.Is(err, gonet.ErrClosed) { return }
 			log.Warn().Err(err).Msg("Failed to accept connection")
 			continue
 		}
 
 		log.Print("Accepting new connection")
-		go ServeNetConn(sock, s.serverConn, s.room)
+		go ServeNetConn(sock, s.room, s.tokenSecret, s.moderator)
 	}
 }
 
-// This is just to make sure different users get different login ids
-var userIdCounter uint64
+// The amount of time a client has to send each of their hello and login messages after connecting
+const authTimeout = 10 * time.Second
+
+// Receives the next message, or fails if it doesn't arrive within the timeout
+func recvTimeout(conn *reliable.Conn, timeout time.Duration) (any, error) {
+	type result struct {
+		msg any
+		err error
+	}
+	recv := make(chan result, 1)
+	go func() {
+		msg, err := conn.Recv()
+		recv <- result{msg, err}
+	}()
+
+	select {
+	case res := <-recv:
+		return res.msg, res.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("Timed out waiting for message")
+	}
+}
+
+// Waits for the client to send their Hello and checks that they're running a compatible protocol. If they aren't, they are sent a HelloReject
+func handshake(conn *reliable.Conn) error {
+	msg, err := recvTimeout(conn, authTimeout)
+	if err == nil {
+		hello, ok := msg.(serdes.Hello)
+		if ok {
+			err = serdes.CheckHello(hello)
+		} else {
+			err = fmt.Errorf("%w: Expected hello message, got %T", serdes.ErrVersionMismatch, msg)
+		}
+	}
+
+	if err != nil {
+		log.Warn().Err(err).Msg("Rejecting Client Version")
+		sendErr := conn.Send(serdes.NewHelloReject(err))
+		if sendErr != nil {
+			log.Warn().Err(sendErr).Msg("Failed to send hello rejection")
+			return err
+		}
+		drainBeforeClose(conn)
+		return err
+	}
+
+	return conn.Send(serdes.NewHello())
+}
+
+// Waits for the client to send their login token and returns the account id inside of it
+func authenticate(conn *reliable.Conn, tokenSecret []byte) (uint64, error) {
+	msg, err := recvTimeout(conn, authTimeout)
+	if err != nil {
+		return 0, err
+	}
+	login, ok := msg.(serdes.ClientAuth)
+	if !ok {
+		return 0, fmt.Errorf("Expected login message, got %T", msg)
+	}
+	claims, err := auth.Validate(tokenSecret, login.Token, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserId, nil
+}
+
+// Sends the reason that the user's login was rejected. The connection gets closed afterwards
+func rejectLogin(conn *reliable.Conn, reason error) {
+	log.Warn().Err(reason).Msg("Rejecting Login")
+	err := conn.Send(serdes.ClientAuthReject{reason.Error()})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to send login rejection")
+	}
+}
+
+// Waits for the last reliable message to be acked, otherwise closing the connection can drop it. Only use this before the client's read loop has started
+// Note: Acks are only processed by Recv, so we keep reading (and throwing away) messages until the connection gets closed
+func drainBeforeClose(conn *reliable.Conn) {
+	go func() {
+		for {
+			_, err := conn.Recv()
+			if err != nil && !errors.Is(err, net.ErrSerdes) {
+				return
+			}
+		}
+	}()
+
+	if !conn.Drain(time.Second) {
+		log.Warn().Msg("Closing connection before the client acked the last message")
+	}
+}
 
 // Handles the websocket connection to a specific client in the room
-func ServeNetConn(sock *net.Socket, serverConn *net.Socket, room *Room) {
+func ServeNetConn(sock transport.Socket, room *Room, tokenSecret []byte, moderator *moderation.Pipeline) {
+	// Note: The hello, logins and chat go over the reliable channel, so that they survive packet loss (See: serdes.IsReliable)
+	conn := reliable.NewConn(sock, reliable.DefaultConfig())
 	defer func() {
-		err := sock.Close()
+		err := conn.Close()
 		if err != nil {
 			log.Error().Err(err).Msg("Error closing websocket connection")
 		}
@@ -163,20 +283,34 @@ func ServeNetConn(sock *net.Socket, serverConn *net.Socket, room *Room) {
 	const StopTimeout uint8 = 0
 	const ContTimeout uint8 = 1
 
+	// Stale clients get a clean rejection instead of a stream of serialization errors
+	err := handshake(conn)
+	if err != nil {
+		return
+	}
+
+	// The user's id comes from their signed login token
+	userId, err := authenticate(conn, tokenSecret)
+	if err != nil {
+		rejectLogin(conn, err)
+		drainBeforeClose(conn)
+		return
+	}
+
 	// Login player
 	room.mu.Lock()
-	// TODO - Eventually This id should come from the login request which probably has a JWT which encodes the data. You probably don't need that in a lock
-	userId := userIdCounter
-	userIdCounter++
 	_, ok := room.Map[userId]
 	if ok {
 		log.Print("Duplicate Login Detected! Exiting.")
 		room.mu.Unlock()
+		rejectLogin(conn, fmt.Errorf("User is already logged in"))
+		drainBeforeClose(conn)
 		return
 	}
 
 	// sock := net.NewConnectedSocket(conn, serdes.New())
-	room.Map[userId] = ClientConnection{sock}
+	// Note: Users always log into the default zone. If they logged out somewhere else, then the server will transfer them back there
+	room.Map[userId] = ClientConnection{conn, mmo.DefaultZone}
 
 	room.mu.Unlock()
 
@@ -185,12 +319,14 @@ func ServeNetConn(sock *net.Socket, serverConn *net.Socket, room *Room) {
 		room.mu.Lock()
 		delete(room.Map, userId)
 		room.mu.Unlock()
+		moderator.Forget(userId)
 	}()
 
 	// Send login message to server
 	log.Debug().Uint64(stat.UserId, userId).Msg("Sending Login Message")
+	serverConn := room.GetServer(mmo.DefaultZone)
 	log.Print("ServerConn Status:", serverConn)
-	err := serverConn.Send(serdes.ClientLogin{userId})
+	err = serverConn.Send(serdes.ClientLogin{userId})
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to forward login message")
 		return
@@ -198,13 +334,15 @@ func ServeNetConn(sock *net.Socket, serverConn *net.Socket, room *Room) {
 
 	// Send logout message to server
 	defer func() {
+		serverConn := room.GetUserServer(userId)
+		if serverConn == nil { return }
 		sendUserLogoutToServer(serverConn, userId)
 	}()
 
 	// Read data from client and sends to game server
 	go func() {
 		for {
-			msg, err := sock.Recv()
+			msg, err := conn.Recv()
 			if errors.Is(err, net.ErrNetwork) {
 				timeout <- StopTimeout // Stop timeout because of a read error
 				log.Warn().Err(err).Msg("Failed to receive")
@@ -222,36 +360,50 @@ func ServeNetConn(sock *net.Socket, serverConn *net.Socket, room *Room) {
 			if msg == nil { continue }
 
 			switch t := msg.(type) {
-			case serdes.WorldUpdate:
-				// log.Printf("%v", t.Messages)
-				// for i := range t.Messages {
-				// 	t.Messages[i].Filter() // Run a chat filter
-				// }
-
-				// Filter chat messages
-				for _, compSlice := range t.WorldData {
-					for i, c := range compSlice {
-
-						switch t := c.(type) {
-						case ecs.CompBox[mmo.Speech]:
-							filteredText := mmo.FilterChat(t.Get().Text)
-							log.Print("Chat Speech: ", t.Get().Text)
-							log.Print("Chat Filter: ", filteredText)
-							compSlice[i] = ecs.C(mmo.Speech{
-								Text: filteredText,
-							})
-						}
-					}
-				}
-
+			case serdes.PlayerInput:
 				t.UserId = userId
 
+				serverConn := room.GetUserServer(userId)
+				if serverConn == nil { continue }
+				if !serverConn.IsConnected() { continue } // Skip: The server is down, the user gets logged back in once it comes back (See: ReconnectHandler)
+
 				err := serverConn.Send(t)
 				if err != nil {
 					log.Warn().Err(err).Msg("Failed to send")
 				}
+			case serdes.ChatMessage:
+				if t.Channel == mmo.ChannelSystem {
+					log.Warn().Uint64(stat.UserId, userId).Msg("Client tried to send a system chat message")
+					continue
+				}
+
+				text, reason := moderator.Moderate(userId, t.Text, time.Now())
+				log.Print("Chat ", t.Channel, ": ", t.Text)
+				if reason != moderation.ReasonNone {
+					log.Print("Chat Rejected: ", reason)
+					err := conn.Send(serdes.ChatReject{reason})
+					if err != nil {
+						log.Warn().Err(err).Msg("Failed to send chat rejection")
+					}
+					continue
+				}
+				log.Print("Chat Filter: ", text)
+				t.Text = text
+
+				t.UserId = userId
+				t.From = 0 // Note: The server fills this in
+
+				for _, serverConn := range room.ChatServers(t) {
+					err := serverConn.Send(t)
+					if err != nil {
+						log.Warn().Err(err).Msg("Failed to send")
+					}
+				}
 			default:
-				panic("Unknown message type")
+				log.Error().
+					Err(fmt.Errorf("Client sent unknown message type %T", msg)).
+					Uint64(stat.UserId, userId).
+					Msg("ServeNetConn")
 			}
 		}
 	}()
@@ -276,12 +428,114 @@ ExitTimeout:
 type Room struct {
 	mu sync.RWMutex
 	Map map[uint64]ClientConnection
+	servers map[mmo.ZoneId]transport.Socket // The connection to the server that owns each zone
 }
 
 func NewRoom() *Room {
 	return &Room{
 		Map: make(map[uint64]ClientConnection),
+		servers: make(map[mmo.ZoneId]transport.Socket),
+	}
+}
+
+// Connects to the server that owns the zone. The connection keeps reconnecting in the background if the server goes down
+func (r *Room) DialServer(zone mmo.ZoneId, uri string) error {
+	serverNet := transport.Config{
+		Url: uri,
+		Serdes: serdes.New(),
+		ReconnectHandler: func(sock transport.Socket) error {
+			err := sock.Send(serdes.NewHello())
+			if err != nil {
+				return err
+			}
+
+			// After we reconnect the proxy to the server, we want to log all the players into the server who were waiting.
+			r.mu.RLock()
+			for userId, clientConn := range r.Map {
+				if clientConn.zone != zone { continue }
+				log.Debug().Uint64(stat.UserId, userId).Msg("Reconnect - Sending Login Message for")
+
+				loginMsg := serdes.ClientLogin{userId}
+				err := sock.Send(loginMsg)
+				if err != nil {
+					log.Error().Err(err).Uint64(stat.UserId, userId).Msg("Failed to send login message")
+				}
+			}
+			r.mu.RUnlock()
+
+			return r.HandleGameUpdates(sock, zone)
+		},
 	}
+
+	sock, err := serverNet.Dial()
+	if err != nil {
+		return err
+	}
+	r.AddServer(zone, sock)
+	return nil
+}
+
+func (r *Room) AddServer(zone mmo.ZoneId, sock transport.Socket) {
+	r.mu.Lock()
+	r.servers[zone] = sock
+	r.mu.Unlock()
+}
+
+// Returns the connection to the server that owns the zone, or nil if there isn't one
+func (r *Room) GetServer(zone mmo.ZoneId) transport.Socket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.servers[zone]
+}
+
+// Returns the connection to the server that owns the zone that the user is currently in
+func (r *Room) GetUserServer(userId uint64) transport.Socket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clientConn, ok := r.Map[userId]
+	if !ok { return nil }
+	return r.servers[clientConn.zone]
+}
+
+// Returns the servers that the chat message should be routed through
+// Note: Global messages go to every zone. Whispers go to the zone that the target is in, or to the sender's zone if the target isn't connected so that the sender's server can tell them
+func (r *Room) ChatServers(msg serdes.ChatMessage) []transport.Socket {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ret := make([]transport.Socket, 0)
+	switch msg.Channel {
+	case mmo.ChannelGlobal:
+		for _, sock := range r.servers {
+			ret = append(ret, sock)
+		}
+		return ret
+	case mmo.ChannelWhisper:
+		target, ok := r.Map[msg.Target]
+		if ok {
+			sock, ok := r.servers[target.zone]
+			if ok {
+				return append(ret, sock)
+			}
+		}
+	}
+
+	sender, ok := r.Map[msg.UserId]
+	if !ok { return ret }
+	sock, ok := r.servers[sender.zone]
+	if !ok { return ret }
+	return append(ret, sock)
+}
+
+// Sets the zone that the user is in, returns false if the user is disconnected
+func (r *Room) SetZone(userId uint64, zone mmo.ZoneId) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clientConn, ok := r.Map[userId]
+	if !ok { return false }
+	clientConn.zone = zone
+	r.Map[userId] = clientConn
+	return true
 }
 
 func (r *Room) GetClientConn(userId uint64) *ClientConnection {
@@ -297,7 +551,7 @@ func (r *Room) GetClientConn(userId uint64) *ClientConnection {
 }
 
 // Read data from game server and send to client
-func (r *Room) HandleGameUpdates(serverConn *net.Socket) error {
+func (r *Room) HandleGameUpdates(serverConn transport.Socket, zone mmo.ZoneId) error {
 	for {
 		msg, err := serverConn.Recv()
 		if errors.Is(err, net.ErrNetwork) {
@@ -321,9 +575,10 @@ func (r *Room) HandleGameUpdates(serverConn *net.Socket) error {
 				sendUserLogoutToServer(serverConn, t.UserId)
 				continue
 			}
+			if clientConn.zone != zone { continue } // Skip: The user has already been transferred out of this zone
 
 			t.UserId = 0 // Clear userId (clients don't need to know user IDs)
-			err := clientConn.sock.Send(t)
+			err := clientConn.conn.Send(t)
 			if err != nil {
 				log.Warn().Err(err).Msg("Error Sending WorldUpdate to user")
 				// TODO - User disconnected? Remove from map? Why is server still sending to them?
@@ -331,13 +586,91 @@ func (r *Room) HandleGameUpdates(serverConn *net.Socket) error {
 		case serdes.ClientLoginResp:
 			clientConn := r.GetClientConn(t.UserId)
 			if clientConn == nil { continue }
+			if clientConn.zone != zone { continue } // Skip: The user has already been transferred out of this zone
 
-			err := clientConn.sock.Send(serdes.ClientLoginResp{t.UserId, ecs.Id(t.Id)})
+			err := clientConn.conn.Send(serdes.ClientLoginResp{t.UserId, ecs.Id(t.Id), t.Zone})
 			if err != nil {
 				log.Warn().Err(err).Msg("Error Sending login response to user")
 				// TODO - User disconnected? Remove from map? Why is server still sending to them?
 			}
 
+		case serdes.ChatMessage:
+			// Note: Chat isn't tied to a zone, so we deliver it no matter which server it came from
+			clientConn := r.GetClientConn(t.UserId)
+			if clientConn == nil { continue }
+
+			t.UserId = 0 // Clear userId (clients don't need to know user IDs)
+			err := clientConn.conn.Send(t)
+			if err != nil {
+				log.Warn().Err(err).Msg("Error Sending chat to user")
+			}
+
+		case serdes.ZoneTransfer:
+			clientConn := r.GetClientConn(t.UserId)
+			if clientConn == nil { continue } // Note: The server saved the character in the target zone, so they'll go there when they log back in
+
+			target := r.GetServer(t.Zone)
+			if target == nil {
+				// The user's entity has already left the old zone, so the best we can do is disconnect them
+				log.Error().Uint64(stat.UserId, t.UserId).Msg(fmt.Sprintf("No server for zone %d, disconnecting user", t.Zone))
+				clientConn.conn.Close()
+				continue
+			}
+
+			log.Print(fmt.Sprintf("Transferring user %d from zone %d to zone %d", t.UserId, zone, t.Zone))
+			r.SetZone(t.UserId, t.Zone)
+			err := target.Send(t)
+			if err != nil {
+				log.Warn().Err(err).Msg("Error Sending zone transfer to server")
+			}
+
+		case serdes.Hello:
+			err := serdes.CheckHello(t)
+			if err != nil {
+				log.Error().Err(err).Msg("Incompatible Server")
+				serverConn.Close()
+				return err
+			}
+
+		case serdes.HelloReject:
+			err := fmt.Errorf("Server rejected proxy version: %s", t.Reason)
+			log.Error().Err(err).Msg("HandleGameUpdates")
+			serverConn.Close()
+			return err
+
+		case serdes.ServerShutdown:
+			// Let every user in the zone know. Once the server comes back, the users that are still connected get logged back in (See: ReconnectHandler)
+			text := fmt.Sprintf("The server is shutting down in %d seconds", t.Countdown)
+			if t.Countdown == 0 {
+				text = "The server has shut down, you will be logged back in once it restarts"
+			}
+			notice := serdes.ChatMessage{Channel: mmo.ChannelSystem, Text: text}
+
+			r.mu.RLock()
+			for _, clientConn := range r.Map {
+				if clientConn.zone != zone { continue }
+				err := clientConn.conn.Send(notice)
+				if err != nil {
+					log.Warn().Err(err).Msg("Error Sending shutdown notice to user")
+				}
+			}
+			r.mu.RUnlock()
+
+		case serdes.UserKick:
+			clientConn := r.GetClientConn(t.UserId)
+			if clientConn == nil { continue } // Skip: Already disconnected
+
+			// Note: Closing the connection removes the user from the room (See: ServeNetConn). We wait for the kick to be acked first, otherwise closing can drop it
+			log.Warn().Uint64(stat.UserId, t.UserId).Str("Reason", t.Reason).Msg("Kicking user")
+			err := clientConn.conn.Send(t)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to send kick to user")
+			}
+			go func(conn *reliable.Conn) {
+				conn.Drain(time.Second)
+				conn.Close()
+			}(clientConn.conn)
+
 		case serdes.ClientLogoutResp:
 			log.Print("Received serdes.ClientLogoutResp")
 			// Note: When the proxy's client connection handler function exits, it removes the user from the room.
@@ -352,11 +685,12 @@ func (r *Room) HandleGameUpdates(serverConn *net.Socket) error {
 	return nil
 }
 
-func sendUserLogoutToServer(sock *net.Socket, userId uint64) {
+func sendUserLogoutToServer(sock transport.Socket, userId uint64) {
 	err := sock.Send(serdes.ClientLogout{userId})
 	if err != nil {
-		log.Warn().Err(err).Msg("Failed to send logout message")
-		panic(err)
+		// Note: This happens when the server is down. It logs out all of our users once it loses us, so there is nothing else to do
+		log.Warn().Err(err).Uint64(stat.UserId, userId).Msg("Failed to send logout message")
+		return
 	}
 	log.Printf("SendUserLogoutToServer: %d", userId)
 }
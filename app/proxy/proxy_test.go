@@ -0,0 +1,423 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/app/bot"
+	"github.com/unitoftime/mmo/app/server"
+	"github.com/unitoftime/mmo/auth"
+	"github.com/unitoftime/mmo/reliable"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
+)
+
+// Runs a server in-process that proxies connect to over the url. Returns a function that shuts it down, which also runs once the test ends
+func startServer(t *testing.T, url string) (*server.Server, func()) {
+	return startZoneServer(t, url, mmo.DefaultZone, server.NewMemoryStore())
+}
+
+// Runs a server for the zone, which saves its characters to the store
+func startZoneServer(t *testing.T, url string, zone mmo.ZoneId, store server.CharacterStore) (*server.Server, func()) {
+	world := ecs.NewWorld()
+	tilemap := mmo.LoadZone(world, zone)
+	deleteList := server.NewDeleteList()
+	persister := server.NewPersister(store)
+	chat := server.NewChatRouter()
+	networkChannel := make(chan serdes.WorldUpdate, 1024)
+
+	listener, err := (&transport.Config{Url: url, Serdes: serdes.New()}).Listen()
+	if err != nil { panic(err) }
+
+	srv := server.NewServer(listener, func(conn *server.ServerConn) error {
+		return server.ServeProxyConnection(conn, world, networkChannel, deleteList, persister, chat, zone)
+	})
+
+	schedule := mmo.GetScheduler()
+	schedule.AppendPhysics(server.CreateServerSystems(world, srv, networkChannel, deleteList, tilemap, persister, chat, zone)...)
+
+	quit := ecs.Signal{}
+	quit.Set(false)
+	gameDone := make(chan struct{})
+	go func() {
+		schedule.Run(&quit)
+		close(gameDone)
+	}()
+	go srv.Start()
+
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			srv.Stop()
+			srv.CloseProxies()
+			quit.Set(true)
+			<-gameDone
+		})
+	}
+	t.Cleanup(stop)
+	return srv, stop
+}
+
+// Runs a proxy in-process that connects to the server's url, and that clients connect to over the proxy's url
+func startProxy(t *testing.T, serverUrl, proxyUrl string, secret []byte) *Room {
+	room := NewRoom()
+	err := room.DialServer(mmo.DefaultZone, serverUrl)
+	if err != nil { panic(err) }
+
+	listener, err := (&transport.Config{Url: proxyUrl, Serdes: serdes.New()}).Listen()
+	if err != nil { panic(err) }
+	t.Cleanup(func() { listener.Close() })
+
+	playerServer := &websocketServer{
+		listener: listener,
+		room: room,
+		tokenSecret: secret,
+		moderator: NewModerationPipeline(""),
+	}
+	go playerServer.Start()
+	return room
+}
+
+// Connects a client to the proxy and logs in. Everything that the proxy sends ends up on the channel
+func dialClient(t *testing.T, url string, secret []byte, userId uint64) (*reliable.Conn, chan any) {
+	return dialClientWrapped(t, url, secret, userId, nil)
+}
+
+// Same as dialClient, but the client's socket is wrapped before the reliable channel is put on top of it
+func dialClientWrapped(t *testing.T, url string, secret []byte, userId uint64, wrap func(transport.Socket) transport.Socket) (*reliable.Conn, chan any) {
+	token, err := auth.NewToken(secret, userId, time.Now().Add(time.Hour))
+	if err != nil { panic(err) }
+
+	recv := make(chan any, 1024)
+	var conn *reliable.Conn
+	connReady := make(chan struct{})
+	clientNet := transport.Config{
+		Url: url,
+		Serdes: serdes.New(),
+		ReconnectHandler: func(sock transport.Socket) error {
+			<-connReady
+			conn.Reset()
+			err := conn.Send(serdes.NewHello())
+			if err != nil { return err }
+			err = conn.Send(serdes.ClientAuth{token})
+			if err != nil { return err }
+			for {
+				msg, err := conn.Recv()
+				if err != nil { return err }
+				recv <- msg
+			}
+		},
+	}
+	sock, err := clientNet.Dial()
+	if err != nil { panic(err) }
+	if wrap != nil {
+		sock = wrap(sock)
+	}
+	conn = reliable.NewConn(sock, reliable.DefaultConfig())
+	close(connReady)
+	t.Cleanup(func() { conn.Close() })
+	return conn, recv
+}
+
+// Waits for the first message that matches, skipping everything else
+func waitFor[T any](t *testing.T, recv chan any, match func(T) bool) T {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg := <-recv:
+			m, ok := msg.(T)
+			if ok && match(m) {
+				return m
+			}
+		case <-timeout:
+			var m T
+			t.Fatalf("Timed out waiting for %T", m)
+			return m
+		}
+	}
+}
+
+// Waits until the condition is true, or fails the test
+func eventually(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Wires a server, a proxy and a client together in one process over mem:// urls, with latency and jitter on both hops and packet loss between the client and the proxy
+func TestEndToEnd(t *testing.T) {
+	secret := []byte("secret")
+	userId := uint64(1234)
+
+	srv, _ := startServer(t, "mem://e2e-server")
+	startProxy(t, "mem://e2e-server?latency=5ms&jitter=5ms", "mem://e2e-proxy", secret)
+
+	// Note: Logins go over the reliable channel, so they make it through the loss
+	conn, recv := dialClient(t, "mem://e2e-proxy?latency=10ms&jitter=10ms&loss=0.1&seed=1", secret, userId)
+
+	loginResp := waitFor(t, recv, func(resp serdes.ClientLoginResp) bool { return true })
+	if loginResp.UserId != userId {
+		t.Fatalf("Logged in as %d, expected %d", loginResp.UserId, userId)
+	}
+
+	// Note: The server starts sending world updates once the user sends their first input
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(mmo.NetworkTickDivider * mmo.FixedTimeStep)
+		defer ticker.Stop()
+		playerTick := uint16(0)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				playerTick = (playerTick + 1) % mmo.TickModulus
+				conn.Send(serdes.PlayerInput{
+					Inputs: []serdes.TickInput{{playerTick, mmo.Input{}}},
+				})
+			}
+		}
+	}()
+
+	// The proxy forwards the world updates that include the user
+	waitFor(t, recv, func(update serdes.WorldUpdate) bool {
+		_, ok := update.WorldData[ecs.Id(loginResp.Id)]
+		return ok
+	})
+
+	// Disconnecting the client logs the user out of the server
+	conn.Close()
+	eventually(t, "User was never logged out of the server", func() bool {
+		for _, proxy := range srv.Proxies() {
+			_, ok := proxy.GetUser(userId)
+			if ok { return false }
+		}
+		return true
+	})
+}
+
+// Drops the first message that gets sent over the socket
+type dropFirstSocket struct {
+	transport.Socket
+	dropped atomic.Bool
+}
+
+func (s *dropFirstSocket) Send(msg any) error {
+	if s.dropped.CompareAndSwap(false, true) {
+		return nil
+	}
+	return s.Socket.Send(msg)
+}
+
+// The client's Hello gets lost. It has to be retransmitted before the ClientAuth, otherwise the proxy rejects the client
+func TestDroppedHello(t *testing.T) {
+	secret := []byte("secret")
+	userId := uint64(1234)
+
+	startServer(t, "mem://drop-server")
+	startProxy(t, "mem://drop-server", "mem://drop-proxy", secret)
+
+	var sock *dropFirstSocket
+	_, recv := dialClientWrapped(t, "mem://drop-proxy", secret, userId, func(s transport.Socket) transport.Socket {
+		sock = &dropFirstSocket{Socket: s}
+		return sock
+	})
+
+	// Note: The client would reconnect once the proxy closes the connection, so the rejection is what we have to check for
+	resp := waitFor(t, recv, func(msg any) bool {
+		switch msg.(type) {
+		case serdes.HelloReject, serdes.ClientAuthReject:
+			t.Fatalf("Client was rejected: %v", msg)
+		case serdes.ClientLoginResp:
+			return true
+		}
+		return false
+	}).(serdes.ClientLoginResp)
+	if resp.UserId != userId {
+		t.Fatalf("Logged in as %d, expected %d", resp.UserId, userId)
+	}
+	if !sock.dropped.Load() {
+		t.Errorf("Expected the first message to be dropped")
+	}
+}
+
+// Restarts the server underneath the proxy. The users that stay connected get logged back in, and a user that leaves while the server is down doesn't take the proxy down with it
+func TestServerRestart(t *testing.T) {
+	secret := []byte("secret")
+	stayId := uint64(1)
+	leaveId := uint64(2)
+
+	_, stop := startServer(t, "mem://restart-server")
+	room := startProxy(t, "mem://restart-server", "mem://restart-proxy", secret)
+
+	_, stayRecv := dialClient(t, "mem://restart-proxy", secret, stayId)
+	leaveConn, leaveRecv := dialClient(t, "mem://restart-proxy", secret, leaveId)
+	waitFor(t, stayRecv, func(resp serdes.ClientLoginResp) bool { return resp.UserId == stayId })
+	waitFor(t, leaveRecv, func(resp serdes.ClientLoginResp) bool { return resp.UserId == leaveId })
+
+	// Drop the server, and wait for the proxy to notice
+	stop()
+	eventually(t, "Proxy never noticed that the server went down", func() bool {
+		return !room.GetServer(mmo.DefaultZone).IsConnected()
+	})
+
+	// Note: The proxy tries to log this user out of the server, which fails because the server is down
+	leaveConn.Close()
+	eventually(t, "Proxy never removed the user that left", func() bool {
+		return room.GetClientConn(leaveId) == nil
+	})
+
+	// Bring the server back on the same url. The proxy reconnects and logs the remaining user back in
+	srv, _ := startServer(t, "mem://restart-server")
+	resp := waitFor(t, stayRecv, func(resp serdes.ClientLoginResp) bool { return true })
+	if resp.UserId != stayId {
+		t.Fatalf("Logged in as %d, expected %d", resp.UserId, stayId)
+	}
+
+	eventually(t, "Expected only the user that stayed to be logged into the restarted server", func() bool {
+		users := 0
+		for _, proxy := range srv.Proxies() {
+			_, ok := proxy.GetUser(leaveId)
+			if ok { return false }
+			users += len(proxy.Users())
+		}
+		return users == 1
+	})
+}
+
+// Logs in a user whose character was saved in another zone. The first server redirects them, and the proxy hands them over to the server that owns their zone
+func TestZoneTransfer(t *testing.T) {
+	secret := []byte("secret")
+	userId := uint64(1)
+
+	// Note: Both servers share the store, like they would share a database
+	store := server.NewMemoryStore()
+	err := store.Save(userId, []ecs.Component{ecs.C(mmo.ZoneId(1))})
+	if err != nil { panic(err) }
+
+	srv0, _ := startZoneServer(t, "mem://transfer-server-0", mmo.DefaultZone, store)
+	srv1, _ := startZoneServer(t, "mem://transfer-server-1", 1, store)
+	room := startProxy(t, "mem://transfer-server-0", "mem://transfer-proxy", secret)
+	err = room.DialServer(1, "mem://transfer-server-1")
+	if err != nil { panic(err) }
+
+	_, recv := dialClient(t, "mem://transfer-proxy", secret, userId)
+	resp := waitFor(t, recv, func(resp serdes.ClientLoginResp) bool { return true })
+	if resp.UserId != userId || resp.Zone != 1 {
+		t.Fatalf("Expected user %d to log into zone 1, got %+v", userId, resp)
+	}
+
+	clientConn := room.GetClientConn(userId)
+	if clientConn == nil || clientConn.zone != 1 {
+		t.Errorf("Expected the proxy to route the user to zone 1")
+	}
+	for _, proxy := range srv0.Proxies() {
+		if _, ok := proxy.GetUser(userId); ok {
+			t.Errorf("Expected the user not to be logged into zone 0")
+		}
+	}
+	loggedIn := false
+	for _, proxy := range srv1.Proxies() {
+		_, ok := proxy.GetUser(userId)
+		loggedIn = loggedIn || ok
+	}
+	if !loggedIn {
+		t.Errorf("Expected the user to be logged into zone 1")
+	}
+}
+
+// Runs a handful of bots through the proxy with latency and loss, and checks that they all log in and get a steady stream of updates
+func TestBots(t *testing.T) {
+	secret := []byte("secret")
+	startServer(t, "mem://bots-server")
+	startProxy(t, "mem://bots-server", "mem://bots-proxy", secret)
+
+	count := 5
+	report := bot.Run(bot.Config{
+		ProxyUrl: "mem://bots-proxy?latency=10ms&jitter=10ms&loss=0.05&seed=1",
+		TokenSecret: secret,
+		Count: count,
+		FirstUserId: 100,
+		Ramp: 10 * time.Millisecond,
+		Duration: 2 * time.Second,
+		ChatInterval: 500 * time.Millisecond,
+		Seed: 1,
+	}, nil)
+
+	t.Logf("%+v", report)
+	if report.LoggedIn != count {
+		t.Fatalf("Expected %d bots to be logged in, got %d: %+v", count, report.LoggedIn, report)
+	}
+	if report.Disconnects != 0 || report.Rejects != 0 || report.Kicks != 0 {
+		t.Errorf("Expected no disconnects: %+v", report)
+	}
+	if report.Updates == 0 || report.RttSamples == 0 {
+		t.Fatalf("Expected world updates and round trip times: %+v", report)
+	}
+	// Note: The round trip covers both hops plus waiting for the server's next network tick
+	if report.RttP50 < 20 * time.Millisecond {
+		t.Errorf("Round trip time is shorter than the injected latency: %v", report.RttP50)
+	}
+	if report.ChatSent == 0 || report.ChatReceived == 0 {
+		t.Errorf("Expected the bots to chat: %+v", report)
+	}
+}
+
+// An admin kicks a bot. The proxy forwards the kick, and the bot counts it as a kick instead of a rejection and stops reconnecting
+func TestBotKicked(t *testing.T) {
+	secret := []byte("secret")
+	userId := uint64(100)
+	srv, _ := startServer(t, "mem://kick-server")
+	startProxy(t, "mem://kick-server", "mem://kick-proxy", secret)
+
+	stop := make(chan struct{})
+	reports := make(chan bot.Report, 1)
+	go func() {
+		reports <- bot.Run(bot.Config{
+			ProxyUrl: "mem://kick-proxy",
+			TokenSecret: secret,
+			Count: 1,
+			FirstUserId: userId,
+			Seed: 1,
+		}, stop)
+	}()
+
+	var proxyConn *server.ServerConn
+	eventually(t, "Bot never logged in", func() bool {
+		for _, proxy := range srv.Proxies() {
+			_, ok := proxy.GetUser(userId)
+			if ok {
+				proxyConn = proxy
+				return true
+			}
+		}
+		return false
+	})
+
+	err := proxyConn.Send(serdes.UserKick{userId, "being rude"})
+	if err != nil { panic(err) }
+	eventually(t, "Bot never left the proxy", func() bool {
+		_, ok := proxyConn.GetUser(userId)
+		return !ok
+	})
+
+	close(stop)
+	report := <-reports
+	t.Logf("%+v", report)
+	if report.Kicks != 1 || report.Rejects != 0 || report.Disconnects != 0 {
+		t.Errorf("Expected one kick: %+v", report)
+	}
+	if report.Logins != 1 || report.LoggedIn != 0 {
+		t.Errorf("Expected the bot to stay logged out after the kick: %+v", report)
+	}
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+	"bufio"
+	"errors"
+	"strings"
+	"strconv"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/stat"
+)
+
+var ErrGameLoopStopped = errors.New("game loop isn't running")
+
+// How long a command waits for the game loop before giving up
+const adminTimeout = 5 * time.Second
+
+const adminHelp = `proxies                   List the connected proxies
+users                     List the logged in users and their entities
+kick <userId> [reason]    Log the user out and disconnect them from their proxy
+teleport <entity> <x> <y> Move the entity
+broadcast <text>          Send a system chat message to everyone on this server
+dump <entity>             Print the entity's components
+tickrate [hz]             Print or change how many physics ticks run per second
+profile                   Print how long the tick and each system take to run`
+
+// A console for inspecting and managing the server while it runs. Commands are sent one per line (See: adminHelp)
+//   - Kicks go through the networkChannel, just like logouts from the proxies
+//   - Anything else that reads or writes the world runs on the game loop (See: CreateAdminSystem)
+type Admin struct {
+	world *ecs.World
+	server *Server
+	networkChannel chan serdes.WorldUpdate
+	chat *ChatRouter
+	schedule *mmo.Scheduler
+
+	queries chan func()
+	tickStep time.Duration // The scheduler's current fixed time step
+}
+
+func NewAdmin(world *ecs.World, server *Server, networkChannel chan serdes.WorldUpdate, chat *ChatRouter, schedule *mmo.Scheduler) *Admin {
+	return &Admin{
+		world: world,
+		server: server,
+		networkChannel: networkChannel,
+		chat: chat,
+		schedule: schedule,
+		queries: make(chan func()),
+		tickStep: mmo.FixedTimeStep,
+	}
+}
+
+// Runs the queries that are waiting on the admin console
+func CreateAdminSystem(admin *Admin) ecs.System {
+	sys := ecs.System{"AdminQueries", func(dt time.Duration) {
+		for {
+			select {
+			case query := <-admin.queries:
+				query()
+			default:
+				return
+			}
+		}
+	}}
+	return sys
+}
+
+// Runs the function on the game loop and waits for it to finish
+func (a *Admin) onGameLoop(f func()) error {
+	done := make(chan struct{})
+	select {
+	case a.queries <- func() { f(); close(done) }:
+	case <-time.After(adminTimeout):
+		return ErrGameLoopStopped
+	}
+	<-done
+	return nil
+}
+
+// Listens for admin connections on the url (ie tcp://127.0.0.1:9100 or unix:///tmp/mmo-admin.sock)
+// Note: There is no authentication, anyone that can connect has full control of the server. So only ever listen on a local address
+func (a *Admin) Listen(uri string) (net.Listener, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	address := u.Host
+	if u.Scheme == "unix" {
+		address = u.Path
+		os.Remove(address) // Note: The socket file is left behind if the server didn't exit cleanly
+	}
+
+	listener, err := net.Listen(u.Scheme, address)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Warn().Err(err).Msg("Admin console stopped accepting connections")
+				return
+			}
+			go a.serve(conn)
+		}
+	}()
+	return listener, nil
+}
+
+func (a *Admin) serve(conn net.Conn) {
+	defer conn.Close()
+	log.Print("Admin connected: ", conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" { continue }
+
+		log.Print("Admin: ", line)
+		out, err := a.Run(line)
+		if err != nil {
+			out = "error: " + err.Error()
+		}
+		_, err = fmt.Fprintln(conn, out)
+		if err != nil { return }
+	}
+}
+
+// Runs a single command and returns its output
+func (a *Admin) Run(line string) (string, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return "", nil
+	}
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "help":
+		return adminHelp, nil
+	case "proxies":
+		return a.listProxies(), nil
+	case "users":
+		return a.listUsers(), nil
+	case "kick":
+		if len(args) < 1 { return "", fmt.Errorf("usage: kick <userId> [reason]") }
+		userId, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil { return "", err }
+		reason := strings.Join(args[1:], " ")
+		if reason == "" {
+			reason = "No reason given"
+		}
+		return a.kick(userId, reason)
+	case "teleport":
+		if len(args) != 3 { return "", fmt.Errorf("usage: teleport <entity> <x> <y>") }
+		id, err := parseEntity(args[0])
+		if err != nil { return "", err }
+		x, err := strconv.ParseFloat(args[1], 64)
+		if err != nil { return "", err }
+		y, err := strconv.ParseFloat(args[2], 64)
+		if err != nil { return "", err }
+		return a.teleport(id, phy2.Pos{x, y})
+	case "broadcast":
+		if len(args) == 0 { return "", fmt.Errorf("usage: broadcast <text>") }
+		text := strings.Join(args, " ")
+		a.chat.Push(0, serdes.ChatMessage{Channel: mmo.ChannelSystem, Text: text})
+		return "Sent: " + text, nil
+	case "dump":
+		if len(args) != 1 { return "", fmt.Errorf("usage: dump <entity>") }
+		id, err := parseEntity(args[0])
+		if err != nil { return "", err }
+		return a.dump(id)
+	case "tickrate":
+		if len(args) == 0 {
+			return a.tickRate()
+		}
+		hz, err := strconv.ParseFloat(args[0], 64)
+		if err != nil { return "", err }
+		return a.setTickRate(hz)
+	case "profile":
+		return a.profile(), nil
+	}
+	return "", fmt.Errorf("unknown command %q, try help", cmd)
+}
+
+func parseEntity(s string) (ecs.Id, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return ecs.InvalidEntity, fmt.Errorf("invalid entity: %s", s)
+	}
+	return ecs.Id(id), nil
+}
+
+func (a *Admin) listProxies() string {
+	proxies := a.server.Proxies()
+	lines := make([]string, 0, len(proxies))
+	for proxyId, proxyConn := range proxies {
+		lines = append(lines, fmt.Sprintf("proxy %d: %d users, %d suspicious inputs", proxyId, proxyConn.GetStats(), proxyConn.Violations()))
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%d proxies\n%s", len(proxies), strings.Join(lines, "\n"))
+}
+
+func (a *Admin) listUsers() string {
+	lines := make([]string, 0)
+	for proxyId, proxyConn := range a.server.Proxies() {
+		for userId, id := range proxyConn.Users() {
+			lines = append(lines, fmt.Sprintf("user %d: entity %d on proxy %d, %d suspicious inputs", userId, id, proxyId, proxyConn.UserViolations(userId)))
+		}
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%d users\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// Logs the user out the same way as a logout from their proxy would, then tells the proxy to disconnect them
+func (a *Admin) kick(userId uint64, reason string) (string, error) {
+	for _, proxyConn := range a.server.Proxies() {
+		id, ok := proxyConn.GetUser(userId)
+		if !ok { continue }
+
+		a.networkChannel <- serdes.WorldUpdate{
+			UserId: userId,
+			Delete: []ecs.Id{id},
+		}
+		proxyConn.LogoutUser(userId)
+
+		err := proxyConn.Send(serdes.UserKick{userId, reason})
+		if err != nil {
+			log.Warn().Err(err).Uint64(stat.UserId, userId).Msg("Failed to send kick")
+		}
+		return fmt.Sprintf("Kicked user %d: %s", userId, reason), nil
+	}
+	return "", fmt.Errorf("user %d isn't logged in", userId)
+}
+
+func (a *Admin) teleport(id ecs.Id, pos phy2.Pos) (string, error) {
+	// Note: Writing to an entity that doesn't exist would create it, so we check in the same step. Otherwise a logout could delete it in between
+	exists := false
+	err := a.onGameLoop(func() {
+		_, exists = ecs.Read[phy2.Pos](a.world, id)
+		if exists {
+			ecs.Write(a.world, id, ecs.C(pos))
+		}
+	})
+	if err != nil { return "", err }
+	if !exists {
+		return "", fmt.Errorf("entity %d doesn't have a position", id)
+	}
+	return fmt.Sprintf("Teleported entity %d to (%.1f, %.1f)", id, pos.X, pos.Y), nil
+}
+
+func (a *Admin) dump(id ecs.Id) (string, error) {
+	var lines []string
+	err := a.onGameLoop(func() {
+		lines = dumpComponents(a.world, id)
+	})
+	if err != nil { return "", err }
+	if len(lines) == 0 {
+		return "", fmt.Errorf("entity %d doesn't have any known components", id)
+	}
+	return fmt.Sprintf("entity %d\n%s", id, strings.Join(lines, "\n")), nil
+}
+
+// Returns a line for each of the entity's components
+// Note: The ecs can't list an entity's components, so this only finds the types that are listed here
+func dumpComponents(world *ecs.World, id ecs.Id) []string {
+	lines := make([]string, 0)
+	add := func(comp any, ok bool) {
+		if !ok { return }
+		lines = append(lines, fmt.Sprintf("  %T %+v", comp, comp))
+	}
+	add(ecs.Read[User](world, id))
+	add(ecs.Read[ClientTick](world, id))
+	add(ecs.Read[phy2.Pos](world, id))
+	add(ecs.Read[mmo.Body](world, id))
+	add(ecs.Read[mmo.ZoneId](world, id))
+	add(ecs.Read[mmo.Input](world, id))
+	add(ecs.Read[mmo.Speech](world, id))
+	add(ecs.Read[phy2.CircleCollider](world, id))
+
+	replication, ok := ecs.Read[Replication](world, id)
+	if ok {
+		lines = append(lines, fmt.Sprintf("  server.Replication {Known: %d entities}", len(replication.Known)))
+	}
+	return lines
+}
+
+func (a *Admin) tickRate() (string, error) {
+	var step time.Duration
+	err := a.onGameLoop(func() {
+		step = a.tickStep
+	})
+	if err != nil { return "", err }
+	return fmt.Sprintf("%.1f ticks per second (%s per tick)", float64(time.Second) / float64(step), step), nil
+}
+
+// Changes the scheduler's fixed time step
+// Note: Clients predict with mmo.FixedTimeStep, so anything else shows up as prediction error. This is mostly useful for seeing how the game behaves when the server can't keep up
+func (a *Admin) setTickRate(hz float64) (string, error) {
+	if hz < 1 || hz > 1000 {
+		return "", fmt.Errorf("tick rate must be between 1 and 1000")
+	}
+	step := time.Duration(float64(time.Second) / hz)
+
+	// Note: The scheduler isn't threadsafe, so this has to happen between its ticks
+	err := a.onGameLoop(func() {
+		a.schedule.SetFixedTimeStep(step)
+		a.tickStep = step
+	})
+	if err != nil { return "", err }
+	return a.tickRate()
+}
+
+func (a *Admin) profile() string {
+	report := a.schedule.Profiler.Report()
+	lines := make([]string, 0, len(report.Systems))
+	for _, s := range report.Systems {
+		lines = append(lines, fmt.Sprintf("%s: %s p50, %s p95, %s p99, %s max", s.Name, s.P50, s.P95, s.P99, s.Max))
+	}
+	tick := report.Tick
+	return fmt.Sprintf("tick: %s p50, %s p95, %s p99, %s max, %d of %d ticks overran\n%s", tick.P50, tick.P95, tick.P99, tick.Max, report.Overruns, report.Ticks, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unitoftime/ecs"
+	flownet "github.com/unitoftime/flow/net"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+func TestAdmin(t *testing.T) {
+	world := ecs.NewWorld()
+	persister := NewPersister(NewMemoryStore())
+	networkChannel := make(chan serdes.WorldUpdate, 16)
+	chat := NewChatRouter()
+
+	server := NewServer(nil, nil)
+	serverConn := NewServerConn(&flownet.Socket{}, 0) // Note: This socket isn't connected, so the kick fails to send
+	server.AddProxy(0, serverConn)
+
+	id := world.NewId()
+	ecs.Write(world, id, ecs.C(User{Id: 1, ProxyId: 0}), ecs.C(phy2.Pos{10, 20}), ecs.C(mmo.Body{}))
+	serverConn.LoginUser(1, id, time.Now())
+
+	admin := NewAdmin(world, server, networkChannel, chat, mmo.GetScheduler())
+
+	// Stand in for the game loop
+	adminSys := CreateAdminSystem(admin)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				close(stopped)
+				return
+			default:
+			}
+			applyNetworkUpdates(world, networkChannel, persister)
+			adminSys.Func(mmo.FixedTimeStep)
+		}
+	}()
+
+	run := func(line string) string {
+		out, err := admin.Run(line)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		return out
+	}
+
+	if out := run("proxies"); !strings.Contains(out, "proxy 0: 1 users") {
+		t.Errorf("Unexpected proxies: %s", out)
+	}
+	if out := run("users"); !strings.Contains(out, "user 1: entity") {
+		t.Errorf("Unexpected users: %s", out)
+	}
+	if out := run(fmt.Sprintf("dump %d", id)); !strings.Contains(out, "phy2.Pos {X:10 Y:20}") {
+		t.Errorf("Unexpected dump: %s", out)
+	}
+	if out := run("tickrate 32"); !strings.Contains(out, "31.25ms per tick") {
+		t.Errorf("Unexpected tick rate: %s", out)
+	}
+	if out := run("profile"); !strings.HasPrefix(out, "tick: ") {
+		t.Errorf("Unexpected profile: %s", out)
+	}
+
+	// Anything that reads a missing entity is an error, and doesn't create it
+	for _, line := range []string{"teleport 9999 1 1", "dump 9999", "kick 9999", "nonsense", "tickrate 0"} {
+		_, err := admin.Run(line)
+		if err == nil {
+			t.Errorf("Expected an error for: %s", line)
+		}
+	}
+
+	run(fmt.Sprintf("teleport %d 50 60", id))
+
+	run("broadcast hello everyone")
+	queue := chat.copyAndClear()
+	if len(queue) != 1 || queue[0].msg.Channel != mmo.ChannelSystem || queue[0].msg.Text != "hello everyone" {
+		t.Errorf("Expected a system message to be queued, got %v", queue)
+	}
+
+	// The console works the same over a connection
+	listener, err := admin.Listen("tcp://127.0.0.1:0")
+	if err != nil { panic(err) }
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil { panic(err) }
+	defer conn.Close()
+	_, err = conn.Write([]byte("users\n"))
+	if err != nil { panic(err) }
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "1 users\n" {
+		t.Errorf("Unexpected response: %q %v", line, err)
+	}
+
+	// Note: The teleport was written on the game loop before the kick, so the user is saved at their new position
+	run("kick 1 being rude")
+	if _, ok := serverConn.GetUser(1); ok {
+		t.Errorf("Expected the user to be logged out")
+	}
+
+	close(stop)
+	<-stopped
+	applyNetworkUpdates(world, networkChannel, persister)
+
+	if _, ok := ecs.Read[User](world, id); ok {
+		t.Errorf("Expected the kicked user to be deleted")
+	}
+	persister.Flush()
+	character, err := persister.Load(1)
+	if err != nil || character[0] != ecs.C(phy2.Pos{50, 60}) {
+		t.Errorf("Expected the kicked user to be saved where they were teleported to, got %v %v", character, err)
+	}
+	if _, ok := ecs.Read[phy2.Pos](world, 9999); ok {
+		t.Errorf("Expected the missing entity not to be created")
+	}
+}
+
+// The user's logout is queued when the teleport checks that they exist. Applying the logout afterwards must not bring them back as a ghost entity
+func TestAdminTeleportDuringLogout(t *testing.T) {
+	world := ecs.NewWorld()
+	persister := NewPersister(NewMemoryStore())
+	networkChannel := make(chan serdes.WorldUpdate, 16)
+
+	id := world.NewId()
+	ecs.Write(world, id, ecs.C(User{Id: 1, ProxyId: 0}), ecs.C(phy2.Pos{10, 20}), ecs.C(mmo.Body{}))
+
+	admin := NewAdmin(world, NewServer(nil, nil), networkChannel, NewChatRouter(), mmo.GetScheduler())
+	adminSys := CreateAdminSystem(admin)
+
+	networkChannel <- serdes.WorldUpdate{UserId: 1, Delete: []ecs.Id{id}}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := admin.Run(fmt.Sprintf("teleport %d 50 60", id))
+		result <- err
+	}()
+
+	// Stand in for the game loop until the teleport is done
+	var err error
+	Loop:
+	for {
+		adminSys.Func(mmo.FixedTimeStep)
+		select {
+		case err = <-result:
+			break Loop
+		default:
+		}
+	}
+	if err != nil {
+		t.Fatalf("Expected the teleport to succeed: %v", err)
+	}
+	applyNetworkUpdates(world, networkChannel, persister)
+
+	if _, ok := ecs.Read[phy2.Pos](world, id); ok {
+		t.Errorf("Expected the logged out user not to be recreated")
+	}
+	persister.Flush()
+	character, err := persister.Load(1)
+	if err != nil || character[0] != ecs.C(phy2.Pos{50, 60}) {
+		t.Errorf("Expected the user to be saved where they were teleported to, got %v %v", character, err)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"time"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/stat"
+)
+
+type queuedChat struct {
+	proxyId uint64 // The proxy that the message came from
+	msg serdes.ChatMessage
+}
+
+// Queues chat messages from the network so they can be routed on the game loop, where we can read the positions of the users
+type ChatRouter struct {
+	mu sync.Mutex
+	queue []queuedChat
+}
+
+func NewChatRouter() *ChatRouter {
+	return &ChatRouter{
+		queue: make([]queuedChat, 0),
+	}
+}
+
+func (r *ChatRouter) Push(proxyId uint64, msg serdes.ChatMessage) {
+	r.mu.Lock()
+	r.queue = append(r.queue, queuedChat{proxyId, msg})
+	r.mu.Unlock()
+}
+
+func (r *ChatRouter) copyAndClear() []queuedChat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]queuedChat, len(r.queue))
+	copy(ret, r.queue)
+	r.queue = r.queue[:0]
+	return ret
+}
+
+type chatUser struct {
+	id ecs.Id
+	proxyId uint64
+	pos phy2.Pos
+}
+
+// Sends the message to the user through the proxy that they are connected to
+// Note: The proxy delivers chat to the user even if they are in a different zone, so this works for users that aren't on this server too
+func sendChat(server *Server, proxyId uint64, userId uint64, msg serdes.ChatMessage) {
+	proxy, ok := server.GetProxy(proxyId)
+	if !ok { return } // Skip: proxy disconnected
+
+	msg.UserId = userId
+	err := proxy.Send(msg)
+	if err != nil {
+		log.Warn().Err(err).Uint64(stat.UserId, userId).Msg("Failed to send chat")
+	}
+}
+
+// Routes every queued chat message to the users that should receive it
+func CreateChatSystem(world *ecs.World, server *Server, router *ChatRouter) ecs.System {
+	sys := ecs.System{"RouteChat", func(dt time.Duration) {
+		queue := router.copyAndClear()
+		if len(queue) == 0 { return }
+
+		users := make(map[uint64]chatUser)
+		ecs.Map2(world, func(id ecs.Id, user *User, pos *phy2.Pos) {
+			users[user.Id] = chatUser{id, user.ProxyId, *pos}
+		})
+
+		for _, q := range queue {
+			msg := q.msg
+			msg.From = msg.UserId
+
+			switch msg.Channel {
+			case mmo.ChannelSay:
+				sender, ok := users[msg.From]
+				if !ok { continue } // Skip: sender isn't on this server
+
+				// Show the speech bubble over the sender
+				ecs.Write(world, sender.id, ecs.C(mmo.Speech{Text: msg.Text}))
+
+				for userId, user := range users {
+					dx := user.pos.X - sender.pos.X
+					dy := user.pos.Y - sender.pos.Y
+					if (dx * dx) + (dy * dy) > mmo.ChatDistance * mmo.ChatDistance {
+						continue // Skip: too far away to hear
+					}
+					sendChat(server, user.proxyId, userId, msg)
+				}
+
+			case mmo.ChannelGlobal, mmo.ChannelSystem:
+				for userId, user := range users {
+					sendChat(server, user.proxyId, userId, msg)
+				}
+
+			case mmo.ChannelWhisper:
+				target, ok := users[msg.Target]
+				if !ok {
+					sendChat(server, q.proxyId, msg.From, serdes.ChatMessage{
+						Channel: mmo.ChannelSystem,
+						Text: fmt.Sprintf("User %d is not online", msg.Target),
+					})
+					continue
+				}
+				sendChat(server, target.proxyId, msg.Target, msg)
+				if msg.Target != msg.From {
+					sendChat(server, q.proxyId, msg.From, msg) // Echo it back so the sender can see it in their history
+				}
+
+			default:
+				log.Error().Uint64(stat.UserId, msg.From).Msg(fmt.Sprintf("Unknown chat channel: %d", msg.Channel))
+			}
+		}
+	}}
+	return sys
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
+)
+
+// A world with two proxies connected. The chat that each proxy receives ends up on its end of the pipe
+type chatTest struct {
+	world *ecs.World
+	router *ChatRouter
+	system ecs.System
+	proxies map[uint64]transport.PollSocket
+}
+
+func newChatTest() *chatTest {
+	world := ecs.NewWorld()
+	server := NewServer(nil, nil)
+	proxies := make(map[uint64]transport.PollSocket)
+	for _, proxyId := range []uint64{1, 2} {
+		serverSock, proxySock := transport.NewMemPipe(serdes.New())
+		server.AddProxy(proxyId, NewServerConn(serverSock, proxyId))
+		proxies[proxyId] = proxySock
+	}
+	router := NewChatRouter()
+	return &chatTest{
+		world: world,
+		router: router,
+		system: CreateChatSystem(world, server, router),
+		proxies: proxies,
+	}
+}
+
+func (c *chatTest) addUser(userId, proxyId uint64, pos phy2.Pos) ecs.Id {
+	id := c.world.NewId()
+	ecs.Write(c.world, id, ecs.C(User{userId, proxyId}), ecs.C(pos))
+	return id
+}
+
+func (c *chatTest) run() {
+	c.system.Func(mmo.FixedTimeStep)
+}
+
+// Returns the chat that the proxy received, mapped to the user that it was for
+func (c *chatTest) received(proxyId uint64) map[uint64][]serdes.ChatMessage {
+	ret := make(map[uint64][]serdes.ChatMessage)
+	for {
+		msg, ok, err := c.proxies[proxyId].Poll()
+		if !ok { break }
+		if err != nil { panic(err) }
+		chat := msg.(serdes.ChatMessage)
+		ret[chat.UserId] = append(ret[chat.UserId], chat)
+	}
+	return ret
+}
+
+func TestChatSay(t *testing.T) {
+	c := newChatTest()
+	speakerId := c.addUser(1, 1, phy2.Pos{0, 0})
+	c.addUser(2, 1, phy2.Pos{mmo.ChatDistance, 0}) // Right on the edge
+	c.addUser(3, 2, phy2.Pos{mmo.ChatDistance + 1, 0})
+	c.addUser(4, 2, phy2.Pos{0, -10})
+
+	c.router.Push(1, serdes.ChatMessage{UserId: 1, Channel: mmo.ChannelSay, Text: "hello"})
+	c.run()
+
+	proxy1 := c.received(1)
+	proxy2 := c.received(2)
+	for _, userId := range []uint64{1, 2} {
+		if len(proxy1[userId]) != 1 || proxy1[userId][0].Text != "hello" || proxy1[userId][0].From != 1 {
+			t.Errorf("Expected user %d to hear the speaker, got %v", userId, proxy1[userId])
+		}
+	}
+	if len(proxy2[4]) != 1 || proxy2[4][0].From != 1 {
+		t.Errorf("Expected user 4 to hear the speaker through their own proxy, got %v", proxy2[4])
+	}
+	if len(proxy2[3]) != 0 {
+		t.Errorf("Expected user 3 to be too far away to hear, got %v", proxy2[3])
+	}
+
+	speech, _ := ecs.Read[mmo.Speech](c.world, speakerId)
+	if speech.Text != "hello" {
+		t.Errorf("Expected a speech bubble over the speaker, got %v", speech)
+	}
+
+	// Users on other servers can't say anything here
+	c.router.Push(1, serdes.ChatMessage{UserId: 9, Channel: mmo.ChannelSay, Text: "hello"})
+	c.run()
+	if len(c.received(1)) != 0 || len(c.received(2)) != 0 {
+		t.Errorf("Expected nobody to hear a user who isn't on this server")
+	}
+}
+
+func TestChatGlobalAndSystem(t *testing.T) {
+	c := newChatTest()
+	c.addUser(1, 1, phy2.Pos{0, 0})
+	c.addUser(2, 1, phy2.Pos{10000, 0})
+	c.addUser(3, 2, phy2.Pos{0, 10000})
+
+	// Note: Global messages can come from users in other zones
+	c.router.Push(2, serdes.ChatMessage{UserId: 9, Channel: mmo.ChannelGlobal, Text: "global"})
+	c.router.Push(0, serdes.ChatMessage{Channel: mmo.ChannelSystem, Text: "system"})
+	c.run()
+
+	proxy1 := c.received(1)
+	proxy2 := c.received(2)
+	got := map[uint64][]serdes.ChatMessage{
+		1: proxy1[1],
+		2: proxy1[2],
+		3: proxy2[3],
+	}
+	for userId, msgs := range got {
+		if len(msgs) != 2 {
+			t.Errorf("Expected user %d to get both messages, got %v", userId, msgs)
+			continue
+		}
+		if msgs[0].Channel != mmo.ChannelGlobal || msgs[0].Text != "global" || msgs[0].From != 9 {
+			t.Errorf("Expected user %d to get the global message first, got %v", userId, msgs[0])
+		}
+		if msgs[1].Channel != mmo.ChannelSystem || msgs[1].Text != "system" || msgs[1].From != 0 {
+			t.Errorf("Expected user %d to get the system message second, got %v", userId, msgs[1])
+		}
+	}
+}
+
+func TestChatWhisper(t *testing.T) {
+	c := newChatTest()
+	c.addUser(1, 1, phy2.Pos{0, 0})
+	c.addUser(2, 2, phy2.Pos{10000, 0})
+
+	// The target gets it through their proxy, and the sender gets an echo through theirs
+	c.router.Push(1, serdes.ChatMessage{UserId: 1, Channel: mmo.ChannelWhisper, Target: 2, Text: "psst"})
+	c.run()
+	proxy1 := c.received(1)
+	proxy2 := c.received(2)
+	if len(proxy2[2]) != 1 || proxy2[2][0].Text != "psst" || proxy2[2][0].From != 1 {
+		t.Errorf("Expected the target to get the whisper, got %v", proxy2[2])
+	}
+	if len(proxy1[1]) != 1 || proxy1[1][0].Text != "psst" || proxy1[1][0].Target != 2 {
+		t.Errorf("Expected the whisper to be echoed back to the sender, got %v", proxy1[1])
+	}
+
+	// The sender can be in another zone, so the echo goes back through the proxy that the whisper came from
+	c.router.Push(2, serdes.ChatMessage{UserId: 9, Channel: mmo.ChannelWhisper, Target: 1, Text: "from afar"})
+	c.run()
+	proxy1 = c.received(1)
+	proxy2 = c.received(2)
+	if len(proxy1[1]) != 1 || proxy1[1][0].From != 9 {
+		t.Errorf("Expected the target to get the whisper, got %v", proxy1[1])
+	}
+	if len(proxy2[9]) != 1 || proxy2[9][0].Text != "from afar" {
+		t.Errorf("Expected the echo to go back through the sender's proxy, got %v", proxy2[9])
+	}
+
+	// Whispering yourself isn't echoed twice
+	c.router.Push(1, serdes.ChatMessage{UserId: 1, Channel: mmo.ChannelWhisper, Target: 1, Text: "me"})
+	c.run()
+	proxy1 = c.received(1)
+	if len(proxy1[1]) != 1 {
+		t.Errorf("Expected one copy of a whisper to yourself, got %v", proxy1[1])
+	}
+}
+
+func TestChatWhisperOffline(t *testing.T) {
+	c := newChatTest()
+	c.addUser(1, 1, phy2.Pos{0, 0})
+	c.addUser(2, 2, phy2.Pos{0, 0})
+
+	c.router.Push(1, serdes.ChatMessage{UserId: 1, Channel: mmo.ChannelWhisper, Target: 3, Text: "hello?"})
+	c.run()
+
+	proxy1 := c.received(1)
+	proxy2 := c.received(2)
+	if len(proxy1[1]) != 1 {
+		t.Fatalf("Expected the sender to be told that the target isn't online, got %v", proxy1[1])
+	}
+	reply := proxy1[1][0]
+	if reply.Channel != mmo.ChannelSystem || reply.Text != "User 3 is not online" {
+		t.Errorf("Expected a system message that user 3 is not online, got %v", reply)
+	}
+	if len(proxy2) != 0 {
+		t.Errorf("Expected nobody else to get anything, got %v", proxy2)
+	}
+}
+
+func TestChatRouter(t *testing.T) {
+	c := newChatTest()
+	c.addUser(1, 1, phy2.Pos{0, 0})
+
+	// Nothing queued, nothing sent
+	c.run()
+	if len(c.received(1)) != 0 {
+		t.Errorf("Expected nothing to be sent")
+	}
+
+	// Everything queued is sent in order, and only once
+	texts := []string{"a", "b", "c"}
+	for _, text := range texts {
+		c.router.Push(1, serdes.ChatMessage{UserId: 1, Channel: mmo.ChannelGlobal, Text: text})
+	}
+	c.run()
+	msgs := c.received(1)[1]
+	if len(msgs) != len(texts) {
+		t.Fatalf("Expected %d messages, got %v", len(texts), msgs)
+	}
+	for i := range texts {
+		if msgs[i].Text != texts[i] {
+			t.Errorf("Message %d: Expected %s, got %s", i, texts[i], msgs[i].Text)
+		}
+	}
+	c.run()
+	if len(c.received(1)) != 0 {
+		t.Errorf("Expected the queue to be cleared after routing")
+	}
+
+	// Messages with an unknown channel are dropped
+	c.router.Push(1, serdes.ChatMessage{UserId: 1, Channel: mmo.ChatChannel(99), Text: "?"})
+	c.run()
+	if len(c.received(1)) != 0 {
+		t.Errorf("Expected unknown channels to be dropped")
+	}
+	if len(c.router.copyAndClear()) != 0 {
+		t.Errorf("Expected the queue to be empty")
+	}
+}
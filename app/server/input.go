@@ -0,0 +1,95 @@
+package server
+
+import (
+	"time"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/tile"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// The most inputs that can wait to be applied. Anything older than this gets dropped so that a user can't build up a backlog
+const maxQueuedInputs = maxInputTickJump
+
+// The inputs that have been accepted from a user, but haven't been applied to their character yet
+type InputQueue struct {
+	inputs []serdes.TickInput // Oldest first
+	ack ClientTick // The last server tick that the user has received and the one they are rendering, from their newest input message
+}
+
+func NewInputQueue() *InputQueue {
+	return &InputQueue{
+		inputs: make([]serdes.TickInput, 0),
+	}
+}
+
+// Adds the inputs to the end of the queue. They must have already been validated (See: InputValidator)
+func (q *InputQueue) Push(inputs []serdes.TickInput) {
+	q.inputs = append(q.inputs, inputs...)
+	if len(q.inputs) > maxQueuedInputs {
+		q.inputs = q.inputs[len(q.inputs) - maxQueuedInputs:]
+	}
+}
+
+func (q *InputQueue) SetAck(msg serdes.PlayerInput) {
+	q.ack.AckTick = msg.AckTick
+	q.ack.RenderTick = msg.RenderTick
+	q.ack.Acked = msg.Acked
+}
+
+// Removes and returns every queued input, oldest first
+func (q *InputQueue) Pop() []serdes.TickInput {
+	ret := q.inputs
+	q.inputs = make([]serdes.TickInput, 0)
+	return ret
+}
+
+// Applies each user's queued inputs once per network tick
+//   - The newest input becomes the character's input for the NetworkTickDivider physics ticks until the next world update, which is what the client predicted
+//   - Older inputs arrived late (ie their first message was lost), so they are caught up on right away, as if they had arrived in time
+//   - If nothing arrived, the character stands still. The input gets caught up on if it shows up later
+// Note: This must run before MoveCharacters, and it lines up with ServerSendUpdate by counting the same physics ticks
+func CreateApplyInputSystem(world *ecs.World, server *Server, tilemap *tile.Tilemap) ecs.System {
+	physicsTick := 0
+	return ecs.System{"ApplyInputs", func(dt time.Duration) {
+		apply := (physicsTick == 0)
+		physicsTick = (physicsTick + 1) % mmo.NetworkTickDivider
+		if !apply { return }
+
+		// Note: The ClientTicks are written after the loop, because adding a component during the loop would move the entity
+		clientTicks := make(map[ecs.Id]ClientTick)
+		ecs.Map4(world, func(id ecs.Id, user *User, input *mmo.Input, pos *phy2.Pos, collider *phy2.CircleCollider) {
+			proxy, ok := server.GetProxy(user.ProxyId)
+			if !ok { return }
+
+			inputs, ack, ok := proxy.PopInputs(user.Id)
+			if !ok { return }
+
+			clientTick, hasClientTick := ecs.Read[ClientTick](world, id)
+			if len(inputs) == 0 {
+				*input = mmo.Input{}
+				if !hasClientTick { return } // Note: Users don't get world updates until we've applied their first input
+			} else {
+				for i := 0; i < len(inputs) - 1; i++ {
+					for ii := 0; ii < mmo.NetworkTickDivider; ii++ {
+						mmo.MoveCharacter(&inputs[i].Input, pos, collider, tilemap, mmo.FixedTimeStep)
+					}
+				}
+				newest := inputs[len(inputs) - 1]
+				*input = newest.Input
+				clientTick.Tick = newest.Tick // We just send this field back to the player, we don't use it internally. This is for them to synchronize their client prediction.
+			}
+			clientTick.AckTick = ack.AckTick
+			clientTick.RenderTick = ack.RenderTick
+			clientTick.Acked = ack.Acked
+			clientTicks[id] = clientTick
+		})
+
+		for id, clientTick := range clientTicks {
+			ecs.Write(world, id, ecs.C(clientTick))
+		}
+	}}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"time"
+	"testing"
+	"math/rand"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+type inputResult struct {
+	server, predicted phy2.Pos
+	applied []uint16 // The player ticks that the server reported back, in order
+	lost int // The number of input messages that were lost
+}
+
+// Runs a client that sends one input message per network tick over a connection that loses messages, and returns where the server moved the character
+// Note: Loss is capped at InputRedundancy-1 messages in a row, because anything longer than that loses inputs for good
+func simulateInputs(seed int64, loss float64, networkTicks int) inputResult {
+	rng := rand.New(rand.NewSource(seed))
+	world := ecs.NewWorld()
+	tilemap := mmo.LoadZone(world, mmo.DefaultZone)
+
+	server := NewServer(nil, nil)
+	serverConn := NewServerConn(nil, 0)
+	server.AddProxy(0, serverConn)
+
+	id := world.NewId()
+	collider := phy2.NewCircleCollider(6)
+	ecs.Write(world, id, ecs.C(User{Id: 1, ProxyId: 0}), ecs.C(mmo.Input{}), ecs.C(mmo.SpawnPoint()), ecs.C(collider))
+	now := time.Now()
+	serverConn.LoginUser(1, id, now)
+
+	systems := []ecs.System{
+		CreateApplyInputSystem(world, server, tilemap),
+		ecs.System{"MoveCharacters", func(dt time.Duration) {
+			ecs.Map3(world, func(id ecs.Id, input *mmo.Input, pos *phy2.Pos, collider *phy2.CircleCollider) {
+				mmo.MoveCharacter(input, pos, collider, tilemap, dt)
+			})
+		}},
+	}
+
+	result := inputResult{
+		predicted: mmo.SpawnPoint(),
+		applied: make([]uint16, 0),
+	}
+	sent := make([]serdes.TickInput, 0) // The inputs that the server hasn't acknowledged yet
+	lostInARow := 0
+	for i := 1; i <= networkTicks + mmo.InputRedundancy; i++ {
+		now = now.Add(inputInterval)
+
+		// The client stops moving at the end so that it can flush out the last of its inputs
+		input := mmo.Input{}
+		if i <= networkTicks {
+			dir := rng.Intn(16)
+			input = mmo.Input{dir & 1 != 0, dir & 2 != 0, dir & 4 != 0, dir & 8 != 0}
+		}
+		sent = append(sent, serdes.TickInput{uint16(i), input})
+		for ii := 0; ii < mmo.NetworkTickDivider; ii++ {
+			mmo.MoveCharacter(&input, &result.predicted, &collider, tilemap, mmo.FixedTimeStep)
+		}
+
+		msg := serdes.PlayerInput{UserId: 1, Inputs: sent}
+		if len(sent) > mmo.InputRedundancy {
+			msg.Inputs = sent[len(sent) - mmo.InputRedundancy:]
+		}
+		if i <= networkTicks && lostInARow < mmo.InputRedundancy - 1 && rng.Float64() < loss {
+			lostInARow++
+			result.lost++
+		} else {
+			lostInARow = 0
+			err := serverConn.QueueInputs(1, msg, now)
+			if IsSuspicious(err) {
+				panic(err)
+			}
+		}
+
+		for ii := 0; ii < mmo.NetworkTickDivider; ii++ {
+			for _, sys := range systems {
+				sys.Func(mmo.FixedTimeStep)
+			}
+		}
+
+		// The client finds out which inputs were applied from the next world update
+		clientTick, ok := ecs.Read[ClientTick](world, id)
+		if ok {
+			if len(result.applied) == 0 || result.applied[len(result.applied)-1] != clientTick.Tick {
+				result.applied = append(result.applied, clientTick.Tick)
+			}
+			for len(sent) > 0 && sent[0].Tick <= clientTick.Tick {
+				sent = sent[1:]
+			}
+		}
+	}
+
+	pos, _ := ecs.Read[phy2.Pos](world, id)
+	result.server = pos
+	return result
+}
+
+func TestApplyInputsInOrder(t *testing.T) {
+	result := simulateInputs(1, 0, 200)
+	if result.server != result.predicted {
+		t.Errorf("Expected the server to end up at %v, got %v", result.predicted, result.server)
+	}
+
+	// Every input gets applied in the network tick after it was sent
+	for i := range result.applied {
+		if result.applied[i] != uint16(i + 1) {
+			t.Fatalf("Expected each input to be applied in order, got %v", result.applied)
+		}
+	}
+}
+
+func TestApplyInputsUnderLoss(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		result := simulateInputs(seed, 0.3, 200)
+		if result.lost == 0 {
+			t.Fatalf("Expected some messages to be lost")
+		}
+
+		// The redundant inputs fill in for the lost messages, so the server ends up exactly where the client predicted
+		if result.server != result.predicted {
+			t.Errorf("Seed %d: Expected the server to end up at %v, got %v (%d lost)", seed, result.predicted, result.server, result.lost)
+		}
+		for i := 1; i < len(result.applied); i++ {
+			if mmo.TickDiff(result.applied[i], result.applied[i-1]) <= 0 {
+				t.Fatalf("Seed %d: Expected the applied ticks to move forward, got %v", seed, result.applied)
+			}
+		}
+		if result.applied[len(result.applied)-1] != 200 + mmo.InputRedundancy {
+			t.Errorf("Seed %d: Expected every input to be applied, last was %d", seed, result.applied[len(result.applied)-1])
+		}
+	}
+}
+
+func TestInputQueue(t *testing.T) {
+	q := NewInputQueue()
+	if len(q.Pop()) != 0 {
+		t.Errorf("Expected empty queue")
+	}
+
+	// Too many inputs drops the oldest ones
+	q.Push(make([]serdes.TickInput, maxQueuedInputs + 5))
+	if len(q.Pop()) != maxQueuedInputs {
+		t.Errorf("Expected the queue to be capped at %d", maxQueuedInputs)
+	}
+}
+
+// The ticks that the client has received and is rendering end up on its ClientTick, which is what lag compensation rewinds with
+func TestApplyInputsRenderTick(t *testing.T) {
+	world := ecs.NewWorld()
+	tilemap := mmo.LoadZone(world, mmo.DefaultZone)
+	server := NewServer(nil, nil)
+	serverConn := NewServerConn(nil, 0)
+	server.AddProxy(0, serverConn)
+
+	id := world.NewId()
+	ecs.Write(world, id, ecs.C(User{Id: 1, ProxyId: 0}), ecs.C(mmo.Input{}), ecs.C(mmo.SpawnPoint()), ecs.C(phy2.NewCircleCollider(6)))
+	now := time.Now()
+	serverConn.LoginUser(1, id, now)
+
+	err := serverConn.QueueInputs(1, serdes.PlayerInput{
+		UserId: 1,
+		AckTick: 50,
+		RenderTick: 44,
+		Acked: true,
+		Inputs: []serdes.TickInput{{1, mmo.Input{}}},
+	}, now)
+	if err != nil { panic(err) }
+
+	CreateApplyInputSystem(world, server, tilemap).Func(mmo.FixedTimeStep)
+
+	clientTick, _ := ecs.Read[ClientTick](world, id)
+	if clientTick.AckTick != 50 || clientTick.RenderTick != 44 || !clientTick.Acked {
+		t.Errorf("Expected the client's ticks to be stored, got %+v", clientTick)
+	}
+	tick, ok := RenderTick(clientTick)
+	if !ok || tick != 44 {
+		t.Errorf("Expected to rewind to the client's render tick, got %d", tick)
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/stat"
+)
+
+// Registers the metrics that are read from the server when they are scraped
+// Note: This can only be called once, because metrics can only be registered once
+func registerMetrics(server *Server, networkChannel chan serdes.WorldUpdate) {
+	stat.Default.NewGaugeFunc(stat.NetworkChannelDepthKey, "The number of updates waiting to be applied to the world", func() float64 {
+		return float64(len(networkChannel))
+	})
+
+	stat.Default.OnCollect(func() {
+		// Note: Reset so that proxies that disconnected stop showing up
+		stat.Users.Reset()
+		for proxyId, proxyConn := range server.Proxies() {
+			stat.Users.With(fmt.Sprintf("%d", proxyId)).Set(float64(proxyConn.GetStats()))
+		}
+	})
+}
@@ -5,8 +5,10 @@ import (
 	"time"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"math"
 	"math/rand"
+	"sort"
 
 	"github.com/rs/zerolog/log"
 
@@ -17,6 +19,7 @@ import (
 
 	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
 	"github.com/unitoftime/mmo/stat"
 	// "github.com/unitoftime/mmo/game"
 )
@@ -51,8 +54,6 @@ func (d *DeleteList) CopyAndClear() []ecs.Id {
 	return dListCopy
 }
 
-var everyOther int
-
 // var lastTime time.Time
 // var lastTime4 time.Time
 
@@ -70,11 +71,11 @@ var lastWorldUpdate time.Time
 // }
 
 // This calculates the update to send to all players, finds the proxy associated with them, and sends that update over the wire
-func ServerSendUpdate(world *ecs.World, server *Server, deleteList *DeleteList) {
+func ServerSendUpdate(world *ecs.World, server *Server, deleteList *DeleteList, persister *Persister) {
 	// log.Print("ServerSendUpdate-LastTime: ", time.Since(lastTime))
 	// lastTime = time.Now()
-	everyOther = (everyOther + 1) % mmo.NetworkTickDivider
-	if everyOther != 0 {
+	server.everyOther = (server.everyOther + 1) % mmo.NetworkTickDivider
+	if server.everyOther != 0 {
 		return // skip
 	}
 	// log.Print("ServerSendUpdate-LastTime4: ", time.Since(lastTime4))
@@ -85,23 +86,23 @@ func ServerSendUpdate(world *ecs.World, server *Server, deleteList *DeleteList)
 
 	// Just delete everything that is gone
 	for _, id := range dListCopy {
+		saveCharacter(world, persister, id)
 		ecs.Delete(world, id)
 	}
 
-	// Build the world update
-	update := serdes.WorldUpdate{
-		Tick: server.tick,
-		UserId: 0,
-		WorldData: make(map[ecs.Id][]ecs.Component),
-		Delete: dListCopy,
-	}
+	tick := server.tick
+
+	// Remember where everything was on this tick, so that hits can be checked against what the users saw
+	server.History.Record(world, tick)
 
 	//Increment server tick
 	server.tick = (server.tick + 1) % math.MaxUint16
 
 	// TODO - [optional ecs feature] speech should be optional!!!!
-	// TODO - When you do SOI code, and generate messages on a per player basis. You should also not include the speech bubble that the player just sent.
-	// Add relevant data to the world update
+	// TODO - You should also not include the speech bubble that the player just sent.
+	// Gather the replicated data of every entity
+	entityData := make(map[ecs.Id][]ecs.Component)
+	entityPos := make(map[ecs.Id]phy2.Pos)
 	{
 		ecs.Map4(world, func(id ecs.Id, pos *phy2.Pos, body *mmo.Body, speech *mmo.Speech, input *mmo.Input) {
 			compList := []ecs.Component{
@@ -113,16 +114,17 @@ func ServerSendUpdate(world *ecs.World, server *Server, deleteList *DeleteList)
 			if speech.HandleSent() {
 				compList = append(compList, ecs.C(*speech))
 			}
-			update.WorldData[id] = compList
+			entityData[id] = compList
+			entityPos[id] = *pos
 		})
+		stat.Entities.Set(float64(len(entityData)))
 	}
 
-	// Send world update to all users
+	// Build and send a world update for each user, only containing the entities that are inside of that user's area of interest
 	{
-		ecs.Map2(world, func(id ecs.Id, user *User, clientTick *ClientTick) {
-			update.UserId = user.Id // Specify the user we want to send the update to
-			// log.Println("ServerSendUpdate WorldUpdate:", update)
+		radiusSquared := server.InterestRadius * server.InterestRadius
 
+		ecs.Map4(world, func(id ecs.Id, user *User, clientTick *ClientTick, replication *Replication, pos *phy2.Pos) {
 			proxy, ok := server.GetProxy(user.ProxyId)
 			if !ok {
 				log.Print("Missing Proxy for user!")
@@ -131,8 +133,36 @@ func ServerSendUpdate(world *ecs.World, server *Server, deleteList *DeleteList)
 				return
 			}
 
-			// Set the player's update tick so they can synchronize
-			update.PlayerTick = clientTick.Tick
+			update := serdes.WorldUpdate{
+				Tick: tick,
+				PlayerTick: clientTick.Tick, // Set the player's update tick so they can synchronize
+				UserId: user.Id, // Specify the user we want to send the update to
+				WorldData: make(map[ecs.Id][]ecs.Component),
+				Delete: make([]ecs.Id, 0),
+			}
+
+			for entityId, compList := range entityData {
+				delta := entityPos[entityId].Sub(*pos)
+				if (delta.X * delta.X) + (delta.Y * delta.Y) > radiusSquared {
+					continue // Skip: This entity is too far away from the user
+				}
+				update.WorldData[entityId] = compList
+			}
+
+			// Anything the user knew about that isn't in this update either left their area of interest or was deleted
+			for entityId := range replication.Known {
+				_, ok := update.WorldData[entityId]
+				if !ok {
+					update.Delete = append(update.Delete, entityId)
+					delete(replication.Known, entityId)
+				}
+			}
+			for entityId := range update.WorldData {
+				replication.Known[entityId] = true
+			}
+
+			// Only send what changed since the last snapshot the user acknowledged
+			replication.History.Encode(&update, clientTick.AckTick, clientTick.Acked)
 
 			// log.Printf("SendUpdate", update)
 			err := proxy.Send(update)
@@ -156,9 +186,135 @@ func ServerSendUpdate(world *ecs.World, server *Server, deleteList *DeleteList)
 	// }
 }
 
-func ServeProxyConnection(serverConn *ServerConn, world *ecs.World, networkChannel chan serdes.WorldUpdate, deleteList *DeleteList) error {
+// Waits for the proxy to send their Hello and checks that they're running a compatible protocol. If they aren't, they are sent a HelloReject
+func serverHandshake(serverConn *ServerConn) error {
+	msg, err := serverConn.Recv()
+	if err == nil {
+		hello, ok := msg.(serdes.Hello)
+		if ok {
+			err = serdes.CheckHello(hello)
+		} else {
+			err = fmt.Errorf("%w: Expected hello message, got %T", serdes.ErrVersionMismatch, msg)
+		}
+	}
+	if errors.Is(err, net.ErrNetwork) {
+		return err
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msg("Rejecting Proxy Version")
+		sendErr := serverConn.Send(serdes.NewHelloReject(err))
+		if sendErr != nil {
+			log.Warn().Err(sendErr).Msg("Failed to send hello rejection")
+		}
+		return err
+	}
+
+	return serverConn.Send(serdes.NewHello())
+}
+
+// Spawns the user's character into the world and tells the proxy the user's new entity id. Must be called from the game loop
+func loginUser(serverConn *ServerConn, world *ecs.World, zone mmo.ZoneId, userId uint64, loaded []ecs.Component, now time.Time) {
+	id := world.NewId()
+
+	// TODO - hardcoded here and in client.go - Centralize character creation
+	collider := phy2.NewCircleCollider(6)
+	collider.Layer = mmo.BodyLayer
+	collider.HitLayer = mmo.BodyLayer
+
+	character := []ecs.Component{
+		ecs.C(mmo.Body{uint32(rand.Intn(mmo.NumBodyTypes))}),
+		ecs.C(mmo.SpawnPoint()),
+	}
+	character = mergeComponents(character, loaded)
+	character = mergeComponents(character, []ecs.Component{ecs.C(zone)})
+
+	compList := []ecs.Component{
+		ecs.C(User{
+			Id: userId,
+			ProxyId: serverConn.proxyId,
+		}),
+		ecs.C(mmo.Input{}),
+		ecs.C(mmo.Speech{}),
+		ecs.C(collider),
+		ecs.C(phy2.NewColliderCache()),
+		ecs.C(NewReplication()),
+	}
+	compList = append(compList, character...)
+
+	ecs.Write(world, id, compList...)
+
+	serverConn.LoginUser(userId, id, now)
+
+	resp := serdes.ClientLoginResp{userId, id, zone}
+	err := serverConn.Send(resp)
+	if err != nil {
+		log.Warn().Err(err).Msg(fmt.Sprintf("Failed to send: %v", resp))
+	}
+}
+
+// Loads the user's character and spawns it, or sends the user back to the zone that they logged out in. Must be called from the game loop
+func handleLogin(serverConn *ServerConn, world *ecs.World, persister *Persister, zone mmo.ZoneId, login LoginRequest) {
+	if login.Transfer {
+		loginUser(serverConn, world, zone, login.UserId, login.Character, login.Time)
+		return
+	}
+
+	// Restore the user's character if they've played before
+	character, err := persister.Load(login.UserId)
+	if err != nil && !errors.Is(err, ErrCharacterNotFound) {
+		log.Error().Err(err).Uint64(stat.UserId, login.UserId).Msg("Failed to load character")
+	}
+
+	// If the user logged out in a different zone, then send them back there
+	characterZone, ok := getZone(character)
+	if ok && characterZone != zone {
+		log.Print("Server: Redirecting login to zone ", characterZone)
+		transferUser(serverConn, login.UserId, characterZone, character)
+		return
+	}
+
+	loginUser(serverConn, world, zone, login.UserId, character, login.Time)
+}
+
+// Tells the proxy to move the user to the server that owns the zone
+func transferUser(serverConn *ServerConn, userId uint64, zone mmo.ZoneId, character []ecs.Component) {
+	dat, err := serdes.MarshalComponents(character)
+	if err != nil {
+		log.Error().Err(err).Uint64(stat.UserId, userId).Msg("Failed to serialize transferred character")
+		return
+	}
+
+	err = serverConn.Send(serdes.ZoneTransfer{
+		UserId: userId,
+		Zone: zone,
+		Character: dat,
+	})
+	if err != nil {
+		log.Warn().Err(err).Uint64(stat.UserId, userId).Msg("Failed to send zone transfer")
+	}
+}
+
+// Returns the zone that the character was saved in, if any
+func getZone(character []ecs.Component) (mmo.ZoneId, bool) {
+	for _, c := range character {
+		box, ok := c.(ecs.CompBox[mmo.ZoneId])
+		if ok {
+			return box.Get(), true
+		}
+	}
+	return 0, false
+}
+
+func ServeProxyConnection(serverConn *ServerConn, world *ecs.World, networkChannel chan serdes.WorldUpdate, deleteList *DeleteList, persister *Persister, chat *ChatRouter, zone mmo.ZoneId) error {
 	log.Print("Server: ServeProxyConnection")
 
+	err := serverHandshake(serverConn)
+	if err != nil {
+		serverConn.sock.Close()
+		return err
+	}
+
 	// Read data
 	for {
 		msg, err := serverConn.Recv()
@@ -173,122 +329,135 @@ func ServeProxyConnection(serverConn *ServerConn, world *ecs.World, networkChann
 		}
 		if msg == nil { continue }
 
-		// Interpret different messages
-		switch t := msg.(type) {
-		case serdes.WorldUpdate:
-			id, ok := serverConn.GetUser(t.UserId)
-			if !ok {
-				log.Error().Uint64(stat.UserId, t.UserId).
-					Msg("Proxy sent update for user that we don't have on the server")
-				// Skip: We can't find the user
-				continue
-			}
+		handleProxyMessage(serverConn, msg, time.Now(), world, networkChannel, deleteList, persister, chat, zone)
+	}
+}
+
+// Handles a single message from the proxy. The time is when the message arrived
+func handleProxyMessage(serverConn *ServerConn, msg any, now time.Time, world *ecs.World, networkChannel chan serdes.WorldUpdate, deleteList *DeleteList, persister *Persister, chat *ChatRouter, zone mmo.ZoneId) {
+	// Interpret different messages
+	switch t := msg.(type) {
+	case serdes.PlayerInput:
+		_, ok := serverConn.GetUser(t.UserId)
+		if !ok {
+			log.Error().Uint64(stat.UserId, t.UserId).
+				Msg("Proxy sent input for user that we don't have on the server")
+			// Skip: We can't find the user
+			return
+		}
 
-			// TODO - requires client to put their input on spot 0. You probably want to change the message serialization type to just send one piece of entity data over.
-			componentList := t.WorldData[id]
-			if len(componentList) <= 0 { break } // Exit if no content
-
-			compSlice := make([]ecs.Component, 0)
-			// TODO - these should be in a loop. can't guarantee each component slot
-			inputBox, ok := componentList[0].(ecs.CompBox[mmo.Input])
-			if !ok { continue }
-			input := inputBox.Get()
-			compSlice = append(compSlice, ecs.C(input))
-
-			if len(componentList) > 1 {
-				speechBox, ok := componentList[1].(ecs.CompBox[mmo.Speech])
-				if !ok { continue }
-				speech := speechBox.Get()
-				compSlice = append(compSlice, ecs.C(speech))
+		// Note: The inputs get applied on the game loop, once per network tick (See: CreateApplyInputSystem)
+		err := serverConn.QueueInputs(t.UserId, t, now)
+		if IsSuspicious(err) {
+			newestTick := uint16(0)
+			if len(t.Inputs) > 0 {
+				newestTick = t.Inputs[len(t.Inputs) - 1].Tick
 			}
+			log.Warn().Err(err).
+				Uint64(stat.UserId, t.UserId).
+				Uint16(stat.PlayerTick, newestTick).
+				Int(stat.Violations, serverConn.UserViolations(t.UserId)).
+				Msg("Suspicious Input")
+		}
 
-			// We just send this field back to the player, we don't use it internally. This is for them to syncrhonize their client prediction.
-			compSlice = append(compSlice, ecs.C(ClientTick{
-				Tick: t.PlayerTick,
-			}))
+	case serdes.ChatMessage:
+		if t.Channel == mmo.ChannelSystem {
+			log.Warn().Uint64(stat.UserId, t.UserId).Msg("Proxy sent a system chat message, dropping")
+			return
+		}
 
-			trustedUpdate := serdes.WorldUpdate{
-				WorldData: map[ecs.Id][]ecs.Component{
-					id: compSlice,
-				},
-				// Messages: t.Messages,
-			}
-			// log.Print("TrustedUpdate:", trustedUpdate)
-
-			networkChannel <- trustedUpdate
-
-		case serdes.ClientLogin:
-			log.Print("Server: serdes.ClientLogin")
-			// Login player
-			// TODO! - not threadsafe
-			id := world.NewId()
-
-			// TODO - hardcoded here and in client.go - Centralize character creation
-			collider := phy2.NewCircleCollider(6)
-			collider.Layer = mmo.BodyLayer
-			collider.HitLayer = mmo.BodyLayer
-			trustedLogin := serdes.WorldUpdate{
-				WorldData: map[ecs.Id][]ecs.Component{
-					id: []ecs.Component{
-						ecs.C(User{
-							Id: t.UserId,
-							ProxyId: serverConn.proxyId,
-						}),
-						ecs.C(mmo.Input{}),
-						ecs.C(mmo.Body{uint32(rand.Intn(mmo.NumBodyTypes))}),
-						ecs.C(mmo.Speech{}),
-						ecs.C(mmo.SpawnPoint()),
-						ecs.C(collider),
-						ecs.C(phy2.NewColliderCache()),
-					},
-				},
-			}
-			networkChannel <- trustedLogin
+		// Note: Global and whisper messages can come from users in other zones, so we don't check that the sender is on this server
+		chat.Push(serverConn.proxyId, t)
 
-			serverConn.LoginUser(t.UserId, id)
+	case serdes.ClientLogin:
+		log.Print("Server: serdes.ClientLogin")
+		// Note: The character gets loaded on the game loop, after any logout that came before this has been saved (See: CreateLoginSystem)
+		serverConn.QueueLogin(LoginRequest{UserId: t.UserId, Time: now})
 
-			resp := serdes.ClientLoginResp{t.UserId, id}
-			err := serverConn.Send(resp)
-			if err != nil {
-				log.Warn().Err(err).Msg(fmt.Sprintf("Failed to send: %v", resp))
-			}
-		case serdes.ClientLogout:
-			log.Printf("serdes.ClientLogout: %d", t.UserId)
-			id, ok := serverConn.GetUser(t.UserId)
-			if !ok {
-				// Skip: User already logged out
-				log.Printf("User already logged out: %d", t.UserId)
-				continue
-			}
-			trustedLogout := serdes.WorldUpdate{
-				UserId: t.UserId,
-				Delete: []ecs.Id{id},
-			}
-			networkChannel <- trustedLogout
+	case serdes.ZoneTransfer:
+		log.Print("Server: serdes.ZoneTransfer")
+		if t.Zone != zone {
+			log.Error().Uint64(stat.UserId, t.UserId).Msg(fmt.Sprintf("Proxy sent zone transfer for zone %d, but this server owns zone %d", t.Zone, zone))
+			return
+		}
 
-			serverConn.LogoutUser(t.UserId)
+		character, err := serdes.UnmarshalComponents(t.Character)
+		if err != nil {
+			log.Error().Err(err).Uint64(stat.UserId, t.UserId).Msg("Failed to read transferred character")
+		}
 
-			deleteList.Append(id)
+		serverConn.QueueLogin(LoginRequest{UserId: t.UserId, Transfer: true, Character: character, Time: now})
 
-			resp := serdes.ClientLogoutResp{t.UserId, id}
-			err := serverConn.Send(resp)
-			if err != nil {
-				log.Print("Failed to send", resp)
+	case serdes.ClientLogout:
+		log.Printf("serdes.ClientLogout: %d", t.UserId)
+		id, ok := serverConn.GetUser(t.UserId)
+		if !ok {
+			// Note: The user might still be waiting on the game loop to log them in
+			if serverConn.CancelLogin(t.UserId) {
+				log.Printf("Cancelled queued login: %d", t.UserId)
+				return
 			}
-		default:
-			log.Error().Msg("Unknown message type")
+			// Skip: User already logged out
+			log.Printf("User already logged out: %d", t.UserId)
+			return
+		}
+		trustedLogout := serdes.WorldUpdate{
+			UserId: t.UserId,
+			Delete: []ecs.Id{id},
+		}
+		networkChannel <- trustedLogout
+
+		serverConn.LogoutUser(t.UserId)
+
+		deleteList.Append(id)
+
+		resp := serdes.ClientLogoutResp{t.UserId, id}
+		err := serverConn.Send(resp)
+		if err != nil {
+			log.Print("Failed to send", resp)
 		}
+	default:
+		log.Error().Msg("Unknown message type")
 	}
 }
 
 
 //--------------------------------------------------------------------------------
+// The connection to a proxy (ie a transport.Socket)
+type ProxySocket interface {
+	Send(any) error
+	Recv() (any, error)
+	Close() error
+}
+
 type ServerConn struct {
-	sock *net.Socket
+	sock ProxySocket
 
 	mu sync.RWMutex
 	proxyId uint64
 	loginMap map[uint64]ecs.Id
+	validators map[uint64]*InputValidator
+	inputs map[uint64]*InputQueue
+	violations uint64 // The total number of suspicious inputs from users on this proxy
+	logins []LoginRequest
+}
+
+// A user that the proxy wants logged in. Transferred users bring their character with them, everyone else gets theirs loaded from the persister
+type LoginRequest struct {
+	UserId uint64
+	Transfer bool
+	Character []ecs.Component
+	Time time.Time // When the login arrived
+}
+
+func NewServerConn(sock ProxySocket, proxyId uint64) *ServerConn {
+	return &ServerConn{
+		sock: sock,
+		proxyId: proxyId,
+		loginMap: make(map[uint64]ecs.Id),
+		validators: make(map[uint64]*InputValidator),
+		inputs: make(map[uint64]*InputQueue),
+	}
 }
 
 func (c *ServerConn) Send(msg any) error {
@@ -299,16 +468,100 @@ func (c *ServerConn) Recv() (any, error) {
 	return c.sock.Recv()
 }
 
-func (c *ServerConn) LoginUser(userId uint64, ecsId ecs.Id) {
+// Maps the user to their entity. The time is when their login arrived, their input rate is measured from then
+func (c *ServerConn) LoginUser(userId uint64, ecsId ecs.Id, now time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.loginMap[userId] = ecsId
+	c.validators[userId] = NewInputValidator(now)
+	c.inputs[userId] = NewInputQueue()
 }
 
 func (c *ServerConn) LogoutUser(userId uint64) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	delete(c.loginMap, userId)
+	delete(c.validators, userId)
+	delete(c.inputs, userId)
+}
+
+// Queues up a login to be handled on the game loop
+func (c *ServerConn) QueueLogin(login LoginRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logins = append(c.logins, login)
+}
+
+// Removes and returns the queued logins, oldest first
+func (c *ServerConn) PopLogins() []LoginRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := c.logins
+	c.logins = nil
+	return ret
+}
+
+// Removes the user's queued logins. Returns false if there weren't any
+func (c *ServerConn) CancelLogin(userId uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := false
+	logins := c.logins[:0]
+	for _, login := range c.logins {
+		if login.UserId == userId {
+			found = true
+			continue
+		}
+		logins = append(logins, login)
+	}
+	c.logins = logins
+	return found
+}
+
+// Checks that the user's inputs are in order and aren't being sent too fast, then queues up the new ones to be applied. Returns the reason that any of the inputs were rejected
+func (c *ServerConn) QueueInputs(userId uint64, msg serdes.PlayerInput, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	validator, ok := c.validators[userId]
+	if !ok {
+		return fmt.Errorf("Missing input validator for user %d", userId)
+	}
+	queue := c.inputs[userId]
+
+	accepted, err := validator.Check(msg.Inputs, now)
+	if IsSuspicious(err) {
+		c.violations++
+	} else {
+		queue.SetAck(msg)
+	}
+	queue.Push(accepted)
+	return err
+}
+
+// Removes and returns the user's queued inputs, oldest first, and the server ticks from their newest input message (Tick isn't set). Returns false if the user isn't logged in
+func (c *ServerConn) PopInputs(userId uint64) ([]serdes.TickInput, ClientTick, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queue, ok := c.inputs[userId]
+	if !ok {
+		return nil, ClientTick{}, false
+	}
+	return queue.Pop(), queue.ack, true
+}
+
+// Returns the number of suspicious inputs that the user has sent
+func (c *ServerConn) UserViolations(userId uint64) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	validator, ok := c.validators[userId]
+	if !ok { return 0 }
+	return validator.Violations
+}
+
+func (c *ServerConn) Violations() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.violations
 }
 
 func (c *ServerConn) GetUser(userId uint64) (ecs.Id, bool) {
@@ -318,6 +571,17 @@ func (c *ServerConn) GetUser(userId uint64) (ecs.Id, bool) {
 	return ret, ok
 }
 
+// Returns a copy of every logged in user, mapped to their entity
+func (c *ServerConn) Users() map[uint64]ecs.Id {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[uint64]ecs.Id, len(c.loginMap))
+	for userId, id := range c.loginMap {
+		ret[userId] = id
+	}
+	return ret
+}
+
 // TODO - add more stats
 func (c *ServerConn) GetStats() int {
 	c.mu.RLock()
@@ -327,20 +591,26 @@ func (c *ServerConn) GetStats() int {
 }
 
 type Server struct {
-	listener net.Listener
+	listener transport.Listener
 	handler func(*ServerConn) error
+	stopped atomic.Bool // Set once we've stopped accepting new proxies
 
 	tick uint16
+	everyOther int // Counts physics ticks, a world update is sent every mmo.NetworkTickDivider ticks
+	InterestRadius float64 // Entities further than this from a user won't be sent to that user
+	History *RewindBuffer // The recent positions of every entity, on each tick that was sent to the users
 
  	connectionsMut sync.RWMutex // Sync for connections map
 	connections map[uint64]*ServerConn // A map of proxyIds to Proxy connections
 }
 
-func NewServer(listener net.Listener, handler func(*ServerConn) error) *Server {
+func NewServer(listener transport.Listener, handler func(*ServerConn) error) *Server {
 	server := Server{
 		listener: listener,
 		connections: make(map[uint64]*ServerConn),
 		handler: handler,
+		InterestRadius: mmo.DefaultInterestRadius,
+		History: NewRewindBuffer(maxRewindTicks),
 	}
 	return &server
 }
@@ -354,7 +624,7 @@ func (s *Server) Start() {
 			s.connectionsMut.RLock()
 			for proxyId, proxyConn := range s.connections {
 				numActive := proxyConn.GetStats()
-				log.Print(fmt.Sprintf("Proxy %d - %d active users", proxyId, numActive))
+				log.Print(fmt.Sprintf("Proxy %d - %d active users - %d suspicious inputs", proxyId, numActive, proxyConn.Violations()))
 			}
 			s.connectionsMut.RUnlock()
 		}
@@ -365,16 +635,13 @@ func (s *Server) Start() {
 		// Wait for a connection.
 		sock, err := s.listener.Accept()
 		if err != nil {
+			if s.stopped.Load() { return }
 			log.Warn().Err(err).Msg("Failed to accept connection")
 			continue
 		}
 
 		proxyId := counter
-		serverConn := &ServerConn{
-			sock: sock,
-			proxyId: proxyId,
-			loginMap: make(map[uint64]ecs.Id),
-		}
+		serverConn := NewServerConn(sock, proxyId)
 
 		s.AddProxy(proxyId, serverConn)
 
@@ -391,6 +658,44 @@ func (s *Server) Start() {
 	}
 }
 
+// Stops accepting new proxies. The proxies that are already connected stay connected until CloseProxies is called
+func (s *Server) Stop() error {
+	s.stopped.Store(true)
+	return s.listener.Close()
+}
+
+// Sends the message to every connected proxy
+func (s *Server) Broadcast(msg any) {
+	s.connectionsMut.RLock()
+	defer s.connectionsMut.RUnlock()
+	for proxyId, proxyConn := range s.connections {
+		err := proxyConn.Send(msg)
+		if err != nil {
+			log.Warn().Err(err).Msg(fmt.Sprintf("Failed to send %T to proxy %d", msg, proxyId))
+		}
+	}
+}
+
+// Closes the connection to every proxy, their handlers remove them once they see that the connection is closed
+func (s *Server) CloseProxies() {
+	s.connectionsMut.RLock()
+	defer s.connectionsMut.RUnlock()
+	for _, proxyConn := range s.connections {
+		proxyConn.sock.Close()
+	}
+}
+
+// Returns a copy of every connected proxy, mapped by proxyId
+func (s *Server) Proxies() map[uint64]*ServerConn {
+	s.connectionsMut.RLock()
+	defer s.connectionsMut.RUnlock()
+	ret := make(map[uint64]*ServerConn, len(s.connections))
+	for proxyId, proxyConn := range s.connections {
+		ret[proxyId] = proxyConn
+	}
+	return ret
+}
+
 func (s *Server) GetProxy(proxyId uint64) (*ServerConn, bool) {
  	s.connectionsMut.RLock()
 	defer s.connectionsMut.RUnlock()
@@ -426,31 +731,69 @@ func (s *Server) RemoveProxy(proxyId uint64) {
 // }
 
 // TODO - this kindof represents a greater pattern of trying to apply commands to the world in a threadsafe manner. Maybe integrate this into the ECS library: https://docs.rs/bevy/0.4.0/bevy/ecs/trait.Command.html
-func CreatePollNetworkSystem(world *ecs.World, networkChannel chan serdes.WorldUpdate) ecs.System {
+func CreatePollNetworkSystem(world *ecs.World, networkChannel chan serdes.WorldUpdate, persister *Persister) ecs.System {
 	sys := ecs.System{"PollNetworkChannel", func(dt time.Duration) {
+		applyNetworkUpdates(world, networkChannel, persister)
+	}}
 
-	MainLoop:
-		for {
-			select {
-			case update := <-networkChannel:
-				for id, compList := range update.WorldData {
-					// compList = append(compList, ecs.C(LastUpdate{time.Now()}))
-					ecs.Write(world, id, compList...)
-				}
+	return sys
+}
 
-				// Delete all the entities in the deleteList
-				if update.Delete != nil {
-					for _, id := range update.Delete {
-						ecs.Delete(world, id)
-					}
-				}
+// Handles the logins that the proxies have queued up. Loading the character here (instead of on the proxy's goroutine) means that a user who logs out and quickly back in gets the character that their logout saved
+func CreateLoginSystem(world *ecs.World, server *Server, networkChannel chan serdes.WorldUpdate, persister *Persister, zone mmo.ZoneId) ecs.System {
+	sys := ecs.System{"HandleLogins", func(dt time.Duration) {
+		proxies := server.Proxies()
+		proxyIds := make([]uint64, 0, len(proxies))
+		for proxyId := range proxies {
+			proxyIds = append(proxyIds, proxyId)
+		}
+		sort.Slice(proxyIds, func(i, j int) bool { return proxyIds[i] < proxyIds[j] })
 
-			default:
-				break MainLoop
+		type queuedLogin struct {
+			conn *ServerConn
+			login LoginRequest
+		}
+		logins := make([]queuedLogin, 0)
+		for _, proxyId := range proxyIds {
+			conn := proxies[proxyId]
+			for _, login := range conn.PopLogins() {
+				logins = append(logins, queuedLogin{conn, login})
 			}
 		}
+		if len(logins) == 0 { return }
+
+		// Note: The proxy sends the logout before the login, so by popping the logins first, any logout that came before them is already in the networkChannel. Applying it saves the character before we load it
+		applyNetworkUpdates(world, networkChannel, persister)
+
+		for _, l := range logins {
+			handleLogin(l.conn, world, persister, zone, l.login)
+		}
 	}}
 
 	return sys
 }
 
+// Applies every update that is waiting in the networkChannel
+func applyNetworkUpdates(world *ecs.World, networkChannel chan serdes.WorldUpdate, persister *Persister) {
+MainLoop:
+	for {
+		select {
+		case update := <-networkChannel:
+			for id, compList := range update.WorldData {
+				// compList = append(compList, ecs.C(LastUpdate{time.Now()}))
+				ecs.Write(world, id, compList...)
+			}
+
+			// Delete all the entities in the deleteList
+			if update.Delete != nil {
+				for _, id := range update.Delete {
+					saveCharacter(world, persister, id)
+					ecs.Delete(world, id)
+				}
+			}
+
+		default:
+			break MainLoop
+		}
+	}
+}
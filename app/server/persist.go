@@ -0,0 +1,249 @@
+package server
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"sync"
+	"errors"
+	"reflect"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/stat"
+)
+
+var ErrCharacterNotFound = errors.New("character not found")
+
+// This is the interface used to load and save a user's character between logins
+type CharacterStore interface {
+	// Returns ErrCharacterNotFound if the user has never saved a character
+	Load(userId uint64) ([]ecs.Component, error)
+	Save(userId uint64, comps []ecs.Component) error
+}
+
+// Stores each character as a file in a directory
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{dir}, nil
+}
+
+func (s *FileStore) path(userId uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d.char", userId))
+}
+
+func (s *FileStore) Load(userId uint64) ([]ecs.Component, error) {
+	dat, err := os.ReadFile(s.path(userId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCharacterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return serdes.UnmarshalComponents(dat)
+}
+
+func (s *FileStore) Save(userId uint64, comps []ecs.Component) error {
+	dat, err := serdes.MarshalComponents(comps)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash can't leave a partially written character
+	path := s.path(userId)
+	err = os.WriteFile(path + ".tmp", dat, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path + ".tmp", path)
+}
+
+// Stores characters in memory, this is mostly useful for testing
+type MemoryStore struct {
+	mu sync.Mutex
+	chars map[uint64][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		chars: make(map[uint64][]byte),
+	}
+}
+
+func (s *MemoryStore) Load(userId uint64) ([]ecs.Component, error) {
+	s.mu.Lock()
+	dat, ok := s.chars[userId]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrCharacterNotFound
+	}
+	return serdes.UnmarshalComponents(dat)
+}
+
+func (s *MemoryStore) Save(userId uint64, comps []ecs.Component) error {
+	dat, err := serdes.MarshalComponents(comps)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.chars[userId] = dat
+	s.mu.Unlock()
+	return nil
+}
+
+// Writes characters to the store in the background so that the game loop doesn't block on IO
+type Persister struct {
+	store CharacterStore
+
+	mu sync.Mutex
+	pending map[uint64][]ecs.Component // Saves that haven't been written yet
+	writing map[uint64][]ecs.Component // Saves that are currently being written
+	wake chan struct{}
+
+	flushMu sync.Mutex
+}
+
+func NewPersister(store CharacterStore) *Persister {
+	return &Persister{
+		store: store,
+		pending: make(map[uint64][]ecs.Component),
+		writing: make(map[uint64][]ecs.Component),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Continually writes pending saves to the store
+func (p *Persister) Run() {
+	for range p.wake {
+		p.Flush()
+	}
+}
+
+// Queues the character to be saved. If the user is already queued, then only the latest save is written
+func (p *Persister) Save(userId uint64, comps []ecs.Component) {
+	p.mu.Lock()
+	p.pending[userId] = comps
+	p.mu.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Loads the character, this includes saves that haven't been written to the store yet
+func (p *Persister) Load(userId uint64) ([]ecs.Component, error) {
+	p.mu.Lock()
+	comps, ok := p.pending[userId]
+	if !ok {
+		comps, ok = p.writing[userId]
+	}
+	p.mu.Unlock()
+	if ok {
+		return comps, nil
+	}
+
+	return p.store.Load(userId)
+}
+
+// Synchronously writes all pending saves to the store. Returns the last error that occured
+func (p *Persister) Flush() error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	p.writing = p.pending
+	p.pending = make(map[uint64][]ecs.Component)
+	p.mu.Unlock()
+
+	var lastErr error
+	for userId, comps := range p.writing {
+		err := p.store.Save(userId, comps)
+		if err != nil {
+			log.Error().Err(err).Uint64(stat.UserId, userId).Msg("Failed to save character")
+			lastErr = err
+		}
+	}
+
+	p.mu.Lock()
+	p.writing = make(map[uint64][]ecs.Component)
+	p.mu.Unlock()
+
+	return lastErr
+}
+
+// Reads the components of a character that should be persisted
+func readCharacter(world *ecs.World, id ecs.Id) []ecs.Component {
+	comps := make([]ecs.Component, 0)
+
+	pos, ok := ecs.Read[phy2.Pos](world, id)
+	if ok {
+		comps = append(comps, ecs.C(pos))
+	}
+	body, ok := ecs.Read[mmo.Body](world, id)
+	if ok {
+		comps = append(comps, ecs.C(body))
+	}
+	zone, ok := ecs.Read[mmo.ZoneId](world, id)
+	if ok {
+		comps = append(comps, ecs.C(zone))
+	}
+
+	return comps
+}
+
+// Queues a save of the entity's character if the entity is a logged in user
+func saveCharacter(world *ecs.World, persister *Persister, id ecs.Id) {
+	user, ok := ecs.Read[User](world, id)
+	if !ok { return } // Skip: not a user
+
+	persister.Save(user.Id, readCharacter(world, id))
+}
+
+// Replaces each default component with the loaded component of the same type, and appends any loaded components that don't have defaults
+func mergeComponents(defaults []ecs.Component, loaded []ecs.Component) []ecs.Component {
+	ret := append([]ecs.Component{}, defaults...)
+	for _, c := range loaded {
+		found := false
+		for i := range ret {
+			if reflect.TypeOf(ret[i]) == reflect.TypeOf(c) {
+				ret[i] = c
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func CreatePersistSystem(world *ecs.World, persister *Persister) ecs.System {
+	var elapsed time.Duration
+	sys := ecs.System{"PersistCharacters", func(dt time.Duration) {
+		elapsed += dt
+		if elapsed < mmo.PersistInterval {
+			return
+		}
+		elapsed = 0
+
+		ecs.Map(world, func(id ecs.Id, user *User) {
+			persister.Save(user.Id, readCharacter(world, id))
+		})
+	}}
+	return sys
+}
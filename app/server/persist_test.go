@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil { panic(err) }
+
+	_, err = store.Load(1)
+	if !errors.Is(err, ErrCharacterNotFound) {
+		t.Fatalf("Expected ErrCharacterNotFound, got %v", err)
+	}
+
+	character := []ecs.Component{
+		ecs.C(phy2.Pos{10, 20}),
+		ecs.C(mmo.Body{2}),
+		ecs.C(mmo.ZoneId(3)),
+	}
+	err = store.Save(1, character)
+	if err != nil { panic(err) }
+
+	loaded, err := store.Load(1)
+	if err != nil { panic(err) }
+	if len(loaded) != len(character) {
+		t.Fatalf("Expected %v, got %v", character, loaded)
+	}
+	for i := range character {
+		if loaded[i] != character[i] {
+			t.Errorf("Component %d: Expected %v, got %v", i, character[i], loaded[i])
+		}
+	}
+
+	// Other users still haven't saved anything
+	_, err = store.Load(2)
+	if !errors.Is(err, ErrCharacterNotFound) {
+		t.Errorf("Expected ErrCharacterNotFound, got %v", err)
+	}
+}
+
+// Blocks every save until it is released, so that tests can look at the persister while a save is being written
+type blockingStore struct {
+	*MemoryStore
+	saving chan uint64
+	release chan struct{}
+}
+
+func (s *blockingStore) Save(userId uint64, comps []ecs.Component) error {
+	s.saving <- userId
+	<-s.release
+	return s.MemoryStore.Save(userId, comps)
+}
+
+func TestPersister(t *testing.T) {
+	store := &blockingStore{NewMemoryStore(), make(chan uint64), make(chan struct{})}
+	persister := NewPersister(store)
+
+	_, err := persister.Load(1)
+	if !errors.Is(err, ErrCharacterNotFound) {
+		t.Fatalf("Expected ErrCharacterNotFound, got %v", err)
+	}
+
+	first := []ecs.Component{ecs.C(phy2.Pos{1, 1})}
+	second := []ecs.Component{ecs.C(phy2.Pos{2, 2})}
+
+	// Pending saves are loaded before they are written
+	persister.Save(1, first)
+	loaded, err := persister.Load(1)
+	if err != nil || loaded[0] != first[0] {
+		t.Errorf("Expected the pending save %v, got %v %v", first, loaded, err)
+	}
+
+	flushDone := make(chan error)
+	go func() {
+		flushDone <- persister.Flush()
+	}()
+	<-store.saving
+
+	// Saves that are being written are loaded too
+	loaded, err = persister.Load(1)
+	if err != nil || loaded[0] != first[0] {
+		t.Errorf("Expected the save that is being written %v, got %v %v", first, loaded, err)
+	}
+
+	// A newer pending save wins over the one being written
+	persister.Save(1, second)
+	loaded, err = persister.Load(1)
+	if err != nil || loaded[0] != second[0] {
+		t.Errorf("Expected the newer pending save %v, got %v %v", second, loaded, err)
+	}
+
+	store.release <- struct{}{}
+	err = <-flushDone
+	if err != nil { panic(err) }
+
+	// The first flush only wrote the first save
+	loaded, err = store.MemoryStore.Load(1)
+	if err != nil || loaded[0] != first[0] {
+		t.Errorf("Expected the store to have %v, got %v %v", first, loaded, err)
+	}
+
+	go func() {
+		flushDone <- persister.Flush()
+	}()
+	<-store.saving
+	store.release <- struct{}{}
+	err = <-flushDone
+	if err != nil { panic(err) }
+
+	loaded, err = store.MemoryStore.Load(1)
+	if err != nil || loaded[0] != second[0] {
+		t.Errorf("Expected the store to have %v, got %v %v", second, loaded, err)
+	}
+
+	// Once everything is written, loads come from the store
+	loaded, err = persister.Load(1)
+	if err != nil || loaded[0] != second[0] {
+		t.Errorf("Expected the stored save %v, got %v %v", second, loaded, err)
+	}
+}
+
+func TestMergeComponents(t *testing.T) {
+	defaults := []ecs.Component{
+		ecs.C(mmo.Body{1}),
+		ecs.C(mmo.SpawnPoint()),
+	}
+	loaded := []ecs.Component{
+		ecs.C(phy2.Pos{5, 5}),
+		ecs.C(mmo.ZoneId(2)),
+	}
+
+	merged := mergeComponents(defaults, loaded)
+	expected := []ecs.Component{
+		ecs.C(mmo.Body{1}),
+		ecs.C(phy2.Pos{5, 5}),
+		ecs.C(mmo.ZoneId(2)),
+	}
+	if len(merged) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, merged)
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			t.Errorf("Component %d: Expected %v, got %v", i, expected[i], merged[i])
+		}
+	}
+
+	// The defaults aren't modified
+	if defaults[1] != ecs.C(mmo.SpawnPoint()) {
+		t.Errorf("Expected the defaults to be unchanged, got %v", defaults)
+	}
+}
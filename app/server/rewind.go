@@ -0,0 +1,174 @@
+package server
+
+import (
+	"math"
+	"sort"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+)
+
+// The number of network ticks of history that the server keeps (~1 second). Clients that are further behind than this get rewound to the oldest tick
+const maxRewindTicks = 16
+
+type rewindEntry struct {
+	id ecs.Id
+	pos phy2.Pos
+	collider phy2.CircleCollider
+}
+
+// The position and collider of every entity on a single server tick
+type RewindFrame struct {
+	Tick uint16
+	entries []rewindEntry // Sorted by id
+}
+
+// A ring buffer of the recent positions and colliders of every entity, used to check hits against the world as the client saw it
+type RewindBuffer struct {
+	frames []RewindFrame
+	count int // The number of frames that have been recorded, up to the length of frames
+	latest int // The index of the most recent frame
+}
+
+func NewRewindBuffer(size int) *RewindBuffer {
+	return &RewindBuffer{
+		frames: make([]RewindFrame, size),
+		latest: -1,
+	}
+}
+
+// Records the position and collider of every entity for the tick. Ticks must be recorded in order
+// Note: The colliders are recorded as if they were centered on the entity's position
+func (b *RewindBuffer) Record(world *ecs.World, tick uint16) {
+	b.latest = (b.latest + 1) % len(b.frames)
+	if b.count < len(b.frames) {
+		b.count++
+	}
+
+	frame := &b.frames[b.latest]
+	frame.Tick = tick
+	frame.entries = frame.entries[:0] // Reuse the old frame's memory
+	ecs.Map2(world, func(id ecs.Id, pos *phy2.Pos, collider *phy2.CircleCollider) {
+		col := *collider
+		col.CenterX = pos.X
+		col.CenterY = pos.Y
+		frame.entries = append(frame.entries, rewindEntry{id, *pos, col})
+	})
+	sort.Slice(frame.entries, func(i, j int) bool {
+		return frame.entries[i].id < frame.entries[j].id
+	})
+}
+
+// Returns the frame for the tick. Ticks that are older than the history return the oldest frame, and ticks that haven't happened yet return the latest frame. Returns false if nothing has been recorded
+func (b *RewindBuffer) AsOf(tick uint16) (*RewindFrame, bool) {
+	if b.count == 0 {
+		return nil, false
+	}
+
+	latest := &b.frames[b.latest]
+	age := mmo.TickDiff(latest.Tick, tick)
+	if age <= 0 {
+		return latest, true
+	}
+	if age >= b.count {
+		age = b.count - 1
+	}
+	index := (b.latest - age + len(b.frames)) % len(b.frames)
+	return &b.frames[index], true
+}
+
+// Returns the tick that the client was rendering other entities at
+// Note: The client can't be rendering a tick that it hasn't received, so anything newer than its AckTick is clamped. Anything older than the history gets clamped by AsOf
+func RenderTick(clientTick ClientTick) (uint16, bool) {
+	if !clientTick.Acked {
+		return 0, false
+	}
+	if mmo.TickDiff(clientTick.AckTick, clientTick.RenderTick) < 0 {
+		return clientTick.AckTick, true
+	}
+	return clientTick.RenderTick, true
+}
+
+// Returns the position of the entity on this frame
+func (f *RewindFrame) Pos(id ecs.Id) (phy2.Pos, bool) {
+	i := sort.Search(len(f.entries), func(i int) bool { return f.entries[i].id >= id })
+	if i < len(f.entries) && f.entries[i].id == id {
+		return f.entries[i].pos, true
+	}
+	return phy2.Pos{}, false
+}
+
+// Returns the ids of every entity whose collider overlapped the circle on this frame. The ignore id is skipped (ie the attacker)
+func (f *RewindFrame) OverlapCircle(circle *phy2.CircleCollider, ignore ecs.Id) []ecs.Id {
+	hits := make([]ecs.Id, 0)
+	for i := range f.entries {
+		entry := &f.entries[i]
+		if entry.id == ignore { continue }
+		if !circle.LayerMask(entry.collider.Layer) { continue }
+
+		if circle.Collides(1.0, &entry.collider) {
+			hits = append(hits, entry.id)
+		}
+	}
+	return hits
+}
+
+type Ray struct {
+	Origin phy2.Pos
+	Dir phy2.Vec2 // Doesn't need to be normalized
+	Length float64
+	HitLayer phy2.CollisionLayer
+}
+
+type RayHit struct {
+	Id ecs.Id
+	Dist float64 // The distance along the ray to the hit
+	Point phy2.Pos
+}
+
+// Returns the closest entity whose collider was hit by the ray on this frame. The ignore id is skipped (ie the attacker)
+func (f *RewindFrame) Raycast(ray Ray, ignore ecs.Id) (RayHit, bool) {
+	dir := ray.Dir.Norm()
+	if dir.Len() == 0 {
+		return RayHit{}, false
+	}
+
+	best := RayHit{Dist: math.Inf(1)}
+	for i := range f.entries {
+		entry := &f.entries[i]
+		if entry.id == ignore { continue }
+		if entry.collider.Disabled { continue }
+		if (ray.HitLayer & entry.collider.Layer) == 0 { continue }
+
+		dist, ok := intersectCircle(ray.Origin, dir, &entry.collider)
+		if !ok || dist > ray.Length || dist >= best.Dist { continue }
+
+		best = RayHit{
+			Id: entry.id,
+			Dist: dist,
+			Point: phy2.Pos{ray.Origin.X + dir.X * dist, ray.Origin.Y + dir.Y * dist},
+		}
+	}
+	return best, !math.IsInf(best.Dist, 1)
+}
+
+// Returns the distance along the (normalized) direction that the ray first touches the circle. Rays that start inside of the circle hit at 0
+func intersectCircle(origin phy2.Pos, dir phy2.Vec2, circle *phy2.CircleCollider) (float64, bool) {
+	mx := origin.X - circle.CenterX
+	my := origin.Y - circle.CenterY
+	b := (mx * dir.X) + (my * dir.Y)
+	c := (mx * mx) + (my * my) - (circle.Radius * circle.Radius)
+	if c <= 0 {
+		return 0, true // Inside
+	}
+	if b > 0 {
+		return 0, false // Pointing away
+	}
+	disc := (b * b) - c
+	if disc < 0 {
+		return 0, false
+	}
+	return -b - math.Sqrt(disc), true
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+)
+
+func newRewindEntity(world *ecs.World, pos phy2.Pos) ecs.Id {
+	id := world.NewId()
+	collider := phy2.NewCircleCollider(8)
+	collider.Layer = mmo.BodyLayer
+	collider.HitLayer = mmo.BodyLayer
+	ecs.Write(world, id, ecs.C(pos), ecs.C(collider))
+	return id
+}
+
+// Replays a known movement: the runner moves 10 units to the right every tick while the target stands still
+func recordMovement(startTick uint16, ticks int) (*RewindBuffer, ecs.Id, ecs.Id) {
+	world := ecs.NewWorld()
+	runner := newRewindEntity(world, phy2.Pos{0, 0})
+	target := newRewindEntity(world, phy2.Pos{100, 0})
+
+	history := NewRewindBuffer(maxRewindTicks)
+	tick := startTick
+	for i := 0; i < ticks; i++ {
+		ecs.Write(world, runner, ecs.C(phy2.Pos{float64(i * 10), 0}))
+		history.Record(world, tick)
+		tick = uint16((int(tick) + 1) % mmo.TickModulus)
+	}
+	return history, runner, target
+}
+
+func TestRewindAsOf(t *testing.T) {
+	// Note: This starts right before the server tick wraps
+	start := uint16(mmo.TickModulus - 5)
+	history, runner, _ := recordMovement(start, 30)
+
+	// The latest tick is 29 ticks after the start
+	latest := uint16((int(start) + 29) % mmo.TickModulus)
+	for age := 0; age < maxRewindTicks; age++ {
+		tick := uint16((int(latest) - age + mmo.TickModulus) % mmo.TickModulus)
+		frame, ok := history.AsOf(tick)
+		if !ok || frame.Tick != tick {
+			t.Fatalf("Age %d: Expected frame for tick %d, got %v", age, tick, frame)
+		}
+		pos, ok := frame.Pos(runner)
+		if !ok || pos.X != float64((29 - age) * 10) {
+			t.Errorf("Age %d: Unexpected runner position: %v", age, pos)
+		}
+	}
+
+	// Too old gets clamped to the oldest tick, and the future gets clamped to the latest
+	oldest := uint16((int(latest) - (maxRewindTicks - 1) + mmo.TickModulus) % mmo.TickModulus)
+	frame, _ := history.AsOf(start)
+	if frame.Tick != oldest {
+		t.Errorf("Expected oldest tick %d, got %d", oldest, frame.Tick)
+	}
+	frame, _ = history.AsOf(latest + 5)
+	if frame.Tick != latest {
+		t.Errorf("Expected latest tick %d, got %d", latest, frame.Tick)
+	}
+
+	_, ok := NewRewindBuffer(4).AsOf(0)
+	if ok {
+		t.Errorf("Expected empty history to have no frames")
+	}
+}
+
+func TestRewindQueries(t *testing.T) {
+	history, runner, target := recordMovement(100, 20)
+
+	// At tick 109 the runner was at x=90, overlapping the target at x=100. At the latest tick it is at x=190
+	attack := phy2.NewCircleCollider(4)
+	attack.HitLayer = mmo.BodyLayer
+	attack.CenterX = 95
+
+	frame, _ := history.AsOf(109)
+	hits := frame.OverlapCircle(&attack, 0)
+	if len(hits) != 2 || hits[0] != runner || hits[1] != target {
+		t.Errorf("Expected runner and target to be hit, got %v", hits)
+	}
+	hits = frame.OverlapCircle(&attack, runner)
+	if len(hits) != 1 || hits[0] != target {
+		t.Errorf("Expected ignored runner to be skipped, got %v", hits)
+	}
+
+	frame, _ = history.AsOf(119)
+	hits = frame.OverlapCircle(&attack, 0)
+	if len(hits) != 1 || hits[0] != target {
+		t.Errorf("Expected only target to be hit at latest tick, got %v", hits)
+	}
+
+	// A ray shot from the left hits whoever was in front on that tick
+	ray := Ray{
+		Origin: phy2.Pos{-50, 0},
+		Dir: phy2.V2(1, 0),
+		Length: 1000,
+		HitLayer: mmo.BodyLayer,
+	}
+	frame, _ = history.AsOf(105) // Runner at x=50
+	hit, ok := frame.Raycast(ray, 0)
+	if !ok || hit.Id != runner || math.Abs(hit.Dist - 92) > 1e-9 || math.Abs(hit.Point.X - 42) > 1e-9 {
+		t.Errorf("Expected ray to hit runner at x=42, got %v", hit)
+	}
+
+	frame, _ = history.AsOf(119) // Runner at x=190, behind the target
+	hit, ok = frame.Raycast(ray, 0)
+	if !ok || hit.Id != target || math.Abs(hit.Point.X - 92) > 1e-9 {
+		t.Errorf("Expected ray to hit target at x=92, got %v", hit)
+	}
+
+	// Too short, pointing away, and masked out
+	shortRay := ray
+	shortRay.Length = 100
+	if hit, ok := frame.Raycast(shortRay, 0); ok {
+		t.Errorf("Expected short ray to miss, got %v", hit)
+	}
+	awayRay := ray
+	awayRay.Dir = phy2.V2(-1, 0)
+	if hit, ok := frame.Raycast(awayRay, 0); ok {
+		t.Errorf("Expected ray pointing away to miss, got %v", hit)
+	}
+	maskedRay := ray
+	maskedRay.HitLayer = mmo.WallLayer
+	if hit, ok := frame.Raycast(maskedRay, 0); ok {
+		t.Errorf("Expected masked ray to miss, got %v", hit)
+	}
+}
+
+func TestRenderTick(t *testing.T) {
+	_, ok := RenderTick(ClientTick{Acked: false})
+	if ok {
+		t.Errorf("Expected no render tick before the client has acked")
+	}
+
+	// The client's render delay changes with its connection, so the server uses whatever the client says
+	tick, _ := RenderTick(ClientTick{AckTick: 100, RenderTick: 93, Acked: true})
+	if tick != 93 {
+		t.Errorf("Unexpected render tick: %d", tick)
+	}
+
+	tick, _ = RenderTick(ClientTick{AckTick: 1, RenderTick: mmo.TickModulus - 2, Acked: true})
+	if tick != mmo.TickModulus - 2 {
+		t.Errorf("Expected render tick from before the wrap: %d", tick)
+	}
+
+	// A client can't render a tick that it hasn't received
+	tick, _ = RenderTick(ClientTick{AckTick: 100, RenderTick: 105, Acked: true})
+	if tick != 100 {
+		t.Errorf("Expected the render tick to be clamped to the ack tick: %d", tick)
+	}
+}
@@ -2,19 +2,31 @@ package server
 
 import (
 	"os"
+	"time"
+	"syscall"
 	"os/signal"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/unitoftime/ecs"
-	"github.com/unitoftime/flow/net"
 
 	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
+	"github.com/unitoftime/mmo/stat"
 )
 
-func Main() {
+type Config struct {
+	Url string // The url that proxies connect to
+	Zone mmo.ZoneId // The zone that this server owns
+	ShutdownCountdown time.Duration // How long users are warned for before the server shuts down
+	AdminUrl string // The local url that the admin console listens on (See: Admin), empty to disable it
+	MetricsAddr string // The local address that metrics are served on at /metrics (ie 127.0.0.1:9101), empty to disable them
+	ProfileInterval time.Duration // How often the tick profile is logged (See: mmo.Profiler), zero to disable it
+}
+
+func Main(config Config) {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
@@ -22,19 +34,30 @@ func Main() {
 
 	// Load Game
 	world := ecs.NewWorld()
-	tilemap := mmo.LoadGame(world)
+	tilemap := mmo.LoadZone(world, config.Zone)
 
 	// This is the list of entities to get deleted
 	deleteList := NewDeleteList()
 
+	// Load and save characters between logins
+	// Note: Every zone's server needs to use the same store, because characters move between zones
+	store, err := NewFileStore("characters")
+	if err != nil {
+		panic(err)
+	}
+	persister := NewPersister(store)
+	go persister.Run()
+
+	// Chat gets routed on the game loop
+	chat := NewChatRouter()
+
 	// TODO - make configurable
 	networkChannel := make(chan serdes.WorldUpdate, 1024)
 
 	// Start the networking layer
-	url := "tcp://127.0.0.1:9000"
-	log.Print("Starting Server", url)
-	serverNet := net.Config{
-		Url: url,
+	log.Print("Starting Server ", config.Url, " for zone ", config.Zone)
+	serverNet := transport.Config{
+		Url: config.Url,
 		Serdes: serdes.New(),
 	}
 	listener, err := serverNet.Listen()
@@ -43,27 +66,80 @@ func Main() {
 	}
 
 	server := NewServer(listener, func(conn *ServerConn) error {
-		return ServeProxyConnection(conn, world, networkChannel, deleteList)
+		return ServeProxyConnection(conn, world, networkChannel, deleteList, persister, chat, config.Zone)
 	})
 
-	serverSystems := CreateServerSystems(world, server, networkChannel, deleteList, tilemap)
+	serverSystems := CreateServerSystems(world, server, networkChannel, deleteList, tilemap, persister, chat, config.Zone)
 
 	quit := ecs.Signal{}
 	quit.Set(false)
 
 	schedule := mmo.GetScheduler()
 	schedule.AppendPhysics(serverSystems...)
-	go schedule.Run(&quit)
+
+	admin := NewAdmin(world, server, networkChannel, chat, schedule)
+	schedule.AppendPhysics(CreateAdminSystem(admin))
+	if config.AdminUrl != "" {
+		adminListener, err := admin.Listen(config.AdminUrl)
+		if err != nil {
+			panic(err)
+		}
+		defer adminListener.Close()
+		log.Print("Admin console listening on ", config.AdminUrl)
+	}
+
+	if config.MetricsAddr != "" {
+		registerMetrics(server, networkChannel)
+		go func() {
+			err := stat.ListenAndServe(config.MetricsAddr)
+			log.Error().Err(err).Msg("Metrics stopped")
+		}()
+		log.Print("Serving metrics on ", config.MetricsAddr)
+	}
+
+	if config.ProfileInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(config.ProfileInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				schedule.Profiler.Log()
+			}
+		}()
+	}
+
+	gameDone := make(chan struct{})
+	go func() {
+		schedule.Run(&quit)
+		close(gameDone)
+	}()
 
 	// go ecs.RunGameFixed(serverSystems, &quit)
 
 	go server.Start()
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	select{
 	case sig := <-sigs:
 		log.Print("Terminating:", sig)
 	}
+
+	// Shutdown: Stop taking new proxies and give the users a chance to finish up
+	err = server.Stop()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to stop listening")
+	}
+	shutdownCountdown(server, config.ShutdownCountdown, sigs)
+
+	// Stop the game loop so that we can safely save everyone
 	quit.Set(true)
+	<-gameDone
+
+	err = logoutEveryone(world, server, networkChannel, deleteList, persister)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save characters")
+	}
+	server.Broadcast(serdes.ServerShutdown{0})
+	server.CloseProxies()
+	log.Print("Server shut down")
 }
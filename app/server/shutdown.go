@@ -0,0 +1,66 @@
+package server
+
+import (
+	"os"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/ecs"
+
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/stat"
+)
+
+// Tells every proxy how long is left until the server shuts down, once per second. Returns early if anything arrives on skip (ie a second Ctrl-C)
+func shutdownCountdown(server *Server, countdown time.Duration, skip <-chan os.Signal) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for remaining := countdown; remaining > 0; remaining -= time.Second {
+		seconds := uint16((remaining + time.Second - 1) / time.Second)
+		log.Print("Shutting down in ", seconds, " seconds")
+		server.Broadcast(serdes.ServerShutdown{seconds})
+
+		select {
+		case <-ticker.C:
+		case sig := <-skip:
+			log.Print("Skipping shutdown countdown: ", sig)
+			return
+		}
+	}
+}
+
+// Saves every user's character, then logs them out and sends their proxy a logout response. This also finishes off anything that the game loop hadn't gotten to yet (ie logouts). Logins that are still queued are dropped, because those users never made it into the world
+// Note: The game loop must be stopped before this is called, because it modifies the world
+func logoutEveryone(world *ecs.World, server *Server, networkChannel chan serdes.WorldUpdate, deleteList *DeleteList, persister *Persister) error {
+	applyNetworkUpdates(world, networkChannel, persister)
+	for _, id := range deleteList.CopyAndClear() {
+		saveCharacter(world, persister, id)
+		ecs.Delete(world, id)
+	}
+
+	// Note: The users are deleted after the loop, because deleting during the loop would move them
+	users := make(map[ecs.Id]User)
+	ecs.Map(world, func(id ecs.Id, user *User) {
+		users[id] = *user
+	})
+
+	for id, user := range users {
+		saveCharacter(world, persister, id)
+		ecs.Delete(world, id)
+
+		proxy, ok := server.GetProxy(user.ProxyId)
+		if !ok { continue } // Skip: The proxy already disconnected
+		proxy.LogoutUser(user.Id)
+
+		resp := serdes.ClientLogoutResp{user.Id, id}
+		err := proxy.Send(resp)
+		if err != nil {
+			log.Warn().Err(err).Uint64(stat.UserId, user.Id).Msg(fmt.Sprintf("Failed to send: %v", resp))
+		}
+	}
+
+	return persister.Flush()
+}
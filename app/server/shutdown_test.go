@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/net"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+func TestLogoutEveryone(t *testing.T) {
+	world := ecs.NewWorld()
+	store := NewMemoryStore()
+	persister := NewPersister(store)
+	networkChannel := make(chan serdes.WorldUpdate, 16)
+	deleteList := NewDeleteList()
+
+	server := NewServer(nil, nil)
+	serverConn := NewServerConn(&net.Socket{}, 0) // Note: This socket isn't connected, so the logout responses fail to send
+	server.AddProxy(0, serverConn)
+
+	// User 1 is playing, user 2 has logged out but hasn't been deleted yet, and user 3's login hasn't been applied yet
+	for userId := uint64(1); userId <= 3; userId++ {
+		id := world.NewId()
+		comps := []ecs.Component{ecs.C(User{Id: userId, ProxyId: 0}), ecs.C(phy2.Pos{float64(userId), 0}), ecs.C(mmo.DefaultZone)}
+		if userId == 3 {
+			networkChannel <- serdes.WorldUpdate{WorldData: map[ecs.Id][]ecs.Component{id: comps}}
+		} else {
+			ecs.Write(world, id, comps...)
+		}
+		serverConn.LoginUser(userId, id, time.Now())
+
+		if userId == 2 {
+			serverConn.LogoutUser(userId)
+			deleteList.Append(id)
+		}
+	}
+
+	err := logoutEveryone(world, server, networkChannel, deleteList, persister)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Everyone was written to the store, without waiting for the persister to run
+	for userId := uint64(1); userId <= 3; userId++ {
+		comps, err := store.Load(userId)
+		if err != nil {
+			t.Fatalf("Expected user %d to be saved: %v", userId, err)
+		}
+		if comps[0] != ecs.C(phy2.Pos{float64(userId), 0}) {
+			t.Errorf("Expected user %d to be saved at their position, got %v", userId, comps)
+		}
+	}
+
+	if len(networkChannel) != 0 {
+		t.Errorf("Expected the network channel to be drained")
+	}
+	ecs.Map(world, func(id ecs.Id, user *User) {
+		t.Errorf("Expected user %d to be deleted", user.Id)
+	})
+	if serverConn.GetStats() != 0 {
+		t.Errorf("Expected every user to be logged out, %d are still logged in", serverConn.GetStats())
+	}
+}
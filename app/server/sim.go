@@ -0,0 +1,293 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/tile"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
+)
+
+//--------------------------------------------------------------------------------
+// - Simulation
+//--------------------------------------------------------------------------------
+
+// A scripted user in a Sim. It sends an input every network tick and keeps everything that the server sends it
+type SimClient struct {
+	UserId uint64
+	Id ecs.Id // The user's entity, once they're logged in
+	LoggedIn bool
+	Script []mmo.Input // The input for each network tick after logging in. Once the script runs out the user stands still
+
+	Updates []serdes.WorldUpdate // Every world update that was received, decoded into full snapshots
+	Messages []any // Everything else that was received, in order
+
+	sim *Sim
+	step int // The next input in the script
+	playerTick uint16
+	history *serdes.SnapshotBuffer
+	ackTick uint16
+	acked bool
+}
+
+// Returns the newest world update that was received
+func (c *SimClient) LastUpdate() (serdes.WorldUpdate, bool) {
+	if len(c.Updates) == 0 {
+		return serdes.WorldUpdate{}, false
+	}
+	return c.Updates[len(c.Updates) - 1], true
+}
+
+func (c *SimClient) Logout() {
+	c.sim.send(serdes.ClientLogout{c.UserId})
+}
+
+// Says something in the ChannelSay chat
+func (c *SimClient) Say(text string) {
+	c.sim.send(serdes.ChatMessage{
+		UserId: c.UserId,
+		Channel: mmo.ChannelSay,
+		Text: text,
+	})
+}
+
+func (c *SimClient) sendInput() {
+	input := mmo.Input{}
+	if c.step < len(c.Script) {
+		input = c.Script[c.step]
+	}
+	c.step++
+	c.playerTick = (c.playerTick + 1) % mmo.TickModulus
+
+	c.sim.send(serdes.PlayerInput{
+		UserId: c.UserId,
+		AckTick: c.ackTick,
+		RenderTick: c.ackTick, // Note: Sim users don't interpolate, so they see the newest tick
+		Acked: c.acked,
+		Inputs: []serdes.TickInput{{c.playerTick, input}},
+	})
+}
+
+func (c *SimClient) receive(msg any) error {
+	switch t := msg.(type) {
+	case serdes.WorldUpdate:
+		err := c.history.Decode(&t)
+		if err != nil {
+			return err
+		}
+		c.ackTick = t.Tick
+		c.acked = true
+		c.Updates = append(c.Updates, t)
+		return nil
+	case serdes.ClientLoginResp:
+		c.Id = t.Id
+		c.LoggedIn = true
+	case serdes.ClientLogoutResp, serdes.ZoneTransfer, serdes.UserKick:
+		c.LoggedIn = false
+	}
+	c.Messages = append(c.Messages, msg)
+	return nil
+}
+
+// Runs a server world in-process with scripted users standing in for a proxy, for tests
+//   - Everything runs on the caller's goroutine and the clock only moves when Step is called, so there are no sleeps and the same script always gives the same result
+//   - The users talk to the server over a transport.NewMemPipe, so every message goes through serdes. The pipe is polled instead of read by ServeProxyConnection, so that nothing runs on another goroutine
+//   - There is no packet loss or latency, every message arrives on the tick that it was sent
+type Sim struct {
+	World *ecs.World
+	Tilemap *tile.Tilemap
+	Server *Server
+	Store *MemoryStore
+	Zone mmo.ZoneId
+	Now time.Time // The simulated time, this moves forward by mmo.FixedTimeStep every Step
+	Ticks int // The number of physics ticks that have run
+	Clients map[uint64]*SimClient
+	Unrouted []any // Messages from the server that weren't for any user (ie ServerShutdown)
+
+	systems []ecs.System
+	networkChannel chan serdes.WorldUpdate
+	deleteList *DeleteList
+	persister *Persister
+	chat *ChatRouter
+
+	serverConn *ServerConn
+	serverSock transport.PollSocket // The server's end of the connection
+	proxySock transport.PollSocket // The simulated proxy's end of the connection
+}
+
+func NewSim(zone mmo.ZoneId) *Sim {
+	world := ecs.NewWorld()
+	tilemap := mmo.LoadZone(world, zone)
+	store := NewMemoryStore()
+	persister := NewPersister(store)
+	chat := NewChatRouter()
+	deleteList := NewDeleteList()
+	networkChannel := make(chan serdes.WorldUpdate, 1024)
+
+	server := NewServer(nil, nil)
+	serverSock, proxySock := transport.NewMemPipe(serdes.New())
+	serverConn := NewServerConn(serverSock, 0)
+	server.AddProxy(0, serverConn)
+
+	return &Sim{
+		World: world,
+		Tilemap: tilemap,
+		Server: server,
+		Store: store,
+		Zone: zone,
+		Now: time.Now(),
+		Clients: make(map[uint64]*SimClient),
+		Unrouted: make([]any, 0),
+
+		systems: CreateServerSystems(world, server, networkChannel, deleteList, tilemap, persister, chat, zone),
+		networkChannel: networkChannel,
+		deleteList: deleteList,
+		persister: persister,
+		chat: chat,
+
+		serverConn: serverConn,
+		serverSock: serverSock,
+		proxySock: proxySock,
+	}
+}
+
+func (s *Sim) send(msg any) {
+	err := s.proxySock.Send(msg)
+	if err != nil {
+		panic(err) // Note: The sim's socket only fails if the message can't be serialized, which is a bug in the test
+	}
+}
+
+func (s *Sim) addClient(userId uint64, script []mmo.Input) *SimClient {
+	client := &SimClient{
+		UserId: userId,
+		Script: script,
+		Updates: make([]serdes.WorldUpdate, 0),
+		Messages: make([]any, 0),
+		sim: s,
+		history: serdes.NewSnapshotBuffer(mmo.MaxSnapshotAge),
+	}
+	s.Clients[userId] = client
+	return client
+}
+
+// Adds a user and sends their login. They start following the script once the server has logged them in
+func (s *Sim) Login(userId uint64, script []mmo.Input) *SimClient {
+	client := s.addClient(userId, script)
+	s.send(serdes.ClientLogin{userId})
+	return client
+}
+
+// Adds a user that arrives from another zone with their character, like the proxy does after a ZoneTransfer
+func (s *Sim) Transfer(userId uint64, zone mmo.ZoneId, character []ecs.Component, script []mmo.Input) *SimClient {
+	dat, err := serdes.MarshalComponents(character)
+	if err != nil {
+		panic(err)
+	}
+	client := s.addClient(userId, script)
+	s.send(serdes.ZoneTransfer{UserId: userId, Zone: zone, Character: dat})
+	return client
+}
+
+// Runs a single physics tick
+//   1. Every logged in user sends their next input (once per network tick)
+//   2. The server handles everything that the users sent
+//   3. The server's systems run
+//   4. Every user receives what the server sent them
+func (s *Sim) Step() error {
+	if s.Ticks % mmo.NetworkTickDivider == 0 {
+		for _, userId := range s.userIds() {
+			client := s.Clients[userId]
+			if !client.LoggedIn { continue }
+			client.sendInput()
+		}
+	}
+
+	for {
+		msg, ok, err := s.serverSock.Poll()
+		if !ok { break }
+		if err != nil { return err }
+		handleProxyMessage(s.serverConn, msg, s.Now, s.World, s.networkChannel, s.deleteList, s.persister, s.chat, s.Zone)
+	}
+
+	for _, sys := range s.systems {
+		sys.Func(mmo.FixedTimeStep)
+	}
+
+	for {
+		msg, ok, err := s.proxySock.Poll()
+		if !ok { break }
+		if err != nil { return err }
+		err = s.route(msg)
+		if err != nil { return err }
+	}
+
+	s.Ticks++
+	s.Now = s.Now.Add(mmo.FixedTimeStep)
+	return nil
+}
+
+// Runs the number of physics ticks, stopping at the first error
+func (s *Sim) Run(ticks int) error {
+	for i := 0; i < ticks; i++ {
+		err := s.Step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the user ids in order, so that the users always send in the same order
+func (s *Sim) userIds() []uint64 {
+	ret := make([]uint64, 0, len(s.Clients))
+	for userId := range s.Clients {
+		ret = append(ret, userId)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// Hands the message to the user that it is for
+func (s *Sim) route(msg any) error {
+	var userId uint64
+	switch t := msg.(type) {
+	case serdes.WorldUpdate:
+		userId = t.UserId
+	case serdes.ClientLoginResp:
+		userId = t.UserId
+	case serdes.ClientLogoutResp:
+		userId = t.UserId
+	case serdes.ChatMessage:
+		userId = t.UserId
+	case serdes.ZoneTransfer:
+		userId = t.UserId
+	case serdes.UserKick:
+		userId = t.UserId
+	default:
+		s.Unrouted = append(s.Unrouted, msg)
+		return nil
+	}
+
+	client, ok := s.Clients[userId]
+	if !ok {
+		return fmt.Errorf("server sent %T to unknown user %d", msg, userId)
+	}
+	// Note: Nothing is lost in the sim, so a missing delta baseline is a bug
+	return client.receive(msg)
+}
+
+// Returns the user's position on the server
+func (s *Sim) Pos(userId uint64) (phy2.Pos, bool) {
+	client, ok := s.Clients[userId]
+	if !ok || !client.LoggedIn {
+		return phy2.Pos{}, false
+	}
+	return ecs.Read[phy2.Pos](s.World, client.Id)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+	"github.com/unitoftime/mmo/transport"
+)
+
+// Returns where a character ends up after the script, by replaying it the same way the server applies inputs
+func replayScript(sim *Sim, script []mmo.Input) phy2.Pos {
+	pos := mmo.SpawnPoint()
+	collider := phy2.NewCircleCollider(6)
+	for i := range script {
+		for ii := 0; ii < mmo.NetworkTickDivider; ii++ {
+			mmo.MoveCharacter(&script[i], &pos, &collider, sim.Tilemap, mmo.FixedTimeStep)
+		}
+	}
+	return pos
+}
+
+func repeatInput(input mmo.Input, n int) []mmo.Input {
+	ret := make([]mmo.Input, n)
+	for i := range ret {
+		ret[i] = input
+	}
+	return ret
+}
+
+func TestSimMovement(t *testing.T) {
+	sim := NewSim(mmo.DefaultZone)
+	script := append(repeatInput(mmo.Input{Right: true}, 10), repeatInput(mmo.Input{Up: true}, 5)...)
+	walker := sim.Login(1, script)
+	watcher := sim.Login(2, nil)
+
+	// Everyone spawns on top of each other
+	err := sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+	if !walker.LoggedIn || !watcher.LoggedIn {
+		t.Fatalf("Expected both users to be logged in")
+	}
+	cache, _ := ecs.Read[phy2.ColliderCache](sim.World, watcher.Id)
+	if len(cache.Current) != 1 || cache.Current[0] != walker.Id {
+		t.Errorf("Expected the users to collide when they spawn, got %v", cache.Current)
+	}
+
+	err = sim.Run(30 * mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	expected := replayScript(sim, script)
+	pos, ok := sim.Pos(1)
+	if !ok || pos != expected {
+		t.Errorf("Expected the walker at %v, got %v", expected, pos)
+	}
+	pos, ok = sim.Pos(2)
+	if !ok || pos != mmo.SpawnPoint() {
+		t.Errorf("Expected the watcher to stay at the spawn point, got %v", pos)
+	}
+
+	// They walked apart
+	cache, _ = ecs.Read[phy2.ColliderCache](sim.World, watcher.Id)
+	if len(cache.Current) != 0 {
+		t.Errorf("Expected the users to stop colliding, got %v", cache.Current)
+	}
+
+	// The watcher was sent where the walker is
+	update, ok := watcher.LastUpdate()
+	if !ok {
+		t.Fatalf("Expected the watcher to receive world updates")
+	}
+	found := false
+	for _, c := range update.WorldData[walker.Id] {
+		if c == ecs.C(expected) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the watcher's update to have the walker at %v, got %v", expected, update.WorldData[walker.Id])
+	}
+	if update.PlayerTick == 0 {
+		t.Errorf("Expected the update to acknowledge the watcher's inputs")
+	}
+}
+
+func TestSimChatAndLogout(t *testing.T) {
+	sim := NewSim(mmo.DefaultZone)
+	leaver := sim.Login(1, repeatInput(mmo.Input{Left: true}, 5))
+	listener := sim.Login(2, nil)
+
+	err := sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	leaver.Say("bye")
+	err = sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	heard := false
+	for _, msg := range listener.Messages {
+		chat, ok := msg.(serdes.ChatMessage)
+		if ok && chat.From == 1 && chat.Text == "bye" {
+			heard = true
+		}
+	}
+	if !heard {
+		t.Errorf("Expected the listener to hear the leaver, got %v", listener.Messages)
+	}
+
+	err = sim.Run(10 * mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+	pos, _ := sim.Pos(1)
+	leaverId := leaver.Id
+
+	leaver.Logout()
+	err = sim.Run(2 * mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	if leaver.LoggedIn {
+		t.Errorf("Expected the leaver to get a logout response")
+	}
+	if _, ok := ecs.Read[User](sim.World, leaverId); ok {
+		t.Errorf("Expected the leaver's entity to be deleted")
+	}
+	update, _ := listener.LastUpdate()
+	if _, ok := update.WorldData[leaverId]; ok {
+		t.Errorf("Expected the leaver to be removed from the listener's updates")
+	}
+
+	sim.persister.Flush()
+	character, err := sim.Store.Load(1)
+	if err != nil || character[0] != ecs.C(pos) {
+		t.Errorf("Expected the leaver to be saved at %v, got %v %v", pos, character, err)
+	}
+}
+
+// A user that logs out and straight back in gets the character that their logout saved
+func TestSimRelogin(t *testing.T) {
+	sim := NewSim(mmo.DefaultZone)
+	sim.Login(1, repeatInput(mmo.Input{Right: true}, 5))
+	err := sim.Run(10 * mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+	pos, _ := sim.Pos(1)
+	if pos == mmo.SpawnPoint() {
+		t.Fatalf("Expected the user to walk away from the spawn point")
+	}
+
+	// Note: Both messages arrive on the same tick, before the logout has been saved
+	sim.Clients[1].Logout()
+	client := sim.Login(1, nil)
+	err = sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	if !client.LoggedIn {
+		t.Fatalf("Expected the user to be logged back in")
+	}
+	relogPos, ok := sim.Pos(1)
+	if !ok || relogPos != pos {
+		t.Errorf("Expected the user to log back in at %v, got %v", pos, relogPos)
+	}
+	if len(sim.serverConn.Users()) != 1 {
+		t.Errorf("Expected one user on the server, got %v", sim.serverConn.Users())
+	}
+}
+
+// The same script always gives the same result
+func TestSimDeterministic(t *testing.T) {
+	run := func() (phy2.Pos, phy2.Pos, int) {
+		sim := NewSim(mmo.DefaultZone)
+		sim.Login(1, []mmo.Input{{Right: true}, {Right: true, Up: true}, {}, {Down: true}, {Left: true}})
+		sim.Login(2, []mmo.Input{{Up: true}, {Up: true}, {Left: true, Down: true}})
+		err := sim.Run(20 * mmo.NetworkTickDivider)
+		if err != nil { t.Fatal(err) }
+		a, _ := sim.Pos(1)
+		b, _ := sim.Pos(2)
+		return a, b, len(sim.Clients[1].Updates)
+	}
+
+	a1, b1, n1 := run()
+	a2, b2, n2 := run()
+	if a1 != a2 || b1 != b2 || n1 != n2 {
+		t.Errorf("Expected identical runs, got %v %v %d and %v %v %d", a1, b1, n1, a2, b2, n2)
+	}
+}
+
+// The sim's socket also works with the real proxy handler
+// The sim polls its pipe, but the server can serve a proxy over one like any other socket
+func TestSimPipe(t *testing.T) {
+	world := ecs.NewWorld()
+	serverSock, proxySock := transport.NewMemPipe(serdes.New())
+	serverConn := NewServerConn(serverSock, 0)
+
+	done := make(chan error)
+	go func() {
+		done <- ServeProxyConnection(serverConn, world, make(chan serdes.WorldUpdate, 16), NewDeleteList(), NewPersister(NewMemoryStore()), NewChatRouter(), mmo.DefaultZone)
+	}()
+
+	err := proxySock.Send(serdes.NewHello())
+	if err != nil { t.Fatal(err) }
+	msg, err := proxySock.Recv()
+	if _, ok := msg.(serdes.Hello); !ok || err != nil {
+		t.Errorf("Expected a hello back, got %v %v", msg, err)
+	}
+
+	proxySock.Close()
+	if err := <-done; err == nil {
+		t.Errorf("Expected the handler to stop once the socket closed")
+	}
+	if err := proxySock.Send(serdes.NewHello()); err == nil {
+		t.Errorf("Expected sending on a closed socket to fail")
+	}
+}
@@ -21,21 +21,41 @@ type User struct {
 // This is the tick that the client says they are on
 type ClientTick struct {
 	Tick uint16 // This is the tick that the player is currently on
+	AckTick uint16 // This is the last server tick that the player has received
+	RenderTick uint16 // This is the server tick that the player is rendering other entities at
+	Acked bool // This is false if the player hasn't received any server ticks yet
 }
 
-func CreateServerSystems(world *ecs.World, server *Server, networkChannel chan serdes.WorldUpdate, deleteList *DeleteList, tilemap *tile.Tilemap) []ecs.System {
+// This tracks the entities that the server has replicated to a user (ie the entities that the client currently knows about)
+type Replication struct {
+	Known map[ecs.Id]bool
+	History *serdes.SnapshotBuffer // The recent snapshots sent to the user, used as delta baselines
+}
+
+func NewReplication() Replication {
+	return Replication{
+		Known: make(map[ecs.Id]bool),
+		History: serdes.NewSnapshotBuffer(mmo.MaxSnapshotAge),
+	}
+}
+
+func CreateServerSystems(world *ecs.World, server *Server, networkChannel chan serdes.WorldUpdate, deleteList *DeleteList, tilemap *tile.Tilemap, persister *Persister, chat *ChatRouter, zone mmo.ZoneId) []ecs.System {
 	serverSystems := []ecs.System{
-		CreatePollNetworkSystem(world, networkChannel),
+		CreatePollNetworkSystem(world, networkChannel, persister),
+		CreateLoginSystem(world, server, networkChannel, persister, zone),
+		CreateChatSystem(world, server, chat),
 	}
 
 	// serverSystems = append(serverSystems,
 	// 	CreatePhysicsSystems(world)...)
 	serverSystems = append(serverSystems,
+		CreateApplyInputSystem(world, server, tilemap),
 		ecs.System{"MoveCharacters", func(dt time.Duration) {
 			ecs.Map3(world, func(id ecs.Id, input *mmo.Input, pos *phy2.Pos, collider *phy2.CircleCollider) {
 				mmo.MoveCharacter(input, pos, collider, tilemap, dt)
 			})
 		}},
+		CreateZoneTransferSystem(world, server, tilemap, zone, persister),
 		ecs.System{"CheckCollisions", func(dt time.Duration) {
 			// Set the collider position
 			ecs.Map2(world, func(id ecs.Id, pos *phy2.Pos, col *phy2.CircleCollider) {
@@ -49,8 +69,9 @@ func CreateServerSystems(world *ecs.World, server *Server, networkChannel chan s
 
 	serverSystems = append(serverSystems, []ecs.System{
 		ecs.System{"ServerSendUpdate", func(dt time.Duration) {
-			ServerSendUpdate(world, server, deleteList)
+			ServerSendUpdate(world, server, deleteList, persister)
 		}},
+		CreatePersistSystem(world, persister),
 	}...)
 
 	return serverSystems
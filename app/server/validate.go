@@ -0,0 +1,134 @@
+package server
+
+import (
+	"time"
+	"errors"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// Note: Duplicates are expected because the client repeats every unacknowledged input (See mmo.InputRedundancy), so they are dropped but aren't suspicious
+var ErrInputDuplicate = errors.New("duplicate input")
+
+var ErrInputOutOfOrder = errors.New("out of order input")
+var ErrInputTickJump = errors.New("input tick jumped too far ahead")
+var ErrInputRate = errors.New("input rate exceeded")
+var ErrInputTooMany = errors.New("too many inputs in one message")
+
+// Returns true if the validation error indicates that the client might be modified
+func IsSuspicious(err error) bool {
+	return err != nil && !errors.Is(err, ErrInputDuplicate)
+}
+
+// The time between each input that the client sends
+const inputInterval = mmo.NetworkTickDivider * mmo.FixedTimeStep
+
+// The largest amount that a client's player tick can advance in one message. This also limits how far ahead of real time a client can get
+const maxInputTickJump = 32
+
+// Validates the inputs that a single user sends to the server
+type InputValidator struct {
+	started bool
+	lastTick uint16   // The last player tick that was accepted
+
+	lastRefill time.Time
+	tickTokens float64    // Limits how fast the player tick can advance (ie speedhacks)
+	messageTokens float64 // Limits how many messages can be sent (ie flooding)
+
+	Violations int // The number of suspicious inputs this user has sent
+}
+
+func NewInputValidator(now time.Time) *InputValidator {
+	return &InputValidator{
+		lastRefill: now,
+		tickTokens: maxInputTickJump,
+		messageTokens: maxInputTickJump,
+	}
+}
+
+func (v *InputValidator) refill(now time.Time) {
+	intervals := float64(now.Sub(v.lastRefill)) / float64(inputInterval)
+	v.lastRefill = now
+	if intervals <= 0 { return }
+
+	v.tickTokens += intervals
+	if v.tickTokens > maxInputTickJump {
+		v.tickTokens = maxInputTickJump
+	}
+
+	v.messageTokens += intervals
+	if v.messageTokens > maxInputTickJump {
+		v.messageTokens = maxInputTickJump
+	}
+}
+
+// Returns the inputs from the message that haven't been applied yet, oldest first. Inputs that were already accepted are dropped quietly
+// Returns ErrInputDuplicate if nothing in the message was new, or the reason that the rest of the message was rejected
+func (v *InputValidator) Check(inputs []serdes.TickInput, now time.Time) ([]serdes.TickInput, error) {
+	accepted, err := v.check(inputs, now)
+	if IsSuspicious(err) {
+		v.Violations++
+	}
+	return accepted, err
+}
+
+func (v *InputValidator) check(inputs []serdes.TickInput, now time.Time) ([]serdes.TickInput, error) {
+	v.refill(now)
+
+	if v.messageTokens < 1 {
+		return nil, ErrInputRate
+	}
+	v.messageTokens--
+
+	if len(inputs) > mmo.InputRedundancy {
+		return nil, ErrInputTooMany
+	}
+
+	accepted := make([]serdes.TickInput, 0, len(inputs))
+	for _, input := range inputs {
+		err := v.checkTick(input.Tick)
+		if errors.Is(err, ErrInputDuplicate) {
+			continue
+		}
+		if err != nil {
+			return accepted, err
+		}
+		accepted = append(accepted, input)
+	}
+
+	if len(accepted) == 0 {
+		return accepted, ErrInputDuplicate
+	}
+	return accepted, nil
+}
+
+func (v *InputValidator) checkTick(playerTick uint16) error {
+	if !v.started {
+		v.started = true
+		v.lastTick = playerTick
+		v.tickTokens--
+		return nil
+	}
+
+	// Note: Ticks wrap around, so we compare them as a signed distance
+	diff := mmo.TickDiff(playerTick, v.lastTick)
+	if diff <= 0 {
+		// Old messages can arrive late, but nothing should be older than the inputs we could have been sent before the last accepted one
+		if diff <= -maxInputTickJump {
+			return ErrInputOutOfOrder
+		}
+		return ErrInputDuplicate
+	} else if diff > maxInputTickJump {
+		return ErrInputTickJump
+	}
+
+	// Each tick represents a fixed amount of time on the client, so the ticks can't advance faster than real time
+	if v.tickTokens < float64(diff) {
+		return ErrInputRate
+	}
+	v.tickTokens -= float64(diff)
+
+	v.lastTick = playerTick
+	return nil
+}
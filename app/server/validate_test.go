@@ -0,0 +1,97 @@
+package server
+
+import (
+	"time"
+	"testing"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// Returns the inputs for every tick in [start, end]
+func tickInputs(start, end uint16) []serdes.TickInput {
+	ret := make([]serdes.TickInput, 0)
+	for tick := start; tick != end + 1; tick++ {
+		ret = append(ret, serdes.TickInput{Tick: tick})
+	}
+	return ret
+}
+
+func TestInputValidator(t *testing.T) {
+	now := time.Now()
+	v := NewInputValidator(now)
+
+	// Normal client: Each message repeats the last InputRedundancy ticks, once every input interval
+	for tick := uint16(100); tick < 200; tick++ {
+		now = now.Add(inputInterval)
+		start := tick - mmo.InputRedundancy + 1
+		accepted, err := v.Check(tickInputs(start, tick), now)
+		if err != nil {
+			t.Fatalf("Tick %d: Expected input to be accepted: %v", tick, err)
+		}
+		// Note: Everything in the first message is new
+		expected := 1
+		if tick == 100 {
+			expected = mmo.InputRedundancy
+		}
+		if len(accepted) != expected || accepted[len(accepted)-1].Tick != tick {
+			t.Fatalf("Tick %d: Expected only the new inputs to be accepted: %v", tick, accepted)
+		}
+	}
+	if v.Violations != 0 {
+		t.Fatalf("Expected no violations, got %d", v.Violations)
+	}
+
+	// A message that arrived late only has old inputs
+	if _, err := v.Check(tickInputs(190, 197), now); err != ErrInputDuplicate {
+		t.Errorf("Expected duplicate: %v", err)
+	}
+
+	// After some messages were lost, every input that we missed is accepted in order
+	now = now.Add(3 * inputInterval)
+	accepted, err := v.Check(tickInputs(196, 203), now)
+	if err != nil || len(accepted) != 4 || accepted[0].Tick != 200 || accepted[3].Tick != 203 {
+		t.Errorf("Expected the missed inputs to be accepted: %v %v", accepted, err)
+	}
+
+	// Out of order, tick jumps, and messages with too many inputs
+	now = now.Add(inputInterval)
+	if _, err := v.Check(tickInputs(150, 150), now); err != ErrInputOutOfOrder {
+		t.Errorf("Expected out of order: %v", err)
+	}
+	now = now.Add(inputInterval)
+	if _, err := v.Check(tickInputs(203 + maxInputTickJump + 1, 203 + maxInputTickJump + 1), now); err != ErrInputTickJump {
+		t.Errorf("Expected tick jump: %v", err)
+	}
+	now = now.Add(inputInterval)
+	if _, err := v.Check(tickInputs(204, 204 + mmo.InputRedundancy), now); err != ErrInputTooMany {
+		t.Errorf("Expected too many inputs: %v", err)
+	}
+
+	// Speedhack: Ticks advancing much faster than real time
+	tick := uint16(204)
+	for i := 0; i < 2 * maxInputTickJump; i++ {
+		now = now.Add(inputInterval / 4)
+		_, err = v.Check(tickInputs(tick, tick), now)
+		if err != nil { break }
+		tick++
+	}
+	if err != ErrInputRate {
+		t.Errorf("Expected rate limit: %v", err)
+	}
+
+	if v.Violations != 4 {
+		t.Errorf("Expected 4 violations, got %d", v.Violations)
+	}
+
+	// Wraparound
+	v = NewInputValidator(now)
+	if _, err := v.Check(tickInputs(mmo.TickModulus - 2, mmo.TickModulus - 1), now); err != nil {
+		t.Errorf("Expected accept: %v", err)
+	}
+	wrapped := []serdes.TickInput{{Tick: mmo.TickModulus - 1}, {Tick: 0}, {Tick: 1}}
+	accepted, err = v.Check(wrapped, now.Add(4 * inputInterval))
+	if err != nil || len(accepted) != 2 {
+		t.Errorf("Expected wrapped ticks to be accepted: %v %v", accepted, err)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/tile"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/stat"
+)
+
+// Moves every user that is standing on a portal to the portal's target zone
+func CreateZoneTransferSystem(world *ecs.World, server *Server, tilemap *tile.Tilemap, zoneId mmo.ZoneId, persister *Persister) ecs.System {
+	zone := mmo.GetZone(zoneId)
+
+	type transfer struct {
+		id ecs.Id
+		user User
+		portal mmo.Portal
+	}
+	transfers := make([]transfer, 0)
+
+	sys := ecs.System{"ZoneTransfer", func(dt time.Duration) {
+		transfers = transfers[:0]
+		ecs.Map2(world, func(id ecs.Id, user *User, pos *phy2.Pos) {
+			portal, ok := zone.PortalAt(tilemap, *pos)
+			if !ok { return }
+			transfers = append(transfers, transfer{id, *user, portal})
+		})
+
+		for _, t := range transfers {
+			log.Print("Transferring user ", t.user.Id, " to zone ", t.portal.TargetZone)
+
+			// Move the character into the target zone, then save it so that the user logs back into the target zone if the transfer gets interrupted
+			ecs.Write(world, t.id,
+				ecs.C(t.portal.TargetPos(tilemap)),
+				ecs.C(t.portal.TargetZone),
+			)
+			character := readCharacter(world, t.id)
+			persister.Save(t.user.Id, character)
+
+			proxy, ok := server.GetProxy(t.user.ProxyId)
+			if ok {
+				transferUser(proxy, t.user.Id, t.portal.TargetZone, character)
+				proxy.LogoutUser(t.user.Id)
+			} else {
+				log.Error().Uint64(stat.UserId, t.user.Id).Msg("Missing proxy for transferred user")
+			}
+
+			// Note: Every user that could see this entity will get it deleted on the next update because it's no longer in the world
+			ecs.Delete(world, t.id)
+		}
+	}}
+	return sys
+}
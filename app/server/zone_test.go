@@ -0,0 +1,152 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// Returns the zone and position in the character, if it has them
+func characterZonePos(character []ecs.Component) (mmo.ZoneId, phy2.Pos, bool, bool) {
+	zone, hasZone := getZone(character)
+	var pos phy2.Pos
+	hasPos := false
+	for _, c := range character {
+		box, ok := c.(ecs.CompBox[phy2.Pos])
+		if ok {
+			pos = box.Get()
+			hasPos = true
+		}
+	}
+	return zone, pos, hasZone, hasPos
+}
+
+// Returns the last zone transfer that the user received
+func lastTransfer(client *SimClient) (serdes.ZoneTransfer, bool) {
+	for i := len(client.Messages) - 1; i >= 0; i-- {
+		transfer, ok := client.Messages[i].(serdes.ZoneTransfer)
+		if ok {
+			return transfer, true
+		}
+	}
+	return serdes.ZoneTransfer{}, false
+}
+
+func TestZoneTransferPortal(t *testing.T) {
+	sim := NewSim(mmo.DefaultZone)
+	client := sim.Login(1, nil)
+	err := sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+	if !client.LoggedIn {
+		t.Fatalf("Expected the user to be logged in")
+	}
+	id := client.Id
+
+	// Put the user on the portal
+	portal := mmo.GetZone(mmo.DefaultZone).Portals[0]
+	x, y := sim.Tilemap.TileToPosition(portal.Tile)
+	ecs.Write(sim.World, id, ecs.C(phy2.Pos{float64(x), float64(y)}))
+
+	err = sim.Step()
+	if err != nil { t.Fatal(err) }
+
+	transfer, ok := lastTransfer(client)
+	if !ok {
+		t.Fatalf("Expected a zone transfer, got %v", client.Messages)
+	}
+	if transfer.Zone != portal.TargetZone {
+		t.Errorf("Expected a transfer to zone %d, got %d", portal.TargetZone, transfer.Zone)
+	}
+	character, err := serdes.UnmarshalComponents(transfer.Character)
+	if err != nil { panic(err) }
+	targetPos := portal.TargetPos(sim.Tilemap)
+	zone, pos, hasZone, hasPos := characterZonePos(character)
+	if !hasZone || zone != portal.TargetZone || !hasPos || pos != targetPos {
+		t.Errorf("Expected the transferred character in zone %d at %v, got %v", portal.TargetZone, targetPos, character)
+	}
+
+	// The user has left this server
+	if _, ok := ecs.Read[User](sim.World, id); ok {
+		t.Errorf("Expected the user's entity to be deleted")
+	}
+	if _, ok := sim.serverConn.GetUser(1); ok {
+		t.Errorf("Expected the user to be logged out of the proxy connection")
+	}
+
+	// The character is saved in the target zone, in case the transfer gets interrupted
+	sim.persister.Flush()
+	saved, err := sim.Store.Load(1)
+	if err != nil { panic(err) }
+	zone, pos, hasZone, hasPos = characterZonePos(saved)
+	if !hasZone || zone != portal.TargetZone || !hasPos || pos != targetPos {
+		t.Errorf("Expected the character to be saved in zone %d at %v, got %v", portal.TargetZone, targetPos, saved)
+	}
+}
+
+// A user that logs into a different zone than the one they were saved in gets sent back to their zone
+func TestZoneLoginRedirect(t *testing.T) {
+	sim := NewSim(mmo.DefaultZone)
+	savedPos := phy2.Pos{100, 200}
+	err := sim.Store.Save(1, []ecs.Component{ecs.C(savedPos), ecs.C(mmo.ZoneId(1))})
+	if err != nil { panic(err) }
+
+	client := sim.Login(1, nil)
+	err = sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	if client.LoggedIn {
+		t.Errorf("Expected the user not to be logged into this zone")
+	}
+	if len(sim.serverConn.Users()) != 0 {
+		t.Errorf("Expected no users on the server, got %v", sim.serverConn.Users())
+	}
+	transfer, ok := lastTransfer(client)
+	if !ok {
+		t.Fatalf("Expected a zone transfer, got %v", client.Messages)
+	}
+	if transfer.Zone != 1 {
+		t.Errorf("Expected a transfer to zone 1, got %d", transfer.Zone)
+	}
+	character, err := serdes.UnmarshalComponents(transfer.Character)
+	if err != nil { panic(err) }
+	_, pos, _, hasPos := characterZonePos(character)
+	if !hasPos || pos != savedPos {
+		t.Errorf("Expected the saved character to be transferred, got %v", character)
+	}
+}
+
+// The server that owns the target zone logs the transferred user in with the character that they brought
+func TestZoneTransferLogin(t *testing.T) {
+	sim := NewSim(1)
+	arrivePos := phy2.Pos{100, 200}
+	character := []ecs.Component{ecs.C(arrivePos), ecs.C(mmo.ZoneId(1))}
+	client := sim.Transfer(1, 1, character, nil)
+
+	// Transfers for zones that this server doesn't own are dropped
+	wrongZone := sim.Transfer(2, 0, character, nil)
+
+	err := sim.Run(mmo.NetworkTickDivider)
+	if err != nil { t.Fatal(err) }
+
+	if wrongZone.LoggedIn {
+		t.Errorf("Expected the transfer for the wrong zone to be dropped")
+	}
+	if !client.LoggedIn {
+		t.Fatalf("Expected the transferred user to be logged in")
+	}
+	pos, ok := sim.Pos(1)
+	if !ok || pos != arrivePos {
+		t.Errorf("Expected the user to arrive at %v, got %v", arrivePos, pos)
+	}
+	zone, ok := ecs.Read[mmo.ZoneId](sim.World, client.Id)
+	if !ok || zone != 1 {
+		t.Errorf("Expected the user to be in zone 1, got %v", zone)
+	}
+	if len(sim.serverConn.Users()) != 1 {
+		t.Errorf("Expected one user on the server, got %v", sim.serverConn.Users())
+	}
+}
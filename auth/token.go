@@ -0,0 +1,73 @@
+package auth
+
+// This package creates and validates the signed session tokens that clients use to log into the proxy
+// Token format: base64(claims json) + "." + base64(HMAC-SHA256 of the encoded claims)
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/base64"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+var ErrExpiredToken = errors.New("token expired")
+
+type Claims struct {
+	UserId uint64 // The account id of the user
+	Expiry int64  // Unix time (in seconds) after which the token is no longer valid
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Creates a new token for the user that expires at the specified time
+func NewToken(secret []byte, userId uint64, expiry time.Time) (string, error) {
+	claims := Claims{
+		UserId: userId,
+		Expiry: expiry.Unix(),
+	}
+	dat, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(dat)
+	return payload + "." + sign(secret, payload), nil
+}
+
+// Validates the signature and expiration of the token and returns the claims inside of it
+func Validate(secret []byte, token string, now time.Time) (Claims, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, payload))) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	dat, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	claims := Claims{}
+	err = json.Unmarshal(dat, &claims)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	if now.Unix() > claims.Expiry {
+		return Claims{}, ErrExpiredToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"time"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+
+	token, err := NewToken(secret, 1234, now.Add(time.Hour))
+	if err != nil { panic(err) }
+
+	claims, err := Validate(secret, token, now)
+	if err != nil {
+		t.Errorf("Expected valid token: %v", err)
+	}
+	if claims.UserId != 1234 {
+		t.Errorf("Expected UserId 1234, got %d", claims.UserId)
+	}
+
+	// Wrong secret
+	_, err = Validate([]byte("other"), token, now)
+	if err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+
+	// Tampered claims
+	forged, err := NewToken([]byte("other"), 1, now.Add(time.Hour))
+	if err != nil { panic(err) }
+	_, err = Validate(secret, forged[:len(forged)/2] + token[len(token)/2:], now)
+	if err == nil {
+		t.Errorf("Expected tampered token to be invalid")
+	}
+
+	// Expired
+	_, err = Validate(secret, token, now.Add(2 * time.Hour))
+	if err != ErrExpiredToken {
+		t.Errorf("Expected ErrExpiredToken, got %v", err)
+	}
+
+	// Garbage
+	_, err = Validate(secret, "garbage", now)
+	if err != ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package mmo
+
+// The channel that a chat message is sent on. This determines who receives it
+type ChatChannel uint8
+
+const (
+	ChannelSay ChatChannel = iota // Sent to every user within ChatDistance of the sender, and shown as a speech bubble
+	ChannelGlobal                 // Sent to every user in every zone
+	ChannelWhisper                // Sent to a single user
+	ChannelSystem                 // Sent by the server, clients can't send on this channel
+)
+
+func (c ChatChannel) String() string {
+	switch c {
+	case ChannelSay:
+		return "Say"
+	case ChannelGlobal:
+		return "Global"
+	case ChannelWhisper:
+		return "Whisper"
+	case ChannelSystem:
+		return "System"
+	}
+	return "Unknown"
+}
+
+// The distance around the sender that say messages reach
+const ChatDistance float64 = 20 * 16
+
+// The reason that the proxy rejected a chat message, this is sent back to the sender (See: moderation)
+type ChatRejectReason uint8
+
+const (
+	RejectNone ChatRejectReason = iota // The message was accepted
+	RejectEmpty                        // The message had no visible text
+	RejectTooLong                      // The message was longer than the max length
+	RejectScript                       // The message used characters from a script that isn't allowed
+	RejectRateLimited                  // The user sent too many messages too quickly
+	RejectMuted                        // The user is muted
+)
+
+func (r ChatRejectReason) String() string {
+	switch r {
+	case RejectNone:
+		return "Accepted"
+	case RejectEmpty:
+		return "Message is empty"
+	case RejectTooLong:
+		return "Message is too long"
+	case RejectScript:
+		return "Message contains characters that aren't allowed"
+	case RejectRateLimited:
+		return "You are sending messages too quickly"
+	case RejectMuted:
+		return "You are muted"
+	}
+	return "Unknown"
+}
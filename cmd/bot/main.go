@@ -0,0 +1,55 @@
+package main
+
+// Connects simulated players to the proxy for load testing. The secret is read from the MMO_TOKEN_SECRET environment variable and must match the proxy's
+
+import (
+	"os"
+	"flag"
+	"time"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/app/bot"
+)
+
+var url = flag.String("url", "webrtc://localhost:7777", "the url that the proxy listens for clients on")
+var count = flag.Int("n", 10, "the number of bots")
+var firstUser = flag.Uint64("user", 1000000, "the account id of the first bot, the rest count up from it")
+var ramp = flag.Duration("ramp", 100 * time.Millisecond, "the time between each bot connecting")
+var duration = flag.Duration("duration", 0, "how long to run for, zero to run until interrupted")
+var script = flag.String("script", "", "the inputs that every bot repeats (ie up:10,right:10,down:10,left:10), the bots random walk if empty")
+var chat = flag.Duration("chat", 30 * time.Second, "how often each bot says something, zero to disable chat")
+var report = flag.Duration("report", 10 * time.Second, "how often the statistics are logged, zero to only log them at the end")
+var insecure = flag.Bool("insecure", true, "skip verifying the proxy's certificate")
+var seed = flag.Int64("seed", time.Now().UnixNano(), "seeds the random walks and chat")
+
+func main() {
+	flag.Parse()
+
+	secret := os.Getenv("MMO_TOKEN_SECRET")
+	if secret == "" {
+		panic("MMO_TOKEN_SECRET must be set")
+	}
+
+	var inputs []mmo.Input
+	if *script != "" {
+		var err error
+		inputs, err = bot.ParseScript(*script)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	bot.Main(bot.Config{
+		ProxyUrl: *url,
+		TokenSecret: []byte(secret),
+		Count: *count,
+		FirstUserId: *firstUser,
+		Ramp: *ramp,
+		Duration: *duration,
+		Script: inputs,
+		ChatInterval: *chat,
+		ReportInterval: *report,
+		Insecure: *insecure,
+		Seed: *seed,
+	})
+}
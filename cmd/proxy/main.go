@@ -1,14 +1,23 @@
 package main
 
 import (
+	"os"
+
+	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/app/proxy"
 )
 
 func main() {
 	proxy.Main(proxy.Config{
-		ServerUri: "tcp://127.0.0.1:9000",
+		Zones: map[mmo.ZoneId]string{
+			0: "tcp://127.0.0.1:9000",
+			1: "tcp://127.0.0.1:9001",
+		},
 		Test: true,
 		CertFile: "./build/cert.pem",
 		KeyFile: "./build/privkey.pem",
+		TokenSecret: []byte(os.Getenv("MMO_TOKEN_SECRET")),
+		ChatWordList: os.Getenv("MMO_CHAT_WORDLIST"),
+		MetricsAddr: os.Getenv("MMO_METRICS_ADDR"),
 	})
 }
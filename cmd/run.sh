@@ -3,9 +3,13 @@
 trap "trap - SIGTERM && kill -- -$$" SIGINT SIGTERM EXIT
 set -e
 
+# The proxy uses this to validate login tokens
+export MMO_TOKEN_SECRET=${MMO_TOKEN_SECRET:-local-test-secret}
+
 go run ./server &
+go run ./server -zone 1 -url tcp://127.0.0.1:9001 &
 sleep 2
 go run ./proxy &
 #sleep 2
-go run ./client --skip &
-go run ./client --skip
+go run ./client --skip --token $(go run ./token -user 1) &
+go run ./client --skip --token $(go run ./token -user 2)
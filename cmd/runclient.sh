@@ -2,12 +2,13 @@
 
 trap "trap - SIGTERM && kill -- -$$" SIGINT SIGTERM EXIT
 
+export MMO_TOKEN_SECRET=${MMO_TOKEN_SECRET:-local-test-secret}
+
 cd client
 for i in {0..10}
 do
     echo "NewClient" ${i}
-    go run . -skip &
+    go run . -skip -token $(go run ../token -user $((100 + i))) &
 done
 
-go run . -skip
-
+go run . -skip -token $(go run ../token -user 111)
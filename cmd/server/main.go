@@ -1,9 +1,29 @@
 package main
 
 import (
+	"flag"
+	"time"
+
+	"github.com/unitoftime/mmo"
 	"github.com/unitoftime/mmo/app/server"
 )
 
+var url = flag.String("url", "tcp://127.0.0.1:9000", "the url that proxies connect to")
+var zone = flag.Uint("zone", uint(mmo.DefaultZone), "the zone that this server owns")
+var admin = flag.String("admin", "", "the local url that the admin console listens on (ie tcp://127.0.0.1:9100), disabled if empty")
+var metrics = flag.String("metrics", "", "the local address that metrics are served on at /metrics (ie 127.0.0.1:9101), disabled if empty")
+var profile = flag.Duration("profile", time.Minute, "how often the time each system takes to run is logged, disabled if zero")
+var shutdown = flag.Duration("shutdown", 10 * time.Second, "how long users are warned for before the server shuts down")
+
 func main() {
-	server.Main()
+	flag.Parse()
+
+	server.Main(server.Config{
+		Url: *url,
+		Zone: mmo.ZoneId(*zone),
+		ShutdownCountdown: *shutdown,
+		AdminUrl: *admin,
+		MetricsAddr: *metrics,
+		ProfileInterval: *profile,
+	})
 }
@@ -0,0 +1,30 @@
+package main
+
+// Generates a login token for testing. The secret is read from the MMO_TOKEN_SECRET environment variable and must match the proxy's
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"time"
+
+	"github.com/unitoftime/mmo/auth"
+)
+
+var userId = flag.Uint64("user", 1, "the account id to put in the token")
+var ttl = flag.Duration("ttl", 24 * time.Hour, "how long the token is valid for")
+
+func main() {
+	flag.Parse()
+
+	secret := os.Getenv("MMO_TOKEN_SECRET")
+	if secret == "" {
+		panic("MMO_TOKEN_SECRET must be set")
+	}
+
+	token, err := auth.NewToken([]byte(secret), *userId, time.Now().Add(*ttl))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(token)
+}
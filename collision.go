@@ -0,0 +1,67 @@
+package mmo
+
+import (
+	"math"
+	"sort"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+)
+
+type gridEntry struct {
+	id ecs.Id
+	collider *phy2.CircleCollider
+	cache *phy2.ColliderCache
+}
+
+// This is a uniform grid (spatial hash) of colliders which is used as a broadphase for collision detection
+// Note: This holds pointers to components, so it is only valid until the next time the world is modified
+type CollisionGrid struct {
+	cellSize float64
+	maxRadius float64 // The largest radius of every collider in the grid
+	entries []gridEntry
+	cells map[phy2.HashPosition][]int // Indexes into the entries list
+}
+
+func NewCollisionGrid(cellSize float64) *CollisionGrid {
+	return &CollisionGrid{
+		cellSize: cellSize,
+		entries: make([]gridEntry, 0),
+		cells: make(map[phy2.HashPosition][]int),
+	}
+}
+
+func (g *CollisionGrid) toCell(x, y float64) phy2.HashPosition {
+	return phy2.HashPosition{
+		X: int32(math.Floor(x / g.cellSize)),
+		Y: int32(math.Floor(y / g.cellSize)),
+	}
+}
+
+func (g *CollisionGrid) Add(id ecs.Id, collider *phy2.CircleCollider, cache *phy2.ColliderCache) {
+	index := len(g.entries)
+	g.entries = append(g.entries, gridEntry{id, collider, cache})
+
+	cell := g.toCell(collider.CenterX, collider.CenterY)
+	g.cells[cell] = append(g.cells[cell], index)
+
+	if collider.Radius > g.maxRadius {
+		g.maxRadius = collider.Radius
+	}
+}
+
+// Appends the indexes of every collider that could possibly overlap the passed in collider to the candidates list. The list is returned in the order that colliders were added
+func (g *CollisionGrid) Nearby(candidates []int, collider *phy2.CircleCollider) []int {
+	reach := collider.Radius + g.maxRadius
+	min := g.toCell(collider.CenterX - reach, collider.CenterY - reach)
+	max := g.toCell(collider.CenterX + reach, collider.CenterY + reach)
+
+	for x := min.X; x <= max.X; x++ {
+		for y := min.Y; y <= max.Y; y++ {
+			candidates = append(candidates, g.cells[phy2.HashPosition{x, y}]...)
+		}
+	}
+
+	sort.Ints(candidates)
+	return candidates
+}
@@ -0,0 +1,121 @@
+package mmo
+
+import (
+	"fmt"
+	"testing"
+	"reflect"
+	"math/rand"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+)
+
+// This is the original brute force collision check, which checks every pair of colliders. CheckCollisions should always produce the same results
+func checkCollisionsBruteForce(world *ecs.World) {
+	ecs.Map2(world, func(idA ecs.Id, colA *phy2.CircleCollider, cacheA *phy2.ColliderCache) {
+		cacheA.Clear()
+		ecs.Map2(world, func(idB ecs.Id, colB *phy2.CircleCollider, cacheB *phy2.ColliderCache) {
+			if idA == idB { return } // Skip if collider is the same entity
+
+			if !colA.LayerMask(colB.Layer) { return } // Skip if layer mask doesn't match
+
+			// Check if there is a collision
+			if colA.Collides(1.0, colB) {
+				cacheA.Add(idB)
+			}
+		})
+	})
+}
+
+// Creates a world with a bunch of bodies randomly placed across the map
+func setupCollisionWorld(seed int64, numBodies int) *ecs.World {
+	rng := rand.New(rand.NewSource(seed))
+	world := ecs.NewWorld()
+
+	size := float64(mapSize * tileSize)
+	for i := 0; i < numBodies; i++ {
+		collider := phy2.NewCircleCollider(6)
+		collider.Layer = BodyLayer
+		collider.HitLayer = BodyLayer
+		if i % 10 == 0 {
+			collider = phy2.NewCircleCollider(8)
+			collider.Layer = WallLayer
+			collider.HitLayer = BodyLayer
+		}
+		collider.CenterX = rng.Float64() * size
+		collider.CenterY = rng.Float64() * size
+
+		ecs.Write(world, world.NewId(),
+			ecs.C(collider),
+			ecs.C(phy2.NewColliderCache()),
+		)
+	}
+	return world
+}
+
+func readCaches(world *ecs.World) map[ecs.Id]phy2.ColliderCache {
+	ret := make(map[ecs.Id]phy2.ColliderCache)
+	ecs.Map(world, func(id ecs.Id, cache *phy2.ColliderCache) {
+		ret[id] = phy2.ColliderCache{
+			Current: append([]ecs.Id{}, cache.Current...),
+			Last: append([]ecs.Id{}, cache.Last...),
+			NewCollisions: append([]ecs.Id{}, cache.NewCollisions...),
+		}
+	})
+	return ret
+}
+
+func TestCheckCollisionsMatchesBruteForce(t *testing.T) {
+	numBodies := 2000
+	expectedWorld := setupCollisionWorld(1, numBodies)
+	world := setupCollisionWorld(1, numBodies)
+
+	rng := rand.New(rand.NewSource(2))
+	numCollisions := 0
+	for i := 0; i < 3; i++ {
+		checkCollisionsBruteForce(expectedWorld)
+		CheckCollisions(world)
+
+		expected := readCaches(expectedWorld)
+		got := readCaches(world)
+
+		for id := range expected {
+			numCollisions += len(expected[id].Current)
+			if !reflect.DeepEqual(expected[id], got[id]) {
+				t.Fatalf("Iteration %d: Mismatched ColliderCache for %d\nExpected: %v\nGot: %v", i, id, expected[id], got[id])
+			}
+		}
+
+		// Move everything a bit so that the Last and NewCollisions lists get exercised
+		dx := rng.Float64() * 4
+		dy := rng.Float64() * 4
+		for _, w := range []*ecs.World{expectedWorld, world} {
+			ecs.Map(w, func(id ecs.Id, col *phy2.CircleCollider) {
+				if col.Layer == WallLayer { return }
+				col.CenterX += dx * float64(id % 3)
+				col.CenterY -= dy * float64(id % 5)
+			})
+		}
+	}
+	t.Logf("Collisions: %d", numCollisions)
+}
+
+func BenchmarkCheckCollisions(b *testing.B) {
+	for _, numBodies := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("Grid-%d", numBodies), func(b *testing.B) {
+			world := setupCollisionWorld(1, numBodies)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				CheckCollisions(world)
+			}
+		})
+
+		b.Run(fmt.Sprintf("BruteForce-%d", numBodies), func(b *testing.B) {
+			world := setupCollisionWorld(1, numBodies)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				checkCollisionsBruteForce(world)
+			}
+		})
+	}
+}
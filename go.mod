@@ -9,7 +9,7 @@ require (
 	github.com/unitoftime/flow v0.0.0-20221206183408-0f16e69e884b
 	github.com/unitoftime/glitch v0.0.0-20221125145215-98f80d8b228d
 	github.com/unitoftime/packer v0.0.0-20221103211833-11c7601528ba
-	github.com/zyedidia/generic v1.2.0
+	golang.org/x/text v0.5.0
 )
 
 require (
@@ -47,6 +47,7 @@ require (
 	github.com/ungerik/go3d v0.0.0-20220309204530-55ced4bcb334 // indirect
 	github.com/unitoftime/gl v0.0.0-20221010144157-ddeda43df375 // indirect
 	github.com/unitoftime/glfw v0.0.0-20221109201015-17c636a346cf // indirect
+	github.com/zyedidia/generic v1.2.0 // indirect
 	golang.org/x/crypto v0.3.0 // indirect
 	golang.org/x/image v0.2.0 // indirect
 	golang.org/x/net v0.3.0 // indirect
@@ -21,13 +21,18 @@ type Input struct {
 // This defines the ratio of physics ticks to network ticks.
 // TODO - right now I do a % NetworkTickDivider. It'd be nice to make that more systematic
 const NetworkTickDivider = 4    // The number of physics ticks before we send a network update
-const ClientInputResendRate = 2 // The number of times the client resends his input to counter packet loss
-const ClientDefaultUpdateQueueSize = 2 // TODO - make this dynamic
+const InputRedundancy = 8 // The most unacknowledged inputs that the client packs into each input message, to counter packet loss
+const ClientDefaultUpdateQueueSize = 2 // The minimum number of network ticks that the client buffers. The actual size adapts to the connection (See: netcode.JitterBuffer)
+const MaxSnapshotAge = 32 // The number of network ticks that a snapshot can be used as a delta baseline for
+const PersistInterval = 30 * time.Second // How often the server saves every logged in character
+
+// The default distance around a player that other entities must be within to be sent to that player
+// Note: This should be a bit larger than half of the largest screen dimension at the minimum zoom
+const DefaultInterestRadius float64 = 40 * 16
 
 const FixedTimeStep time.Duration =  16 * time.Millisecond
 
 
-var seed int64 = 12345
 var mapSize int = 100
 var tileSize int = 16
 
@@ -46,8 +51,19 @@ func SpawnPoint() phy2.Pos {
 }
 
 func LoadGame(world *ecs.World) *tile.Tilemap {
+	return LoadZone(world, DefaultZone)
+}
+
+// Loads the tilemap and static entities of a zone into the world
+func LoadZone(world *ecs.World, zoneId ZoneId) *tile.Tilemap {
+	zone := GetZone(zoneId)
+
 	// Create Tilemap
-	tmap := CreateTilemap(seed, mapSize, tileSize)
+	tiles := createTiles(zone.Seed, mapSize)
+	for _, portal := range zone.Portals {
+		tiles[portal.Tile.X][portal.Tile.Y].Type = PortalTile
+	}
+	tmap := tile.New(tiles, [2]int{tileSize, tileSize}, tile.FlatRectMath{})
 
 	walls := []tile.TilePosition{
 		// North wall
@@ -134,9 +150,17 @@ const (
 	DirtTile
 	WaterTile
 	ConcreteTile
+	PortalTile
 )
 
 func CreateTilemap(seed int64, mapSize, tileSize int) *tile.Tilemap {
+	tiles := createTiles(seed, mapSize)
+	tmap := tile.New(tiles, [2]int{tileSize, tileSize}, tile.FlatRectMath{})
+
+	return tmap
+}
+
+func createTiles(seed int64, mapSize int) [][]tile.Tile {
 	octaves := []pgen.Octave{
 		pgen.Octave{0.01, 0.6},
 		pgen.Octave{0.05, 0.3},
@@ -181,9 +205,7 @@ func CreateTilemap(seed int64, mapSize, tileSize int) *tile.Tilemap {
 			}
 		}
 	}
-	tmap := tile.New(tiles, [2]int{tileSize, tileSize}, tile.FlatRectMath{})
-
-	return tmap
+	return tiles
 }
 
 func MoveCharacter(input *Input, transform *phy2.Pos, collider *phy2.CircleCollider, tilemap *tile.Tilemap, dt time.Duration) {
@@ -267,20 +289,34 @@ func MoveCharacter(input *Input, transform *phy2.Pos, collider *phy2.CircleColli
 }
 
 func CheckCollisions(world *ecs.World) {
+	// Gather all colliders into a spatial hash so that we only check the colliders that are nearby
+	grid := NewCollisionGrid(float64(tileSize))
+	ecs.Map2(world, func(id ecs.Id, col *phy2.CircleCollider, cache *phy2.ColliderCache) {
+		grid.Add(id, col, cache)
+	})
+
 	// Detect all collisions
-	ecs.Map2(world, func(idA ecs.Id, colA *phy2.CircleCollider, cacheA *phy2.ColliderCache) {
-		cacheA.Clear()
-		ecs.Map2(world, func(idB ecs.Id, colB *phy2.CircleCollider, cacheB *phy2.ColliderCache) {
-			if idA == idB { return } // Skip if collider is the same entity
+	// Note: Candidates are checked in the same order that the colliders were mapped over, so that the ColliderCache gets filled in the same order as if we checked every pair
+	candidates := make([]int, 0)
+	for a := range grid.entries {
+		entryA := &grid.entries[a]
+		colA := entryA.collider
+		entryA.cache.Clear()
+
+		candidates = grid.Nearby(candidates[:0], colA)
+		for _, b := range candidates {
+			entryB := &grid.entries[b]
+			if entryA.id == entryB.id { continue } // Skip if collider is the same entity
 
-			if !colA.LayerMask(colB.Layer) { return } // Skip if layer mask doesn't match
+			colB := entryB.collider
+			if !colA.LayerMask(colB.Layer) { continue } // Skip if layer mask doesn't match
 
 			// Check if there is a collision
 			if colA.Collides(1.0, colB) {
-				cacheA.Add(idB)
+				entryA.cache.Add(entryB.id)
 			}
-		})
-	})
+		}
+	}
 
 	// // Resolve Collisions
 	// ecs.Map2(world, func(id ecs.Id, transform *phy2.Transform, collider *phy2.CircleCollider, cache *phy2.ColliderCache) {
@@ -290,12 +326,6 @@ func CheckCollisions(world *ecs.World) {
 	// })
 }
 
-func GetScheduler() *ecs.Scheduler {
-	schedule := ecs.NewScheduler()
-	schedule.SetFixedTimeStep(FixedTimeStep)
-	return schedule
-}
-
 type TileObject struct {
 }
 
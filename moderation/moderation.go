@@ -0,0 +1,71 @@
+package moderation
+
+// This package checks chat messages before the proxy forwards them to the game servers
+// A Pipeline runs a list of stages in order. Each stage can rewrite the text (ie masking words) or reject the message with a Reason, which gets returned to the sender
+
+import (
+	"time"
+
+	"github.com/unitoftime/mmo"
+)
+
+// The reason that a chat message was rejected
+// Note: This lives in mmo so that serdes can send it back to the user without depending on this package
+type Reason = mmo.ChatRejectReason
+
+const (
+	ReasonNone = mmo.RejectNone
+	ReasonEmpty = mmo.RejectEmpty
+	ReasonTooLong = mmo.RejectTooLong
+	ReasonScript = mmo.RejectScript
+	ReasonRateLimited = mmo.RejectRateLimited
+	ReasonMuted = mmo.RejectMuted
+)
+
+// A single step of the moderation pipeline. Returns the (possibly rewritten) text, or a reason other than ReasonNone to reject the message
+type Stage interface {
+	Moderate(userId uint64, text string, now time.Time) (string, Reason)
+}
+
+// Stages that track per-user state can implement this so the state gets cleaned up when the user disconnects
+type Forgetter interface {
+	Forget(userId uint64)
+}
+
+// Wraps a plain function so it can be used as a stage. The function can only rewrite the text, it never rejects
+type StageFunc func(text string) string
+
+func (f StageFunc) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	return f(text), ReasonNone
+}
+
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{
+		stages: stages,
+	}
+}
+
+// Runs the message through every stage in order, stopping at the first stage that rejects it
+func (p *Pipeline) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	for _, stage := range p.stages {
+		var reason Reason
+		text, reason = stage.Moderate(userId, text, now)
+		if reason != ReasonNone {
+			return "", reason
+		}
+	}
+	return text, ReasonNone
+}
+
+// Drops any per-user state that the stages are holding for the user
+func (p *Pipeline) Forget(userId uint64) {
+	for _, stage := range p.stages {
+		forgetter, ok := stage.(Forgetter)
+		if !ok { continue }
+		forgetter.Forget(userId)
+	}
+}
@@ -0,0 +1,109 @@
+package moderation
+
+import (
+	"os"
+	"time"
+	"testing"
+	"path/filepath"
+
+	"github.com/unitoftime/mmo"
+)
+
+func TestPipeline(t *testing.T) {
+	words := NewWordList("darn", "heck", "he\u0301ck")
+	pipeline := NewPipeline(Normalize{}, MaxLength(20), ScriptPolicy{DefaultScripts}, words)
+	now := time.Now()
+
+	tests := []struct {
+		in string
+		out string
+		reason Reason
+	}{
+		{"hello world", "hello world", ReasonNone},
+		{"  hello \t\n  world  ", "hello world", ReasonNone},
+		{"hel\u200blo\x07", "hello", ReasonNone},
+		{"ｈｅｌｌｏ", "hello", ReasonNone},
+		{"Darn it, HECK!", "**** it, ****!", ReasonNone},
+		{"darned heckle", "darned heckle", ReasonNone},
+		{"ｄａｒｎ", "****", ReasonNone},
+		{"café", "café", ReasonNone},
+		{"cafe\u0301", "café", ReasonNone},
+		{"h\u00e9ck", "****", ReasonNone},
+		{"He\u0301ck", "****", ReasonNone},
+		{"\u24d3arn", "****", ReasonNone},
+		{"ｈｅ\u0301ｃｋ", "****", ReasonNone},
+		{"\ufb01ne\u3000day", "fine day", ReasonNone},
+		{" \u200b ", "", ReasonEmpty},
+		{"this message is way too long", "", ReasonTooLong},
+		{"привет", "", ReasonScript},
+	}
+	for _, test := range tests {
+		out, reason := pipeline.Moderate(1, test.in, now)
+		if out != test.out || reason != test.reason {
+			t.Errorf("%q: Expected (%q, %v), got (%q, %v)", test.in, test.out, test.reason, out, reason)
+		}
+	}
+}
+
+// A pipeline with only the FilterChat stage must behave exactly like the old regex filter
+func TestFilterChatStage(t *testing.T) {
+	pipeline := NewPipeline(FilterChat)
+	for _, text := range []string{"Hello World", "hello world!@#$%^&*()", "☀hello", ""} {
+		out, reason := pipeline.Moderate(1, text, time.Now())
+		if reason != ReasonNone || out != mmo.FilterChat(text) {
+			t.Errorf("%q: Expected %q, got (%q, %v)", text, mmo.FilterChat(text), out, reason)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1, 2, 3, time.Minute)
+	now := time.Unix(1000, 0)
+
+	expect := func(userId uint64, now time.Time, expected Reason) {
+		t.Helper()
+		_, reason := limiter.Moderate(userId, "hi", now)
+		if reason != expected {
+			t.Errorf("Expected %v, got %v", expected, reason)
+		}
+	}
+
+	// Burst, then limited
+	expect(1, now, ReasonNone)
+	expect(1, now, ReasonNone)
+	expect(1, now, ReasonRateLimited)
+
+	// Other users have their own limit
+	expect(2, now, ReasonNone)
+
+	// Refills over time
+	now = now.Add(time.Second)
+	expect(1, now, ReasonNone)
+
+	// Repeated violations mute the user
+	expect(1, now, ReasonRateLimited)
+	expect(1, now, ReasonRateLimited)
+	expect(1, now, ReasonMuted)
+	now = now.Add(30 * time.Second)
+	expect(1, now, ReasonMuted)
+	now = now.Add(31 * time.Second)
+	expect(1, now, ReasonNone)
+
+	// Manual mutes
+	limiter.Mute(2, now.Add(time.Minute))
+	expect(2, now, ReasonMuted)
+}
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	err := os.WriteFile(path, []byte("# comment\nDarn\n\n  heck  \n"), 0644)
+	if err != nil { panic(err) }
+
+	words, err := LoadWordList(path)
+	if err != nil { panic(err) }
+
+	out, _ := words.Moderate(1, "darn heck comment", time.Now())
+	if out != "**** **** comment" {
+		t.Errorf("Unexpected masking: %q", out)
+	}
+}
@@ -0,0 +1,97 @@
+package moderation
+
+import (
+	"sync"
+	"time"
+)
+
+type userLimit struct {
+	tokens float64
+	last time.Time // The last time that tokens were refilled
+	violations int // The number of rate limited messages since the last accepted one
+	mutedUntil time.Time
+}
+
+// Limits each user to Rate messages per second, with bursts of up to Burst messages
+// Users that get rate limited MuteAfter times in a row are muted for MuteDuration
+type RateLimiter struct {
+	mu sync.Mutex
+	Rate float64
+	Burst float64
+	MuteAfter int // If 0, users are never automatically muted
+	MuteDuration time.Duration
+	users map[uint64]*userLimit
+}
+
+func NewRateLimiter(rate float64, burst int, muteAfter int, muteDuration time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Rate: rate,
+		Burst: float64(burst),
+		MuteAfter: muteAfter,
+		MuteDuration: muteDuration,
+		users: make(map[uint64]*userLimit),
+	}
+}
+
+func (l *RateLimiter) get(userId uint64, now time.Time) *userLimit {
+	user, ok := l.users[userId]
+	if !ok {
+		user = &userLimit{
+			tokens: l.Burst,
+			last: now,
+		}
+		l.users[userId] = user
+	}
+	return user
+}
+
+func (l *RateLimiter) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user := l.get(userId, now)
+	if now.Before(user.mutedUntil) {
+		return "", ReasonMuted
+	}
+
+	// Refill
+	elapsed := now.Sub(user.last).Seconds()
+	if elapsed > 0 {
+		user.tokens += elapsed * l.Rate
+		if user.tokens > l.Burst {
+			user.tokens = l.Burst
+		}
+		user.last = now
+	}
+
+	if user.tokens < 1 {
+		user.violations++
+		if l.MuteAfter > 0 && user.violations >= l.MuteAfter {
+			user.violations = 0
+			user.mutedUntil = now.Add(l.MuteDuration)
+			return "", ReasonMuted
+		}
+		return "", ReasonRateLimited
+	}
+
+	user.tokens--
+	user.violations = 0
+	return text, ReasonNone
+}
+
+// Mutes the user until the specified time
+func (l *RateLimiter) Mute(userId uint64, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.get(userId, until).mutedUntil = until
+}
+
+// Note: Muted users are remembered so that they can't reconnect to get around their mute
+func (l *RateLimiter) Forget(userId uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	user, ok := l.users[userId]
+	if !ok { return }
+	if time.Now().Before(user.mutedUntil) { return }
+	delete(l.users, userId)
+}
@@ -0,0 +1,153 @@
+package moderation
+
+import (
+	"os"
+	"time"
+	"bufio"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/unitoftime/mmo"
+)
+
+// The original regex filter, which replaces the whole message if it has any character outside of the allowed set
+// Note: Running a pipeline with only this stage reproduces the old proxy behavior
+var FilterChat = StageFunc(mmo.FilterChat)
+
+// Cleans up the text so that the later stages see what the other users will actually see
+//   - The text is NFKC normalized, so accents are composed (ie e + U+0301 becomes é) and compatibility characters are mapped to their plain form (ie fullwidth ascii, circled letters and ligatures)
+//   - Control characters and invisible formatting characters (ie zero width spaces) are removed
+//   - Runs of whitespace are collapsed into a single space, and leading and trailing whitespace is removed
+type Normalize struct{}
+
+func (Normalize) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	text = norm.NFKC.String(text)
+
+	var b strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteRune(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+
+	if b.Len() == 0 {
+		return "", ReasonEmpty
+	}
+	return b.String(), ReasonNone
+}
+
+// Rejects messages that are longer than this many characters
+type MaxLength int
+
+func (m MaxLength) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	if utf8.RuneCountInString(text) > int(m) {
+		return "", ReasonTooLong
+	}
+	return text, ReasonNone
+}
+
+// The scripts that are allowed by default: latin letters, plus the punctuation, digits and combining marks that are shared between scripts
+var DefaultScripts = []*unicode.RangeTable{unicode.Latin, unicode.Common, unicode.Inherited}
+
+// Rejects messages that have any character outside of the allowed scripts
+type ScriptPolicy struct {
+	Allowed []*unicode.RangeTable
+}
+
+func (s ScriptPolicy) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	for _, r := range text {
+		if !unicode.IsOneOf(s.Allowed, r) {
+			return "", ReasonScript
+		}
+	}
+	return text, ReasonNone
+}
+
+// Masks every word in the list with asterisks. Words are matched case insensitively and only as whole words
+type WordList struct {
+	words map[string]bool
+}
+
+func NewWordList(words ...string) *WordList {
+	w := &WordList{
+		words: make(map[string]bool),
+	}
+	for _, word := range words {
+		// Note: The words are normalized the same way as the text (See: Normalize), so that both spell accents the same way
+		w.words[strings.ToLower(norm.NFKC.String(word))] = true
+	}
+	return w
+}
+
+// Loads a word list file, which has one word per line. Blank lines and lines starting with # are skipped
+func LoadWordList(path string) (*WordList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") { continue }
+		words = append(words, line)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return NewWordList(words...), nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (w *WordList) Moderate(userId uint64, text string, now time.Time) (string, Reason) {
+	if len(w.words) == 0 {
+		return text, ReasonNone
+	}
+
+	var b strings.Builder
+	rest := text
+	for len(rest) > 0 {
+		// Copy everything up to the next word
+		start := strings.IndexFunc(rest, isWordRune)
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		b.WriteString(rest[:start])
+		rest = rest[start:]
+
+		end := strings.IndexFunc(rest, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+
+		if w.words[strings.ToLower(word)] {
+			b.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)))
+		} else {
+			b.WriteString(word)
+		}
+	}
+	return b.String(), ReasonNone
+}
@@ -0,0 +1,228 @@
+package netcode
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+)
+
+type InterpConfig struct {
+	Jitter JitterConfig // Picks how many ticks that rendering stays behind the newest snapshot
+	Capacity int // The maximum number of snapshots that are held onto
+}
+
+// Stats about the snapshot buffer, for the debug overlay
+type InterpStats struct {
+	Target int // The number of ticks that rendering stays behind the newest snapshot
+	Buffered int // The number of snapshots that haven't been played yet
+	Jitter time.Duration
+	Loss float64 // The fraction of recent snapshots that never arrived
+	Underruns int // The number of times that we ran out of snapshots to play
+}
+
+// The rate (per second) that the render clock is pulled towards the target delay
+const catchUpRate = 2.0
+
+type snapshot[T any] struct {
+	seq int64 // The unwrapped tick
+	positions map[ecs.Id]phy2.Pos
+	data T
+}
+
+// Buffers snapshots from the server by their tick, and plays them back a little bit behind the newest snapshot so that other entities can be interpolated smoothly
+//   - Snapshots are ordered by tick instead of arrival, and ticks are unwrapped so that the tick wrap doesn't matter
+//   - Missing ticks are interpolated over (ie tick 100 to 102 takes two ticks of time)
+//   - The delay grows with the measured arrival jitter and packet loss, so bad connections buffer more (See: JitterBuffer)
+type InterpBuffer[T any] struct {
+	config InterpConfig
+	sizer *JitterBuffer
+	snapshots []snapshot[T] // Sorted by seq. The first snapshot may have already been played, it is kept to interpolate from
+
+	started bool
+	newestTick uint16
+	newest int64 // The seq of the newest snapshot
+	played int64 // The seq of the last snapshot that was returned from Step
+	render float64 // The seq that is currently being rendered
+
+	starved bool // True if the render clock is waiting on the newest snapshot
+	underruns int
+}
+
+func NewInterpBuffer[T any](config InterpConfig) *InterpBuffer[T] {
+	b := &InterpBuffer[T]{
+		config: config,
+		sizer: NewJitterBuffer(config.Jitter),
+	}
+	b.Reset()
+	return b
+}
+
+// Drops everything, the next snapshot starts a new timeline (ie after connecting to a different server)
+func (b *InterpBuffer[T]) Reset() {
+	b.snapshots = make([]snapshot[T], 0, b.config.Capacity)
+	b.started = false
+	b.played = math.MinInt64
+	b.starved = false
+	b.sizer.Reset()
+}
+
+// Adds a snapshot that arrived at the specified time. Returns false if the snapshot was dropped because it arrived too late to be played
+func (b *InterpBuffer[T]) Add(tick uint16, now time.Time, positions map[ecs.Id]phy2.Pos, data T) bool {
+	if !b.started {
+		b.started = true
+		b.newestTick = tick
+		b.newest = 0
+		b.render = -b.Delay()
+	}
+
+	seq := b.newest + int64(mmo.TickDiff(tick, b.newestTick))
+	b.sizer.Arrive(seq, now)
+	if seq <= b.played {
+		return false // Too late
+	}
+
+	// Insert in order
+	i := sort.Search(len(b.snapshots), func(i int) bool { return b.snapshots[i].seq >= seq })
+	if i < len(b.snapshots) && b.snapshots[i].seq == seq {
+		return false // Duplicate
+	}
+	b.snapshots = append(b.snapshots, snapshot[T]{})
+	copy(b.snapshots[i+1:], b.snapshots[i:])
+	b.snapshots[i] = snapshot[T]{seq, positions, data}
+
+	if seq > b.newest {
+		b.newest = seq
+		b.newestTick = tick
+	}
+
+	// Drop the oldest snapshots if we are holding too many
+	if b.config.Capacity > 0 && len(b.snapshots) > b.config.Capacity {
+		drop := len(b.snapshots) - b.config.Capacity
+		if b.snapshots[drop-1].seq > b.played {
+			b.played = b.snapshots[drop-1].seq
+		}
+		b.snapshots = b.snapshots[drop:]
+	}
+	return true
+}
+
+// Returns the number of ticks that rendering currently targets being behind the newest snapshot
+func (b *InterpBuffer[T]) Delay() float64 {
+	return float64(b.sizer.Target())
+}
+
+func (b *InterpBuffer[T]) Stats() InterpStats {
+	return InterpStats{
+		Target: b.sizer.Target(),
+		Buffered: b.Len(),
+		Jitter: b.sizer.Jitter(),
+		Loss: b.sizer.Loss(),
+		Underruns: b.underruns,
+	}
+}
+
+// Returns the number of snapshots that haven't been played yet
+func (b *InterpBuffer[T]) Len() int {
+	count := 0
+	for i := range b.snapshots {
+		if b.snapshots[i].seq > b.played {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the server tick that is currently being rendered
+func (b *InterpBuffer[T]) RenderTick() uint16 {
+	ticks := int64(math.Floor(b.render)) - b.newest + int64(b.newestTick)
+	return uint16(((ticks % mmo.TickModulus) + mmo.TickModulus) % mmo.TickModulus)
+}
+
+// Advances the render clock and returns every snapshot that it passed, oldest first
+func (b *InterpBuffer[T]) Step(dt time.Duration) []T {
+	if !b.started {
+		return nil
+	}
+
+	b.sizer.Update(dt)
+
+	tickDuration := b.config.Jitter.TickDuration
+	if tickDuration > 0 {
+		b.render += dt.Seconds() / tickDuration.Seconds()
+	}
+
+	// Pull the render clock towards the target. If it is really far off (ie after a long stall) just jump there
+	target := float64(b.newest) - b.Delay()
+	diff := target - b.render
+	if math.Abs(diff) > float64(b.config.Jitter.MaxSize) {
+		b.render = target
+	} else {
+		b.render += diff * math.Min(1, dt.Seconds() * catchUpRate)
+	}
+
+	// Never render past the newest snapshot (we don't extrapolate), and never go back to snapshots that were already played
+	if b.render >= float64(b.newest) {
+		if !b.starved {
+			b.underruns++
+		}
+		b.starved = true
+		b.render = float64(b.newest)
+	} else {
+		b.starved = false
+	}
+	b.render = math.Max(b.render, float64(b.played))
+
+	ret := make([]T, 0)
+	for i := range b.snapshots {
+		s := &b.snapshots[i]
+		if float64(s.seq) > b.render { break }
+		if s.seq <= b.played { continue }
+		ret = append(ret, s.data)
+		b.played = s.seq
+	}
+
+	// Drop everything before the snapshot that we are interpolating from
+	from := b.fromIndex()
+	if from > 0 {
+		b.snapshots = b.snapshots[from:]
+	}
+	return ret
+}
+
+// Returns the index of the newest snapshot at or before the render clock
+func (b *InterpBuffer[T]) fromIndex() int {
+	i := sort.Search(len(b.snapshots), func(i int) bool { return float64(b.snapshots[i].seq) > b.render })
+	return i - 1
+}
+
+// Returns the position of the entity at the render clock, interpolated between the snapshots on either side of it
+func (b *InterpBuffer[T]) Position(id ecs.Id) (phy2.Pos, bool) {
+	from := b.fromIndex()
+	if from < 0 {
+		return phy2.Pos{}, false
+	}
+	fromPos, ok := b.snapshots[from].positions[id]
+	if !ok {
+		return phy2.Pos{}, false
+	}
+
+	to := from + 1
+	if to >= len(b.snapshots) {
+		return fromPos, true // Nothing to interpolate towards yet
+	}
+	toPos, ok := b.snapshots[to].positions[id]
+	if !ok {
+		return fromPos, true // The entity is gone in the next snapshot
+	}
+
+	alpha := (b.render - float64(b.snapshots[from].seq)) / float64(b.snapshots[to].seq - b.snapshots[from].seq)
+	return phy2.Pos{
+		fromPos.X + (toPos.X - fromPos.X) * alpha,
+		fromPos.Y + (toPos.Y - fromPos.Y) * alpha,
+	}, true
+}
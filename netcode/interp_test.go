@@ -0,0 +1,196 @@
+package netcode
+
+import (
+	"time"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+)
+
+const testTickDuration = 64 * time.Millisecond
+
+func testInterpBuffer() *InterpBuffer[uint16] {
+	return NewInterpBuffer[uint16](InterpConfig{
+		Jitter: JitterConfig{
+			TickDuration: testTickDuration,
+			MinSize: 2,
+			MaxSize: 8,
+			JitterScale: 2,
+			LossScale: 10,
+			ShrinkAfter: 5 * time.Second,
+		},
+		Capacity: 64,
+	})
+}
+
+// The entity moves 10 units to the right every tick
+func testPositions(tick int) map[ecs.Id]phy2.Pos {
+	return map[ecs.Id]phy2.Pos{
+		1: phy2.Pos{float64(tick * 10), 0},
+	}
+}
+
+type arrival struct {
+	tick uint16
+	at time.Duration // The arrival time, relative to the start
+}
+
+// Steps in 16ms frames, adding each snapshot once its arrival time has passed. Returns every tick that was played
+func simulate(b *InterpBuffer[uint16], start time.Time, arrivals []arrival, d time.Duration) []uint16 {
+	played := make([]uint16, 0)
+	next := 0
+	for elapsed := time.Duration(0); elapsed < d; elapsed += 16 * time.Millisecond {
+		for next < len(arrivals) && arrivals[next].at <= elapsed {
+			tick := arrivals[next].tick
+			b.Add(tick, start.Add(arrivals[next].at), testPositions(int(tick)), tick)
+			next++
+		}
+		played = append(played, b.Step(16 * time.Millisecond)...)
+	}
+	return played
+}
+
+func TestInterpPlaysInTickOrder(t *testing.T) {
+	b := testInterpBuffer()
+	now := time.Unix(1000, 0)
+
+	// Ticks 3 and 4 arrive swapped
+	arrivals := make([]arrival, 0)
+	for i, tick := range []uint16{1, 2, 4, 3, 5, 6} {
+		arrivals = append(arrivals, arrival{tick, time.Duration(i) * testTickDuration})
+	}
+	for tick := 7; tick <= 30; tick++ {
+		arrivals = append(arrivals, arrival{uint16(tick), time.Duration(tick - 1) * testTickDuration})
+	}
+
+	// Ticks are played in order, and it stays about Delay ticks behind the newest tick
+	played := simulate(b, now, arrivals, 30 * testTickDuration)
+	if len(played) < 20 {
+		t.Fatalf("Expected at least 20 ticks to be played, got %v", played)
+	}
+	for i := range played {
+		if played[i] != uint16(i + 1) {
+			t.Fatalf("Expected ticks to be played in order, got %v", played)
+		}
+	}
+	lag := float64(b.newest) - b.render
+	if lag < b.Delay() - 1 || lag > b.Delay() + 1 {
+		t.Errorf("Expected render clock to be %f ticks behind, got %f", b.Delay(), lag)
+	}
+
+	// Both of the swapped ticks were received, so that isn't loss
+	if b.Stats().Loss != 0 {
+		t.Errorf("Expected no loss, got %f", b.Stats().Loss)
+	}
+
+	// Late snapshots are dropped
+	if b.Add(2, now, testPositions(2), 2) {
+		t.Errorf("Expected late snapshot to be dropped")
+	}
+}
+
+func TestInterpAcrossGapsAndWrap(t *testing.T) {
+	b := testInterpBuffer()
+	now := time.Unix(1000, 0)
+
+	// Tick 0 is missing, and the ticks wrap right before it
+	start := mmo.TickModulus - 3
+	for i := 0; i < 7; i++ {
+		if i == 3 { continue }
+		tick := uint16((start + i) % mmo.TickModulus)
+		b.Add(tick, now.Add(time.Duration(i) * testTickDuration), testPositions(i), tick)
+	}
+
+	// Render halfway between the ticks on either side of the missing tick
+	b.render = 2.5
+	pos, ok := b.Position(1)
+	if !ok || !near(pos, phy2.Pos{25, 0}) {
+		t.Errorf("Expected to interpolate across the gap, got %v", pos)
+	}
+	if b.RenderTick() != mmo.TickModulus - 1 {
+		t.Errorf("Unexpected render tick: %d", b.RenderTick())
+	}
+
+	b.render = 3.5
+	pos, ok = b.Position(1)
+	if !ok || !near(pos, phy2.Pos{35, 0}) {
+		t.Errorf("Expected to interpolate across the wrap, got %v", pos)
+	}
+	if b.RenderTick() != 0 {
+		t.Errorf("Expected render tick to wrap to 0, got %d", b.RenderTick())
+	}
+
+	// Unknown entities
+	_, ok = b.Position(2)
+	if ok {
+		t.Errorf("Expected unknown entity to have no position")
+	}
+}
+
+func TestInterpDelayAdaptsToJitter(t *testing.T) {
+	steady := testInterpBuffer()
+	jittery := testInterpBuffer()
+	now := time.Unix(1000, 0)
+
+	steadyArrivals := make([]arrival, 0)
+	jitteryArrivals := make([]arrival, 0)
+	for tick := 0; tick < 100; tick++ {
+		at := time.Duration(tick) * testTickDuration
+		steadyArrivals = append(steadyArrivals, arrival{uint16(tick), at})
+
+		// Every other snapshot is 40ms late
+		if tick % 2 == 0 {
+			at += 40 * time.Millisecond
+		}
+		jitteryArrivals = append(jitteryArrivals, arrival{uint16(tick), at})
+	}
+	simulate(steady, now, steadyArrivals, 100 * testTickDuration)
+	simulate(jittery, now, jitteryArrivals, 100 * testTickDuration)
+
+	if steady.Delay() != 2 || steady.Stats().Jitter != 0 {
+		t.Errorf("Expected steady connection to use the min delay, got %f (%v)", steady.Delay(), steady.Stats().Jitter)
+	}
+	if jittery.Delay() <= 2 || jittery.Delay() > 8 {
+		t.Errorf("Expected jittery connection to increase the delay, got %f (%v)", jittery.Delay(), jittery.Stats().Jitter)
+	}
+
+	// The render clock settles at about the target delay behind the newest tick
+	for _, b := range []*InterpBuffer[uint16]{steady, jittery} {
+		lag := float64(b.newest) - b.render
+		if lag < b.Delay() - 1 || lag > b.Delay() + 1 {
+			t.Errorf("Expected render clock to be %f ticks behind, got %f", b.Delay(), lag)
+		}
+	}
+}
+
+func TestInterpStarvation(t *testing.T) {
+	b := testInterpBuffer()
+	now := time.Unix(1000, 0)
+	arrivals := make([]arrival, 0)
+	for tick := 0; tick < 4; tick++ {
+		arrivals = append(arrivals, arrival{uint16(tick), time.Duration(tick) * testTickDuration})
+	}
+
+	// Nothing else arrives, so we hold on the newest snapshot instead of extrapolating
+	played := simulate(b, now, arrivals, 2 * time.Second)
+	if len(played) != 4 || b.RenderTick() != 3 {
+		t.Errorf("Expected to play every tick and hold on the newest, got %v at %d", played, b.RenderTick())
+	}
+	pos, _ := b.Position(1)
+	if !near(pos, phy2.Pos{30, 0}) {
+		t.Errorf("Expected to hold at the newest position, got %v", pos)
+	}
+
+	// After a reset, the next snapshot starts a new timeline
+	b.Reset()
+	if b.Step(time.Second) != nil || b.Len() != 0 {
+		t.Errorf("Expected reset buffer to be empty")
+	}
+	b.Add(5000, now, testPositions(0), 5000)
+	if b.Len() != 1 {
+		t.Errorf("Expected snapshot to be added after reset")
+	}
+}
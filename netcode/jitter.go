@@ -0,0 +1,146 @@
+package netcode
+
+import (
+	"math"
+	"time"
+)
+
+// The number of recent ticks that packet loss is measured over
+const lossWindow = 64
+
+type JitterConfig struct {
+	TickDuration time.Duration // The time between server ticks
+	MinSize int // The smallest number of ticks to buffer
+	MaxSize int // The largest number of ticks to buffer
+	JitterScale float64 // The buffer grows by this many ticks for every tick of measured jitter
+	LossScale float64 // The buffer grows by this many ticks at 100% packet loss (ie 10 adds a tick for every 10% loss)
+	ShrinkAfter time.Duration // The connection must be good for this long before the buffer shrinks
+}
+
+// Picks the number of ticks to buffer based on the jitter and packet loss of the connection
+// Note: The size grows as soon as the connection gets worse, but only shrinks after the connection has been better for a while, so that it doesn't flap back and forth
+type JitterBuffer struct {
+	config JitterConfig
+	target int
+
+	started bool
+	epoch time.Time // The arrival time of the first tick
+	lastTransit time.Duration
+	jitter time.Duration
+
+	highest int64 // The highest tick that has arrived
+	window [lossWindow]int64 // The ticks that have arrived, indexed by tick % lossWindow
+	first int64 // The first tick that arrived, we don't count loss from before it
+
+	better time.Duration // How long the connection has been good enough to shrink the buffer
+}
+
+func NewJitterBuffer(config JitterConfig) *JitterBuffer {
+	j := &JitterBuffer{
+		config: config,
+	}
+	j.Reset()
+	return j
+}
+
+func (j *JitterBuffer) Reset() {
+	j.target = j.config.MinSize
+	j.started = false
+	j.jitter = 0
+	j.better = 0
+	for i := range j.window {
+		j.window[i] = -1
+	}
+}
+
+// Records that the tick arrived at the specified time. Ticks must already be unwrapped (see InterpBuffer)
+func (j *JitterBuffer) Arrive(seq int64, now time.Time) {
+	if !j.started {
+		j.started = true
+		j.epoch = now.Add(-time.Duration(seq) * j.config.TickDuration)
+		j.lastTransit = 0
+		j.highest = seq
+		j.first = seq
+	}
+
+	// Measure the jitter as a running average of how much the transit time changes between ticks (See: RFC 3550)
+	transit := now.Sub(j.epoch) - time.Duration(seq) * j.config.TickDuration
+	d := transit - j.lastTransit
+	if d < 0 {
+		d = -d
+	}
+	j.jitter += (d - j.jitter) / 16
+	j.lastTransit = transit
+
+	if seq > j.highest {
+		j.highest = seq
+	}
+	if seq >= j.first && seq > j.highest - lossWindow {
+		j.window[((seq % lossWindow) + lossWindow) % lossWindow] = seq
+	}
+
+	// Grow right away, because an empty buffer means that everything freezes
+	// Note: This grows once we want half a tick more than the target, and only shrinks once we have wanted half a tick less for ShrinkAfter, so that a connection that sits on the edge doesn't flap back and forth
+	desired := int(math.Round(j.desired()))
+	if desired > j.target {
+		j.target = desired
+		j.better = 0
+	}
+}
+
+// Advances the shrink timer
+func (j *JitterBuffer) Update(dt time.Duration) {
+	if j.desired() < float64(j.target) - 0.5 {
+		j.better += dt
+	} else {
+		j.better = 0
+	}
+
+	if j.better >= j.config.ShrinkAfter && j.target > j.config.MinSize {
+		j.target--
+		j.better = 0
+	}
+}
+
+// Returns the (unrounded) number of ticks that the current connection quality needs
+func (j *JitterBuffer) desired() float64 {
+	desired := float64(j.config.MinSize) + (j.config.LossScale * j.Loss())
+	if j.config.TickDuration > 0 {
+		desired += j.config.JitterScale * j.jitter.Seconds() / j.config.TickDuration.Seconds()
+	}
+	return math.Max(float64(j.config.MinSize), math.Min(desired, float64(j.config.MaxSize)))
+}
+
+// Returns the number of ticks that should be buffered
+func (j *JitterBuffer) Target() int {
+	return j.target
+}
+
+// Returns the measured arrival jitter
+func (j *JitterBuffer) Jitter() time.Duration {
+	return j.jitter
+}
+
+// Returns the fraction of the recent ticks that never arrived
+// Note: Ticks only count as lost once they are too late to be played, otherwise every reordered tick would look like loss
+func (j *JitterBuffer) Loss() float64 {
+	if !j.started {
+		return 0
+	}
+	last := j.highest - int64(j.target) // The newest tick that we can say is lost
+	expected := last - j.first + 1
+	if expected > lossWindow - int64(j.target) {
+		expected = lossWindow - int64(j.target)
+	}
+	if expected <= 0 {
+		return 0
+	}
+
+	received := int64(0)
+	for _, seq := range j.window {
+		if seq > last - expected && seq <= last {
+			received++
+		}
+	}
+	return 1 - (float64(received) / float64(expected))
+}
@@ -0,0 +1,180 @@
+package netcode
+
+import (
+	"time"
+	"testing"
+	"math/rand"
+)
+
+// A simulated connection, every tick is sent on time and arrives after Latency plus a random amount of jitter, or not at all
+type testNetwork struct {
+	Latency time.Duration
+	Jitter time.Duration // Each tick is delayed by a random amount up to this
+	Loss float64 // The chance of dropping a tick
+}
+
+// Returns the arrivals of every tick in [start, end) over the network, sorted by arrival time
+func (n testNetwork) arrivals(rng *rand.Rand, start, end int) []arrival {
+	ret := make([]arrival, 0)
+	for tick := start; tick < end; tick++ {
+		if rng.Float64() < n.Loss { continue }
+
+		at := (time.Duration(tick) * testTickDuration) + n.Latency
+		if n.Jitter > 0 {
+			at += time.Duration(rng.Int63n(int64(n.Jitter)))
+		}
+		ret = append(ret, arrival{uint16(tick), at})
+	}
+
+	// Insertion sort, the arrivals are mostly in order already
+	for i := 1; i < len(ret); i++ {
+		for j := i; j > 0 && ret[j].at < ret[j-1].at; j-- {
+			ret[j], ret[j-1] = ret[j-1], ret[j]
+		}
+	}
+	return ret
+}
+
+func TestJitterGoodNetwork(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	b := testInterpBuffer()
+
+	network := testNetwork{Latency: 50 * time.Millisecond, Jitter: 2 * time.Millisecond}
+	played := simulate(b, time.Unix(1000, 0), network.arrivals(rng, 0, 500), 500 * testTickDuration)
+
+	stats := b.Stats()
+	if stats.Target != 2 {
+		t.Errorf("Expected a good network to use the min size, got %v", stats)
+	}
+	if stats.Loss != 0 || stats.Underruns > 1 {
+		t.Errorf("Expected no loss or underruns, got %v", stats)
+	}
+	if len(played) < 490 {
+		t.Errorf("Expected nearly every tick to be played, got %d", len(played))
+	}
+}
+
+func TestJitterBadNetwork(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	b := testInterpBuffer()
+	now := time.Unix(1000, 0)
+
+	// Let the buffer adapt, then count the underruns after that
+	network := testNetwork{Latency: 50 * time.Millisecond, Jitter: 150 * time.Millisecond, Loss: 0.05}
+	arrivals := network.arrivals(rng, 0, 1000)
+	simulate(b, now, arrivals[:200], arrivals[200].at)
+	adapted := b.Stats()
+	if adapted.Target <= 2 {
+		t.Errorf("Expected a bad network to grow the buffer, got %v", adapted)
+	}
+
+	// Note: simulate works in relative time, so keep stepping from where we left off
+	played := make([]uint16, 0)
+	next := 200
+	for elapsed := arrivals[200].at; elapsed < 1000 * testTickDuration; elapsed += 16 * time.Millisecond {
+		for next < len(arrivals) && arrivals[next].at <= elapsed {
+			tick := arrivals[next].tick
+			b.Add(tick, now.Add(arrivals[next].at), testPositions(int(tick)), tick)
+			next++
+		}
+		played = append(played, b.Step(16 * time.Millisecond)...)
+	}
+
+	stats := b.Stats()
+	if stats.Underruns - adapted.Underruns > 5 {
+		t.Errorf("Expected few underruns after adapting, got %d (%v)", stats.Underruns - adapted.Underruns, stats)
+	}
+	if stats.Loss <= 0 || stats.Loss > 0.2 {
+		t.Errorf("Expected to measure about 5%% loss, got %v", stats)
+	}
+	for i := 1; i < len(played); i++ {
+		if played[i] <= played[i-1] {
+			t.Fatalf("Expected ticks to be played in order, got %d after %d", played[i], played[i-1])
+		}
+	}
+}
+
+func TestJitterHysteresis(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	j := NewJitterBuffer(testInterpBuffer().config.Jitter)
+	start := time.Unix(1000, 0)
+
+	bad := testNetwork{Latency: 50 * time.Millisecond, Jitter: 150 * time.Millisecond}
+	good := testNetwork{Latency: 50 * time.Millisecond}
+
+	// Feeds arrivals to the sizer, stepping it every 16ms. Returns the smallest and largest target that it saw
+	feed := func(arrivals []arrival, from, to time.Duration) (int, int) {
+		min, max := j.Target(), j.Target()
+		next := 0
+		for elapsed := from; elapsed < to; elapsed += 16 * time.Millisecond {
+			for next < len(arrivals) && arrivals[next].at <= elapsed {
+				j.Arrive(int64(arrivals[next].tick), start.Add(arrivals[next].at))
+				next++
+			}
+			j.Update(16 * time.Millisecond)
+			if j.Target() < min { min = j.Target() }
+			if j.Target() > max { max = j.Target() }
+		}
+		return min, max
+	}
+
+	feed(bad.arrivals(rng, 0, 200), 0, 200 * testTickDuration)
+	grown := j.Target()
+	if grown <= 2 {
+		t.Fatalf("Expected the bad network to grow the target, got %d", grown)
+	}
+
+	// The network gets better, but it doesn't shrink until it has been better for ShrinkAfter
+	from := 200 * testTickDuration
+	min, max := feed(good.arrivals(rng, 200, 250), from, 250 * testTickDuration)
+	if min != grown || max != grown {
+		t.Errorf("Expected the target to hold at %d right after the network got better, got %d to %d", grown, min, max)
+	}
+
+	// Then it shrinks one tick at a time, and never grows back while the network is good
+	from = 250 * testTickDuration
+	last := j.Target()
+	next := 0
+	arrivals := good.arrivals(rng, 250, 1000)
+	for elapsed := from; elapsed < 1000 * testTickDuration; elapsed += 16 * time.Millisecond {
+		for next < len(arrivals) && arrivals[next].at <= elapsed {
+			j.Arrive(int64(arrivals[next].tick), start.Add(arrivals[next].at))
+			next++
+		}
+		j.Update(16 * time.Millisecond)
+		if j.Target() > last || j.Target() < last - 1 {
+			t.Fatalf("Expected the target to shrink one tick at a time, went from %d to %d", last, j.Target())
+		}
+		last = j.Target()
+	}
+	if j.Target() != 2 {
+		t.Errorf("Expected the target to shrink back to the min size, got %d", j.Target())
+	}
+}
+
+func TestJitterLoss(t *testing.T) {
+	j := NewJitterBuffer(testInterpBuffer().config.Jitter)
+	start := time.Unix(1000, 0)
+
+	// Every 4th tick is dropped
+	for tick := 0; tick < 200; tick++ {
+		if tick % 4 == 3 { continue }
+		j.Arrive(int64(tick), start.Add(time.Duration(tick) * testTickDuration))
+	}
+
+	loss := j.Loss()
+	if loss < 0.2 || loss > 0.3 {
+		t.Errorf("Expected about 25%% loss, got %f", loss)
+	}
+	if j.Jitter() != 0 {
+		t.Errorf("Expected no jitter, got %v", j.Jitter())
+	}
+	if j.Target() <= 2 {
+		t.Errorf("Expected loss to grow the target, got %d", j.Target())
+	}
+
+	j.Reset()
+	if j.Loss() != 0 || j.Target() != 2 {
+		t.Errorf("Expected reset to clear everything, got %f and %d", j.Loss(), j.Target())
+	}
+}
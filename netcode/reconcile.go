@@ -0,0 +1,186 @@
+package netcode
+
+// This package holds the client side networking logic (prediction, reconciliation, interpolation) so that it can be tested without a window
+
+import (
+	"math"
+	"time"
+
+	"github.com/unitoftime/flow/ds"
+	"github.com/unitoftime/flow/phy2"
+)
+
+// The number of player ticks of predictions that are remembered. This must be larger than the number of unacknowledged inputs
+const predictionHistory = 128
+
+type ReconcileConfig struct {
+	SnapThreshold float64 // Errors larger than this teleport the player instead of smoothing
+	SmoothDuration time.Duration // How long a correction is spread out over
+	TickDuration time.Duration // The time between predicted positions (ie the time between inputs)
+}
+
+type prediction struct {
+	tick uint16
+	pos phy2.Pos
+	valid bool
+}
+
+// Prediction error stats over the recent reconciliations
+type ReconcileStats struct {
+	Count int // The total number of reconciliations
+	Snaps int // The total number of corrections that were too large to smooth
+	Last float64 // The most recent error
+	Avg float64 // The average error over the recent reconciliations
+	Max float64 // The largest error over the recent reconciliations
+}
+
+// Corrects the locally predicted position of the player when the server disagrees with it
+//   - Each predicted position is remembered by the player tick of the input that produced it
+//   - When the server acknowledges a player tick, the server's position is compared to the prediction for that tick
+//   - Small errors are smoothed out over SmoothDuration, large errors snap straight to the new prediction
+type Reconciler struct {
+	config ReconcileConfig
+	predictions [predictionHistory]prediction
+
+	from, to phy2.Pos // The rendered position moves from the last prediction to the newest prediction over TickDuration
+	elapsed time.Duration
+	offset phy2.Pos // The correction that is still being smoothed out
+	remaining time.Duration // The time left to smooth out the offset
+	started bool
+
+	count, snaps int
+	errors *ds.RingBuffer[float64]
+}
+
+func NewReconciler(config ReconcileConfig) *Reconciler {
+	return &Reconciler{
+		config: config,
+		errors: ds.NewRingBuffer[float64](100), // TODO - configurable
+	}
+}
+
+// Remembers the predicted position after the input for the player tick was applied
+func (r *Reconciler) Predict(tick uint16, pos phy2.Pos) {
+	r.predictions[int(tick) % predictionHistory] = prediction{tick, pos, true}
+}
+
+// Returns the predicted position for the player tick, if it is still remembered
+func (r *Reconciler) Prediction(tick uint16) (phy2.Pos, bool) {
+	p := r.predictions[int(tick) % predictionHistory]
+	if !p.valid || p.tick != tick {
+		return phy2.Pos{}, false
+	}
+	return p.pos, true
+}
+
+// Resets everything, the next reconciliation snaps to the server (ie after logging in)
+func (r *Reconciler) Clear() {
+	r.predictions = [predictionHistory]prediction{}
+	r.offset = phy2.Pos{}
+	r.remaining = 0
+	r.started = false
+}
+
+// Reconciles the prediction with the server
+//   ackTick: The last player tick that the server applied
+//   serverPos: The server's position for the player after applying ackTick
+//   predicted: The new prediction, after reapplying every input that the server hasn't applied yet on top of serverPos
+// Returns the prediction error (the distance between the server's position and what we predicted for ackTick)
+func (r *Reconciler) Reconcile(ackTick uint16, serverPos, predicted phy2.Pos) float64 {
+	current := r.Position()
+
+	if !r.started {
+		r.snap(predicted)
+		return 0
+	}
+
+	// Note: If we never predicted the tick (ie the server acked an input before we ever re-simulated it), we can't measure the error, so just move on to the new prediction
+	diff := phy2.Pos{}
+	old, ok := r.Prediction(ackTick)
+	if !ok {
+		r.retarget(current, diff, predicted)
+		return 0
+	}
+
+	diff = serverPos.Sub(old)
+	dist := diff.Len()
+	r.count++
+	r.errors.Add(dist)
+
+	if dist > r.config.SnapThreshold {
+		r.snaps++
+		r.snap(predicted)
+		return dist
+	}
+
+	r.retarget(current, diff, predicted)
+	return dist
+}
+
+// The whole predicted path was off by diff, so shift our starting point by it and smooth out the difference between that and what is currently rendered
+func (r *Reconciler) retarget(current, diff, predicted phy2.Pos) {
+	r.from = r.to.Add(diff)
+	r.to = predicted
+	r.elapsed = 0
+	r.offset = current.Sub(r.from)
+	r.remaining = r.config.SmoothDuration
+}
+
+func (r *Reconciler) snap(pos phy2.Pos) {
+	r.from = pos
+	r.to = pos
+	r.elapsed = 0
+	r.offset = phy2.Pos{}
+	r.remaining = 0
+	r.started = true
+}
+
+// Advances the rendered position by dt and returns it
+func (r *Reconciler) Step(dt time.Duration) phy2.Pos {
+	r.elapsed += dt
+	r.remaining -= dt
+	if r.remaining < 0 {
+		r.remaining = 0
+	}
+	return r.Position()
+}
+
+// Returns the position that the player should be rendered at
+func (r *Reconciler) Position() phy2.Pos {
+	progress := 1.0
+	if r.config.TickDuration > 0 {
+		progress = math.Min(1, r.elapsed.Seconds() / r.config.TickDuration.Seconds())
+	}
+	pos := r.from.Add(r.to.Sub(r.from).Scaled(progress))
+
+	if r.remaining > 0 && r.config.SmoothDuration > 0 {
+		pos = pos.Add(r.offset.Scaled(r.remaining.Seconds() / r.config.SmoothDuration.Seconds()))
+	}
+	return pos
+}
+
+// Returns the prediction error stats
+func (r *Reconciler) Stats() ReconcileStats {
+	stats := ReconcileStats{
+		Count: r.count,
+		Snaps: r.snaps,
+	}
+	errors := r.errors.Buffer()
+	if r.count == 0 || len(errors) == 0 {
+		return stats
+	}
+	// Note: The ring buffer starts out filled with zeros, so only average over the samples we actually have
+	n := len(errors)
+	if r.count < n {
+		n = r.count
+	}
+	recent := errors[len(errors)-n:]
+	stats.Last = recent[len(recent)-1]
+	sum := 0.0
+	for _, e := range recent {
+		sum += e
+		stats.Max = math.Max(stats.Max, e)
+	}
+	stats.Avg = sum / float64(n)
+	return stats
+}
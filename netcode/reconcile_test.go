@@ -0,0 +1,141 @@
+package netcode
+
+import (
+	"math"
+	"time"
+	"testing"
+
+	"github.com/unitoftime/flow/phy2"
+)
+
+func near(a, b phy2.Pos) bool {
+	return math.Abs(a.X - b.X) < 1e-9 && math.Abs(a.Y - b.Y) < 1e-9
+}
+
+func testReconciler() *Reconciler {
+	return NewReconciler(ReconcileConfig{
+		SnapThreshold: 50,
+		SmoothDuration: 200 * time.Millisecond,
+		TickDuration: 64 * time.Millisecond,
+	})
+}
+
+// Predicts the player moving 10 units to the right every tick, starting at x
+func predictPath(r *Reconciler, firstTick uint16, x float64, ticks int) phy2.Pos {
+	pos := phy2.Pos{x, 0}
+	for i := 0; i < ticks; i++ {
+		pos.X += 10
+		r.Predict(firstTick + uint16(i), pos)
+	}
+	return pos
+}
+
+func TestReconcileCorrectPrediction(t *testing.T) {
+	r := testReconciler()
+
+	// The first reconciliation has nothing to compare against, so it snaps
+	predicted := predictPath(r, 1, 0, 3)
+	r.Reconcile(0, phy2.Pos{0, 0}, predicted)
+	if !near(r.Position(), phy2.Pos{30, 0}) {
+		t.Fatalf("Expected snap to prediction, got %v", r.Position())
+	}
+
+	// The server agrees with what we predicted for tick 1, so there is nothing to correct and the player keeps moving smoothly
+	r.Step(64 * time.Millisecond)
+	predicted = predictPath(r, 4, 30, 1)
+	before := r.Position()
+	err := r.Reconcile(1, phy2.Pos{10, 0}, predicted)
+	if err != 0 {
+		t.Errorf("Expected no error, got %f", err)
+	}
+	if !near(r.Position(), before) {
+		t.Errorf("Expected no jump: %v -> %v", before, r.Position())
+	}
+	r.Step(32 * time.Millisecond)
+	if !near(r.Position(), phy2.Pos{35, 0}) {
+		t.Errorf("Expected to be halfway to the next prediction, got %v", r.Position())
+	}
+}
+
+func TestReconcileSmoothsSmallErrors(t *testing.T) {
+	r := testReconciler()
+	predicted := predictPath(r, 1, 0, 3)
+	r.Reconcile(0, phy2.Pos{0, 0}, predicted)
+	r.Step(64 * time.Millisecond)
+
+	// The server says we only got to x=6 on tick 1 (we predicted 10), so every prediction after it is 4 units too far
+	predicted = predictPath(r, 4, 26, 1)
+	before := r.Position()
+	err := r.Reconcile(1, phy2.Pos{6, 0}, predicted)
+	if math.Abs(err - 4) > 1e-9 {
+		t.Errorf("Expected error of 4, got %f", err)
+	}
+	if !near(r.Position(), before) {
+		t.Errorf("Expected the correction to be smoothed, not snapped: %v -> %v", before, r.Position())
+	}
+
+	// Halfway through the smoothing, half of the correction has been applied
+	r.Step(100 * time.Millisecond)
+	if !near(r.Position(), phy2.Pos{38, 0}) {
+		t.Errorf("Expected half of the correction to be applied, got %v", r.Position())
+	}
+
+	// After smoothing finishes, we are exactly on the new prediction
+	r.Step(100 * time.Millisecond)
+	if !near(r.Position(), predicted) {
+		t.Errorf("Expected to end on the prediction %v, got %v", predicted, r.Position())
+	}
+}
+
+func TestReconcileSnapsLargeErrors(t *testing.T) {
+	r := testReconciler()
+	predicted := predictPath(r, 1, 0, 3)
+	r.Reconcile(0, phy2.Pos{0, 0}, predicted)
+	r.Step(64 * time.Millisecond)
+
+	// Teleported by the server
+	predicted = phy2.Pos{500, 0}
+	err := r.Reconcile(1, phy2.Pos{480, 0}, predicted)
+	if math.Abs(err - 470) > 1e-9 {
+		t.Errorf("Expected error of 470, got %f", err)
+	}
+	if !near(r.Position(), predicted) {
+		t.Errorf("Expected snap to %v, got %v", predicted, r.Position())
+	}
+
+	stats := r.Stats()
+	if stats.Count != 1 || stats.Snaps != 1 || stats.Last != 470 || stats.Max != 470 || stats.Avg != 470 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestReconcileStats(t *testing.T) {
+	r := testReconciler()
+	if r.Stats() != (ReconcileStats{}) {
+		t.Errorf("Expected empty stats, got %+v", r.Stats())
+	}
+
+	r.Reconcile(0, phy2.Pos{}, phy2.Pos{})
+	for tick := uint16(1); tick <= 4; tick++ {
+		r.Predict(tick, phy2.Pos{})
+		r.Reconcile(tick, phy2.Pos{float64(tick), 0}, phy2.Pos{})
+	}
+	stats := r.Stats()
+	if stats.Count != 4 || stats.Snaps != 0 || stats.Last != 4 || stats.Max != 4 || stats.Avg != 2.5 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Ticks that were never predicted can't be measured, so they are smoothed without counting as an error
+	before := r.Position()
+	r.Reconcile(100, phy2.Pos{50, 0}, phy2.Pos{10, 0})
+	if r.Stats().Count != 4 || !near(r.Position(), before) {
+		t.Errorf("Expected unpredicted tick to be smoothed: %+v %v", r.Stats(), r.Position())
+	}
+
+	// Forgotten predictions (ie after a zone change) snap without counting as an error
+	r.Clear()
+	r.Reconcile(5, phy2.Pos{100, 0}, phy2.Pos{100, 0})
+	if r.Stats().Count != 4 || !near(r.Position(), phy2.Pos{100, 0}) {
+		t.Errorf("Expected clear to snap: %+v %v", r.Stats(), r.Position())
+	}
+}
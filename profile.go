@@ -0,0 +1,269 @@
+package mmo
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/ds"
+
+	"github.com/unitoftime/mmo/stat"
+)
+
+// The number of samples that the rolling percentiles are calculated over
+const profileWindow = 256 // TODO - configurable
+
+// How often an overrun gets logged, so that a server that is falling behind doesn't spam the logs every tick
+const overrunLogInterval = time.Second
+
+// Wraps the ecs scheduler so that every system that is appended to it gets profiled (See: Profiler)
+type Scheduler struct {
+	*ecs.Scheduler
+	Profiler *Profiler
+}
+
+func GetScheduler() *Scheduler {
+	schedule := ecs.NewScheduler()
+	schedule.SetFixedTimeStep(FixedTimeStep)
+	return &Scheduler{
+		Scheduler: schedule,
+		Profiler: NewProfiler(FixedTimeStep),
+	}
+}
+
+func (s *Scheduler) AppendInput(systems ...ecs.System) {
+	s.Scheduler.AppendInput(s.Profiler.wrap(systems, false)...)
+}
+
+func (s *Scheduler) AppendPhysics(systems ...ecs.System) {
+	s.Scheduler.AppendPhysics(s.Profiler.wrap(systems, true)...)
+}
+
+func (s *Scheduler) AppendRender(systems ...ecs.System) {
+	s.Scheduler.AppendRender(s.Profiler.wrap(systems, false)...)
+}
+
+// Note: This must be called between ticks (ie from a system), because the scheduler isn't threadsafe
+func (s *Scheduler) SetFixedTimeStep(step time.Duration) {
+	s.Scheduler.SetFixedTimeStep(step)
+	s.Profiler.setStep(step)
+}
+
+// The rolling stats of a single system, or of the whole physics tick
+type ProfileStats struct {
+	Name string
+	Last, P50, P95, P99, Max time.Duration
+}
+
+// The rolling stats of every system, in the order that they were appended
+type ProfileReport struct {
+	Tick ProfileStats // The time that all of the physics systems took to run, which has to fit in the fixed time step
+	Systems []ProfileStats
+	Ticks int
+	Overruns int // The number of ticks that took longer than the fixed time step
+}
+
+// Returns the system that has the slowest p99
+func (r ProfileReport) Slowest() (ProfileStats, bool) {
+	if len(r.Systems) == 0 {
+		return ProfileStats{}, false
+	}
+	slowest := r.Systems[0]
+	for _, s := range r.Systems[1:] {
+		if s.P99 > slowest.P99 {
+			slowest = s
+		}
+	}
+	return slowest, true
+}
+
+type profileSamples struct {
+	name string
+	times *ds.RingBuffer[time.Duration]
+	count int
+	histogram *stat.Histogram // Nil for the tick, which isn't a system
+}
+
+func newProfileSamples(name string) *profileSamples {
+	return &profileSamples{
+		name: name,
+		times: ds.NewRingBuffer[time.Duration](profileWindow),
+	}
+}
+
+func (s *profileSamples) add(t time.Duration) {
+	s.times.Add(t)
+	s.count++
+}
+
+func (s *profileSamples) stats() ProfileStats {
+	stats := ProfileStats{Name: s.name}
+	times := s.times.Buffer()
+	// Note: The ring buffer starts out filled with zeros, so only look at the samples we actually have
+	n := len(times)
+	if s.count < n {
+		n = s.count
+	}
+	if n == 0 {
+		return stats
+	}
+	recent := times[len(times)-n:]
+	stats.Last = recent[len(recent)-1]
+
+	sorted := make([]time.Duration, n)
+	copy(sorted, recent)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p * float64(n-1))]
+	}
+	stats.P50 = percentile(0.50)
+	stats.P95 = percentile(0.95)
+	stats.P99 = percentile(0.99)
+	stats.Max = sorted[n-1]
+	return stats
+}
+
+// Records how long each system takes to run and finds the physics ticks that take longer than the fixed time step. When that happens the server can't keep up, and falls further behind every tick
+// Note: Every system is also recorded in the stat.SystemDuration metric
+type Profiler struct {
+	mu sync.Mutex
+	step time.Duration
+	systems []*profileSamples
+	tick *profileSamples
+
+	physicsCount int // The number of physics systems, the last one ends the tick
+	tickTime time.Duration // The time that the physics systems have taken so far this tick
+	slowest *profileSamples // The slowest physics system this tick
+	slowestTime time.Duration
+
+	ticks, overruns int
+	missed int // Overruns that haven't been logged yet
+	lastOverrunLog time.Time
+}
+
+func NewProfiler(step time.Duration) *Profiler {
+	return &Profiler{
+		step: step,
+		systems: make([]*profileSamples, 0),
+		tick: newProfileSamples("Tick"),
+	}
+}
+
+func (p *Profiler) setStep(step time.Duration) {
+	p.mu.Lock()
+	p.step = step
+	p.mu.Unlock()
+}
+
+// Wraps each system so that it gets timed. The physics systems are also added up into the tick
+func (p *Profiler) wrap(systems []ecs.System, physics bool) []ecs.System {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ret := make([]ecs.System, len(systems))
+	for i := range systems {
+		sys := systems[i]
+		samples := newProfileSamples(sys.Name)
+		samples.histogram = stat.SystemDuration.With(sys.Name)
+		p.systems = append(p.systems, samples)
+
+		index := -1
+		if physics {
+			index = p.physicsCount
+			p.physicsCount++
+		}
+
+		ret[i] = ecs.System{sys.Name, func(dt time.Duration) {
+			start := time.Now()
+			sys.Func(dt)
+			p.record(samples, index, time.Since(start))
+		}}
+	}
+	return ret
+}
+
+func (p *Profiler) record(samples *profileSamples, physicsIndex int, t time.Duration) {
+	samples.histogram.Observe(t.Seconds())
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	samples.add(t)
+
+	if physicsIndex < 0 { return } // Only the physics systems run on the fixed time step
+
+	p.tickTime += t
+	if t > p.slowestTime {
+		p.slowest = samples
+		p.slowestTime = t
+	}
+	if physicsIndex == p.physicsCount - 1 {
+		p.endTick()
+	}
+}
+
+func (p *Profiler) endTick() {
+	p.tick.add(p.tickTime)
+	p.ticks++
+
+	if p.tickTime > p.step {
+		p.overruns++
+		p.missed++
+		if time.Since(p.lastOverrunLog) >= overrunLogInterval {
+			log.Warn().
+				Dur("Tick", p.tickTime).
+				Dur("Step", p.step).
+				Str("Slowest", p.slowest.name).
+				Dur("SlowestTime", p.slowestTime).
+				Int("Overruns", p.missed).
+				Msg("Tick took longer than the fixed time step")
+			p.missed = 0
+			p.lastOverrunLog = time.Now()
+		}
+	}
+
+	p.tickTime = 0
+	p.slowest = nil
+	p.slowestTime = 0
+}
+
+// Returns the rolling stats of every system
+func (p *Profiler) Report() ProfileReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	report := ProfileReport{
+		Tick: p.tick.stats(),
+		Systems: make([]ProfileStats, len(p.systems)),
+		Ticks: p.ticks,
+		Overruns: p.overruns,
+	}
+	for i := range p.systems {
+		report.Systems[i] = p.systems[i].stats()
+	}
+	return report
+}
+
+// Logs the rolling stats of the tick and every system
+func (p *Profiler) Log() {
+	report := p.Report()
+	log.Info().
+		Dur("P50", report.Tick.P50).
+		Dur("P95", report.Tick.P95).
+		Dur("P99", report.Tick.P99).
+		Dur("Max", report.Tick.Max).
+		Int("Ticks", report.Ticks).
+		Int("Overruns", report.Overruns).
+		Msg("Tick profile")
+	for _, s := range report.Systems {
+		log.Info().
+			Str("System", s.Name).
+			Dur("P50", s.P50).
+			Dur("P95", s.P95).
+			Dur("P99", s.P99).
+			Dur("Max", s.Max).
+			Msg("System profile")
+	}
+}
@@ -0,0 +1,61 @@
+package mmo
+
+import (
+	"time"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+)
+
+func TestProfiler(t *testing.T) {
+	step := 10 * time.Millisecond
+	profiler := NewProfiler(step)
+
+	slow := false
+	physics := profiler.wrap([]ecs.System{
+		ecs.System{"Fast", func(dt time.Duration) {}},
+		ecs.System{"Slow", func(dt time.Duration) {
+			if slow {
+				time.Sleep(2 * step)
+			}
+		}},
+	}, true)
+	render := profiler.wrap([]ecs.System{
+		ecs.System{"Render", func(dt time.Duration) {}},
+	}, false)
+
+	runTick := func() {
+		for _, sys := range physics {
+			sys.Func(step)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		runTick()
+		render[0].Func(step)
+	}
+	slow = true
+	runTick()
+
+	report := profiler.Report()
+	if report.Ticks != 11 || report.Overruns != 1 {
+		t.Errorf("Expected 1 of 11 ticks to overrun, got %d of %d", report.Overruns, report.Ticks)
+	}
+	if len(report.Systems) != 3 {
+		t.Fatalf("Expected every system in the report, got %v", report.Systems)
+	}
+	if report.Tick.Max < 2 * step || report.Tick.P50 >= step {
+		t.Errorf("Unexpected tick stats: %+v", report.Tick)
+	}
+	slowest, ok := report.Slowest()
+	if !ok || slowest.Name != "Slow" || slowest.Last < 2 * step {
+		t.Errorf("Expected Slow to be the slowest system, got %+v", slowest)
+	}
+
+	// The render systems aren't part of the tick
+	profiler.setStep(time.Nanosecond)
+	render[0].Func(step)
+	if profiler.Report().Ticks != 11 {
+		t.Errorf("Expected render systems not to count as ticks")
+	}
+}
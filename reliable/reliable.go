@@ -0,0 +1,293 @@
+package reliable
+
+// This package layers a reliable-ordered channel on top of a socket that might drop messages (ie webrtc, or the client's simulated packet loss)
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"math/rand"
+
+	"github.com/unitoftime/flow/net"
+
+	"github.com/unitoftime/mmo/serdes"
+)
+
+var ErrTooManyPending = errors.New("too many unacknowledged reliable messages")
+
+// The part of net.Socket that the channel needs
+type Socket interface {
+	Send(any) error
+	Recv() (any, error)
+	Close() error
+}
+
+type Config struct {
+	RetransmitAfter time.Duration // How long to wait for an ack before resending a reliable message
+	MaxPending int // The most reliable messages that can be waiting on an ack. This also limits how far ahead of the next expected message we buffer
+}
+
+func DefaultConfig() Config {
+	return Config{
+		RetransmitAfter: 200 * time.Millisecond,
+		MaxPending: 256,
+	}
+}
+
+type pendingMessage struct {
+	seq uint32
+	data []byte
+	sentAt time.Time
+}
+
+type Stats struct {
+	Pending int // The number of reliable messages waiting on an ack
+	Retransmits int // The total number of reliable messages that were resent
+	Dropped int // The total number of messages dropped by the simulated packet loss
+}
+
+// Splits a socket into two channels, based on serdes.IsReliable:
+//   - Reliable messages are wrapped with a sequence number, and are retransmitted until they are acked. The receiver delivers them exactly once, in order
+//   - Everything else is sent as is, and may be dropped or arrive out of order
+// Note: The proxy <-> server connections run over tcp, so they don't need this
+type Conn struct {
+	sock Socket
+	config Config
+	serdes *serdes.Serdes
+
+	mu sync.Mutex
+	nextSeq uint32 // The seq of the next reliable message that we send
+	pending []pendingMessage // Sorted by seq
+	received uint32 // We have delivered every reliable message up to and including this seq
+	outOfOrder map[uint32]any // Reliable messages that arrived before the ones in front of them
+	ready []any // Reliable messages that are ready to be returned from Recv, in order
+
+	packetloss float64
+	rng *rand.Rand
+	retransmits, dropped int
+
+	done chan struct{}
+	closeOnce sync.Once
+}
+
+// Wraps the socket and starts resending unacked messages in the background, until the Conn is closed
+func NewConn(sock Socket, config Config) *Conn {
+	c := newConn(sock, config)
+	go c.retransmitLoop()
+	return c
+}
+
+func newConn(sock Socket, config Config) *Conn {
+	c := &Conn{
+		sock: sock,
+		config: config,
+		serdes: serdes.New(),
+		rng: rand.New(rand.NewSource(time.Now().UnixNano())),
+		done: make(chan struct{}),
+	}
+	c.Reset()
+	return c
+}
+
+// Drops everything that is in flight, and starts the sequence over. This must be called when the underlying socket reconnects, because the remote starts a new channel for every connection
+func (c *Conn) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextSeq = 1
+	c.pending = c.pending[:0]
+	c.received = 0
+	c.outOfOrder = make(map[uint32]any)
+	c.ready = c.ready[:0]
+}
+
+// Simulates losing this fraction of every message that is sent, for testing bad connections
+func (c *Conn) SetPacketloss(rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packetloss = rate
+}
+
+func (c *Conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Pending: len(c.pending),
+		Retransmits: c.retransmits,
+		Dropped: c.dropped,
+	}
+}
+
+// Sends the message on the channel that serdes.IsReliable picks for it
+// Note: If sending a reliable message fails, it is still retransmitted later (ie if the socket is reconnecting)
+func (c *Conn) Send(msg any) error {
+	if !serdes.IsReliable(msg) {
+		return c.write(msg)
+	}
+
+	data, err := c.serdes.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if len(c.pending) >= c.config.MaxPending {
+		c.mu.Unlock()
+		return ErrTooManyPending
+	}
+	seq := c.nextSeq
+	c.nextSeq++
+	c.pending = append(c.pending, pendingMessage{seq, data, time.Now()})
+	ack := c.received
+	c.mu.Unlock()
+
+	return c.write(serdes.Reliable{seq, ack, data})
+}
+
+// Reads the next message (blocking). Reliable messages are returned exactly once, in order
+func (c *Conn) Recv() (any, error) {
+	for {
+		c.mu.Lock()
+		if len(c.ready) > 0 {
+			msg := c.ready[0]
+			c.ready = c.ready[1:]
+			c.mu.Unlock()
+			return msg, nil
+		}
+		c.mu.Unlock()
+
+		msg, err := c.sock.Recv()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := msg.(type) {
+		case serdes.Reliable:
+			err := c.receive(t)
+			if err != nil {
+				return nil, err
+			}
+		case serdes.ReliableAck:
+			c.ack(t.Ack)
+		default:
+			return msg, nil
+		}
+	}
+}
+
+// Waits until every reliable message has been acked, or the timeout passes. Returns false if messages are still pending
+// Note: Acks are only processed by Recv, so something else has to be receiving while this waits
+func (c *Conn) Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.Stats().Pending > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Stops retransmitting and closes the socket
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return c.sock.Close()
+}
+
+// Queues the reliable message (and any that were waiting on it) to be returned from Recv, then acks everything we have
+func (c *Conn) receive(msg serdes.Reliable) error {
+	c.ack(msg.Ack)
+
+	c.mu.Lock()
+	var err error
+	if msg.Seq > c.received && msg.Seq <= c.received + uint32(c.config.MaxPending) {
+		_, duplicate := c.outOfOrder[msg.Seq]
+		if !duplicate {
+			inner, unmarshalErr := c.serdes.Unmarshal(msg.Message)
+			if unmarshalErr != nil {
+				// Note: Skip over the message, otherwise every message after it would be stuck behind it
+				err = fmt.Errorf("%w: reliable message %d: %s", net.ErrSerdes, msg.Seq, unmarshalErr)
+				inner = nil
+			}
+			c.outOfOrder[msg.Seq] = inner
+		}
+
+		for {
+			next, ok := c.outOfOrder[c.received + 1]
+			if !ok { break }
+			delete(c.outOfOrder, c.received + 1)
+			c.received++
+			if next != nil {
+				c.ready = append(c.ready, next)
+			}
+		}
+	}
+	// Note: Duplicates still get acked, because it probably means that our last ack was lost
+	ack := c.received
+	c.mu.Unlock()
+
+	// Note: If the ack gets lost the remote will retransmit, and we'll ack it again
+	c.write(serdes.ReliableAck{ack})
+	return err
+}
+
+// Removes every pending message up to and including the ack
+func (c *Conn) ack(ack uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := 0
+	for i < len(c.pending) && c.pending[i].seq <= ack {
+		i++
+	}
+	c.pending = c.pending[i:]
+}
+
+// Resends every reliable message that has been waiting on an ack for too long
+func (c *Conn) retransmit(now time.Time) {
+	c.mu.Lock()
+	resend := make([]serdes.Reliable, 0)
+	for i := range c.pending {
+		p := &c.pending[i]
+		if now.Sub(p.sentAt) < c.config.RetransmitAfter { continue }
+		p.sentAt = now
+		resend = append(resend, serdes.Reliable{p.seq, c.received, p.data})
+	}
+	c.retransmits += len(resend)
+	c.mu.Unlock()
+
+	for _, msg := range resend {
+		err := c.write(msg)
+		if err != nil {
+			return // Note: We'll try again next time
+		}
+	}
+}
+
+func (c *Conn) retransmitLoop() {
+	ticker := time.NewTicker(c.config.RetransmitAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.retransmit(now)
+		}
+	}
+}
+
+// Sends the message on the socket, unless the simulated packet loss drops it
+func (c *Conn) write(msg any) error {
+	c.mu.Lock()
+	drop := c.packetloss > 0 && c.rng.Float64() < c.packetloss
+	if drop {
+		c.dropped++
+	}
+	c.mu.Unlock()
+	if drop {
+		return nil
+	}
+	return c.sock.Send(msg)
+}
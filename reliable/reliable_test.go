@@ -0,0 +1,224 @@
+package reliable
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"testing"
+	"math/rand"
+
+	"github.com/unitoftime/flow/net"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// One end of an in memory connection that randomly drops and reorders messages
+type lossySocket struct {
+	rng *rand.Rand
+	loss float64
+	reorder float64 // The chance that a message swaps places with the one in front of it
+	remote *lossySocket
+	inbox []any
+}
+
+func newLossyPair(seed int64, loss, reorder float64) (*lossySocket, *lossySocket) {
+	rng := rand.New(rand.NewSource(seed))
+	a := &lossySocket{rng: rng, loss: loss, reorder: reorder}
+	b := &lossySocket{rng: rng, loss: loss, reorder: reorder}
+	a.remote = b
+	b.remote = a
+	return a, b
+}
+
+func (s *lossySocket) Send(msg any) error {
+	if s.rng.Float64() < s.loss {
+		return nil
+	}
+	inbox := append(s.remote.inbox, msg)
+	n := len(inbox)
+	if n > 1 && s.rng.Float64() < s.reorder {
+		inbox[n-1], inbox[n-2] = inbox[n-2], inbox[n-1]
+	}
+	s.remote.inbox = inbox
+	return nil
+}
+
+var errEmpty = fmt.Errorf("%w: empty", net.ErrNetwork)
+
+// Note: Doesn't block, so that the tests can step through time themselves
+func (s *lossySocket) Recv() (any, error) {
+	if len(s.inbox) == 0 {
+		return nil, errEmpty
+	}
+	msg := s.inbox[0]
+	s.inbox = s.inbox[1:]
+	return msg, nil
+}
+
+func (s *lossySocket) Close() error {
+	return nil
+}
+
+// Receives everything that is currently waiting on the conn
+func recvAll(c *Conn) []any {
+	ret := make([]any, 0)
+	for {
+		msg, err := c.Recv()
+		if errors.Is(err, errEmpty) {
+			return ret
+		}
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, msg)
+	}
+}
+
+func chat(i int) serdes.ChatMessage {
+	return serdes.ChatMessage{Channel: mmo.ChannelSay, Text: fmt.Sprintf("%d", i)}
+}
+
+func TestReliableOverLoss(t *testing.T) {
+	a, b := newLossyPair(1, 0.3, 0.2)
+	config := DefaultConfig()
+	client := newConn(a, config)
+	proxy := newConn(b, config)
+
+	now := time.Now()
+	received := make([]any, 0)
+	for i := 0; i < 100; i++ {
+		err := client.Send(chat(i))
+		if err != nil { panic(err) }
+
+		now = now.Add(50 * time.Millisecond)
+		received = append(received, recvAll(proxy)...)
+		recvAll(client) // Read the acks
+		client.retransmit(now)
+	}
+
+	// Keep retransmitting until everything gets through
+	for i := 0; i < 100 && client.Stats().Pending > 0; i++ {
+		now = now.Add(config.RetransmitAfter)
+		client.retransmit(now)
+		received = append(received, recvAll(proxy)...)
+		recvAll(client)
+	}
+
+	if client.Stats().Pending != 0 {
+		t.Fatalf("Expected every message to be acked, still have %d pending", client.Stats().Pending)
+	}
+	if client.Stats().Retransmits == 0 {
+		t.Errorf("Expected some messages to be retransmitted")
+	}
+	if len(received) != 100 {
+		t.Fatalf("Expected 100 messages exactly once, got %d", len(received))
+	}
+	for i := range received {
+		if received[i] != chat(i) {
+			t.Fatalf("Expected messages in order, got %v at %d", received[i], i)
+		}
+	}
+}
+
+func TestUnreliablePassesThrough(t *testing.T) {
+	a, b := newLossyPair(2, 0, 0)
+	client := newConn(a, DefaultConfig())
+	proxy := newConn(b, DefaultConfig())
+
+	input := serdes.PlayerInput{AckTick: 5}
+	client.Send(input)
+	client.Send(chat(0))
+	client.Send(serdes.NewHello())
+
+	// Only the chat message and the hello are wrapped, and nothing is pending for the unreliable ones
+	if _, ok := b.inbox[0].(serdes.PlayerInput); !ok {
+		t.Errorf("Expected input to be sent unwrapped, got %T", b.inbox[0])
+	}
+	if _, ok := b.inbox[1].(serdes.Reliable); !ok {
+		t.Errorf("Expected chat to be wrapped, got %T", b.inbox[1])
+	}
+	if _, ok := b.inbox[2].(serdes.Reliable); !ok {
+		t.Errorf("Expected hello to be wrapped, got %T", b.inbox[2])
+	}
+	if client.Stats().Pending != 2 {
+		t.Errorf("Expected two pending messages, got %d", client.Stats().Pending)
+	}
+
+	received := recvAll(proxy)
+	if len(received) != 3 || received[0].(serdes.PlayerInput).AckTick != 5 || received[1] != chat(0) {
+		t.Errorf("Unexpected messages: %v", received)
+	}
+}
+
+func TestReliableDuplicatesAndReset(t *testing.T) {
+	a, b := newLossyPair(3, 0, 0)
+	client := newConn(a, DefaultConfig())
+	proxy := newConn(b, DefaultConfig())
+
+	client.Send(chat(0))
+
+	// The ack gets lost, so the message is resent
+	recvAll(proxy)
+	a.inbox = nil
+	client.retransmit(time.Now().Add(time.Second))
+	if received := recvAll(proxy); len(received) != 0 {
+		t.Errorf("Expected duplicate to be dropped, got %v", received)
+	}
+	recvAll(client)
+	if client.Stats().Pending != 0 {
+		t.Errorf("Expected the duplicate to be acked")
+	}
+
+	// After the socket reconnects both sides start over at the first seq
+	client.Send(chat(1))
+	b.inbox = nil
+	client.Reset()
+	proxy.Reset()
+	if client.Stats().Pending != 0 {
+		t.Errorf("Expected reset to drop pending messages")
+	}
+	client.Send(chat(2))
+	received := recvAll(proxy)
+	if len(received) != 1 || received[0] != chat(2) {
+		t.Errorf("Expected the new channel to start over, got %v", received)
+	}
+}
+
+func TestSimulatedPacketloss(t *testing.T) {
+	a, b := newLossyPair(4, 0, 0)
+	client := newConn(a, DefaultConfig())
+	proxy := newConn(b, DefaultConfig())
+	client.SetPacketloss(1)
+
+	client.Send(serdes.PlayerInput{})
+	client.Send(chat(0))
+	if len(b.inbox) != 0 || client.Stats().Dropped != 2 {
+		t.Errorf("Expected everything to be dropped, got %v", b.inbox)
+	}
+
+	// The reliable message still gets through once the connection is better
+	client.SetPacketloss(0)
+	client.retransmit(time.Now().Add(time.Second))
+	received := recvAll(proxy)
+	if len(received) != 1 || received[0] != chat(0) {
+		t.Errorf("Expected the chat to be retransmitted, got %v", received)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	a, b := newLossyPair(5, 0, 0)
+	client := newConn(a, DefaultConfig())
+	proxy := newConn(b, DefaultConfig())
+
+	client.Send(chat(0))
+	if client.Drain(0) {
+		t.Errorf("Expected the message to still be pending")
+	}
+
+	recvAll(proxy)
+	recvAll(client) // Read the ack
+	if !client.Drain(time.Second) {
+		t.Errorf("Expected the message to be acked")
+	}
+}
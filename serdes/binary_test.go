@@ -30,7 +30,7 @@ func TestMarshalUnmarshal(t *testing.T) {
 		fmt.Printf("%T: %x\n", v, v)
 	}
 	{
-		dat, err := encoder.Marshal(ClientLoginResp{0xAEAE, ecs.Id(0xAAAA)})
+		dat, err := encoder.Marshal(ClientLoginResp{0xAEAE, ecs.Id(0xAAAA), 1})
 		// dat, err := MarshalBinary(ClientLoginResp{0xAEAE, ecs.Id(0xAAAA)})
 		if err != nil { panic(err) }
 
@@ -67,6 +67,72 @@ func TestMarshalUnmarshal(t *testing.T) {
 		fmt.Printf("%T: %x\n", v, v)
 	}
 
+	{
+		input := PlayerInput{0xAEAE, 2222, 2220, true, []TickInput{
+			TickInput{1110, mmo.Input{false,false,true,false}},
+			TickInput{1111, mmo.Input{true,false,true,false}},
+		}}
+		dat, err := encoder.Marshal(input)
+		if err != nil { panic(err) }
+
+		fmt.Printf("%x\n", dat)
+
+		v, err := encoder.Unmarshal(dat)
+		if err != nil { panic(err) }
+		fmt.Printf("%T: %v\n", v, v)
+		if !reflect.DeepEqual(v, input) {
+			t.Errorf("Mismatched PlayerInput: %v", v)
+		}
+	}
+	{
+		dat, err := encoder.Marshal(ChatMessage{0xAEAE, mmo.ChannelWhisper, 0xAEAE, 0xBEBE, "hello world"})
+		if err != nil { panic(err) }
+
+		fmt.Printf("%x\n", dat)
+
+		v, err := encoder.Unmarshal(dat)
+		if err != nil { panic(err) }
+		fmt.Printf("%T: %v\n", v, v)
+		if !reflect.DeepEqual(v, ChatMessage{0xAEAE, mmo.ChannelWhisper, 0xAEAE, 0xBEBE, "hello world"}) {
+			t.Errorf("Mismatched ChatMessage: %v", v)
+		}
+	}
+	{
+		dat, err := encoder.Marshal(ChatReject{mmo.RejectMuted})
+		if err != nil { panic(err) }
+
+		fmt.Printf("%x\n", dat)
+
+		v, err := encoder.Unmarshal(dat)
+		if err != nil { panic(err) }
+		fmt.Printf("%T: %v\n", v, v)
+		if !reflect.DeepEqual(v, ChatReject{mmo.RejectMuted}) {
+			t.Errorf("Mismatched ChatReject: %v", v)
+		}
+	}
+
+	{
+		inner, err := encoder.Marshal(ChatMessage{Channel: mmo.ChannelSay, Text: "hello"})
+		if err != nil { panic(err) }
+
+		dat, err := encoder.Marshal(Reliable{7, 3, inner})
+		if err != nil { panic(err) }
+
+		v, err := encoder.Unmarshal(dat)
+		if err != nil { panic(err) }
+		fmt.Printf("%T: %v\n", v, v)
+		reliable, ok := v.(Reliable)
+		if !ok || reliable.Seq != 7 || reliable.Ack != 3 {
+			t.Errorf("Mismatched Reliable: %v", v)
+		}
+
+		v, err = encoder.Unmarshal(reliable.Message)
+		if err != nil { panic(err) }
+		if !reflect.DeepEqual(v, ChatMessage{Channel: mmo.ChannelSay, Text: "hello"}) {
+			t.Errorf("Mismatched Reliable Message: %v", v)
+		}
+	}
+
 	// World update
 	{
 		// TODO - Seems like the binary package i'm using doesn't work if I don't pass a pointer here. (because I have a pointer receiver on MarshalBinary()
@@ -220,7 +286,7 @@ func TestBinaryEncodingUnions(t *testing.T) {
 		fmt.Printf("%T: %x\n", v, v)
 	}
 	{
-		dat, err := union.Serialize(ClientLoginResp{0xAEAE, ecs.Id(0xAAAA)})
+		dat, err := union.Serialize(ClientLoginResp{0xAEAE, ecs.Id(0xAAAA), 1})
 		// dat, err := MarshalBinary(ClientLoginResp{0xAEAE, ecs.Id(0xAAAA)})
 		if err != nil { panic(err) }
 
@@ -0,0 +1,206 @@
+package serdes
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/unitoftime/ecs"
+
+	"github.com/unitoftime/mmo"
+)
+
+var ErrMissingBaseline = errors.New("missing delta baseline")
+
+// This holds the full replicated state of a set of entities at a single tick
+type Snapshot map[ecs.Id][]ecs.Component
+
+// Returns a copy of the snapshot where each component slice can be modified without changing the original
+func (s Snapshot) Clone() Snapshot {
+	ret := make(Snapshot, len(s))
+	for id, compList := range s {
+		newList := make([]ecs.Component, len(compList))
+		copy(newList, compList)
+		ret[id] = newList
+	}
+	return ret
+}
+
+// Returns the snapshot without transient components, so that they aren't used as a baseline
+func (s Snapshot) baseline() Snapshot {
+	ret := make(Snapshot, len(s))
+	for id, compList := range s {
+		newList := make([]ecs.Component, 0, len(compList))
+		for _, c := range compList {
+			if isTransient(c) { continue }
+			newList = append(newList, c)
+		}
+		ret[id] = newList
+	}
+	return ret
+}
+
+// Transient components represent one-time events (like a speech bubble) rather than state. They are only sent once and never carried forward from a baseline
+func isTransient(c ecs.Component) bool {
+	switch c.(type) {
+	case ecs.CompBox[mmo.Speech]:
+		return true
+	}
+	return false
+}
+
+// Returns the components in current that are new or different from base. Entities that are unchanged are left out entirely. Entities in base that aren't in current are returned in the delete list
+func Diff(base, current Snapshot) (Snapshot, []ecs.Id) {
+	delta := make(Snapshot)
+	for id, compList := range current {
+		baseList, ok := base[id]
+		if !ok {
+			delta[id] = compList
+			continue
+		}
+
+		changed := make([]ecs.Component, 0)
+		for _, c := range compList {
+			baseComp, ok := findComponent(baseList, c)
+			if ok && reflect.DeepEqual(baseComp, c) { continue } // Skip: Component didn't change
+			changed = append(changed, c)
+		}
+		if len(changed) > 0 {
+			delta[id] = changed
+		}
+	}
+
+	deletes := make([]ecs.Id, 0)
+	for id := range base {
+		_, ok := current[id]
+		if !ok {
+			deletes = append(deletes, id)
+		}
+	}
+	return delta, deletes
+}
+
+// Reconstructs the full snapshot from a baseline and a delta that was generated from that baseline
+func Apply(base, delta Snapshot, deletes []ecs.Id) Snapshot {
+	ret := base.Clone()
+	for _, id := range deletes {
+		delete(ret, id)
+	}
+
+	for id, compList := range delta {
+		baseList := ret[id]
+		for _, c := range compList {
+			baseList = replaceComponent(baseList, c)
+		}
+		ret[id] = baseList
+	}
+	return ret
+}
+
+func findComponent(compList []ecs.Component, comp ecs.Component) (ecs.Component, bool) {
+	compType := reflect.TypeOf(comp)
+	for _, c := range compList {
+		if reflect.TypeOf(c) == compType {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Replaces the component of the same type in the list, or appends it if it doesn't exist
+func replaceComponent(compList []ecs.Component, comp ecs.Component) []ecs.Component {
+	compType := reflect.TypeOf(comp)
+	for i, c := range compList {
+		if reflect.TypeOf(c) == compType {
+			compList[i] = comp
+			return compList
+		}
+	}
+	return append(compList, comp)
+}
+
+type snapshotEntry struct {
+	tick uint16
+	valid bool
+	snapshot Snapshot
+}
+
+// This stores the last few snapshots that have been sent or received so that they can be used as delta baselines
+// The server uses Encode to delta encode its updates and the client uses Decode to reconstruct them
+type SnapshotBuffer struct {
+	entries []snapshotEntry
+}
+
+func NewSnapshotBuffer(size int) *SnapshotBuffer {
+	return &SnapshotBuffer{
+		entries: make([]snapshotEntry, size),
+	}
+}
+
+func (b *SnapshotBuffer) Add(tick uint16, snapshot Snapshot) {
+	b.entries[int(tick) % len(b.entries)] = snapshotEntry{
+		tick: tick,
+		valid: true,
+		snapshot: snapshot.baseline(),
+	}
+}
+
+func (b *SnapshotBuffer) Get(tick uint16) (Snapshot, bool) {
+	entry := b.entries[int(tick) % len(b.entries)]
+	if !entry.valid || entry.tick != tick {
+		return nil, false
+	}
+	return entry.snapshot, true
+}
+
+// Stores the full snapshot of the update, then delta encodes the update against the acknowledged tick. If the acknowledged snapshot is too old or missing, then the update is left as a full snapshot
+func (b *SnapshotBuffer) Encode(update *WorldUpdate, ackTick uint16, acked bool) {
+	current := Snapshot(update.WorldData)
+	b.Add(update.Tick, current)
+
+	if !acked { return } // Send full snapshot: the client hasn't received anything yet
+
+	age := mmo.TickDiff(update.Tick, ackTick)
+	if age < 0 { return } // Send full snapshot: the client acknowledged a tick that we haven't sent yet
+	if age >= len(b.entries) { return } // Send full snapshot: the baseline is too old
+
+	baseline, ok := b.Get(ackTick)
+	if !ok { return } // Send full snapshot: we don't have the baseline anymore
+
+	delta, deletes := Diff(baseline, current)
+	update.WorldData = delta
+	update.BaseTick = ackTick
+	update.Delta = true
+
+	// Merge in the deletes that are relative to the baseline
+	for _, id := range deletes {
+		found := false
+		for i := range update.Delete {
+			if update.Delete[i] == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			update.Delete = append(update.Delete, id)
+		}
+	}
+}
+
+// Reconstructs the full snapshot of a delta encoded update, then stores it so that it can be used as a future baseline. Returns ErrMissingBaseline if the update can't be reconstructed.
+func (b *SnapshotBuffer) Decode(update *WorldUpdate) error {
+	full := Snapshot(update.WorldData)
+	if update.Delta {
+		baseline, ok := b.Get(update.BaseTick)
+		if !ok {
+			return ErrMissingBaseline
+		}
+		full = Apply(baseline, update.WorldData, update.Delete)
+	}
+
+	b.Add(update.Tick, full)
+
+	update.WorldData = full.Clone()
+	update.BaseTick = 0
+	update.Delta = false
+	return nil
+}
@@ -0,0 +1,150 @@
+package serdes
+
+import (
+	"fmt"
+	"testing"
+	"reflect"
+
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/ecs"
+)
+
+// Sends the update through the serializer, the same way it would be sent over the wire
+func roundTrip(encoder *Serdes, update WorldUpdate) WorldUpdate {
+	dat, err := encoder.Marshal(update)
+	if err != nil { panic(err) }
+
+	fmt.Printf("Dat(%d): %x\n", len(dat), dat)
+
+	v, err := encoder.Unmarshal(dat)
+	if err != nil { panic(err) }
+	return v.(WorldUpdate)
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	encoder := New()
+	serverHistory := NewSnapshotBuffer(mmo.MaxSnapshotAge)
+	clientHistory := NewSnapshotBuffer(mmo.MaxSnapshotAge)
+
+	states := []Snapshot{
+		{
+			1: []ecs.Component{ecs.C(phy2.Pos{1,2}), ecs.C(mmo.Body{1}), ecs.C(mmo.Input{})},
+			2: []ecs.Component{ecs.C(phy2.Pos{4,5}), ecs.C(mmo.Body{2}), ecs.C(mmo.Input{})},
+		},
+		// Entity 1 moves and talks
+		{
+			1: []ecs.Component{ecs.C(phy2.Pos{1,3}), ecs.C(mmo.Body{1}), ecs.C(mmo.Input{Up: true}), ecs.C(mmo.Speech{Text: "hello"})},
+			2: []ecs.Component{ecs.C(phy2.Pos{4,5}), ecs.C(mmo.Body{2}), ecs.C(mmo.Input{})},
+		},
+		// Entity 2 leaves, Entity 3 arrives
+		{
+			1: []ecs.Component{ecs.C(phy2.Pos{1,4}), ecs.C(mmo.Body{1}), ecs.C(mmo.Input{Up: true})},
+			3: []ecs.Component{ecs.C(phy2.Pos{7,8}), ecs.C(mmo.Body{3}), ecs.C(mmo.Input{})},
+		},
+	}
+
+	acked := false
+	ackTick := uint16(0)
+	for i, state := range states {
+		tick := uint16(i)
+		update := WorldUpdate{
+			Tick: tick,
+			WorldData: state.Clone(),
+			Delete: []ecs.Id{},
+		}
+		serverHistory.Encode(&update, ackTick, acked)
+
+		if acked != update.Delta {
+			t.Errorf("Tick %d: expected delta to be %v", tick, acked)
+		}
+
+		recv := roundTrip(encoder, update)
+		err := clientHistory.Decode(&recv)
+		if err != nil { panic(err) }
+
+		if !reflect.DeepEqual(map[ecs.Id][]ecs.Component(state), recv.WorldData) {
+			t.Errorf("Tick %d: reconstructed state doesn't match:\nExpected: %v\nGot: %v", tick, state, recv.WorldData)
+		}
+
+		acked = true
+		ackTick = tick
+	}
+
+	// Nothing changed, so the delta should be empty
+	{
+		update := WorldUpdate{
+			Tick: uint16(len(states)),
+			WorldData: states[len(states)-1].Clone(),
+		}
+		serverHistory.Encode(&update, ackTick, acked)
+		if len(update.WorldData) != 0 {
+			t.Errorf("Expected empty delta, got: %v", update.WorldData)
+		}
+	}
+}
+
+func TestDeltaFallback(t *testing.T) {
+	serverHistory := NewSnapshotBuffer(mmo.MaxSnapshotAge)
+
+	state := Snapshot{
+		1: []ecs.Component{ecs.C(phy2.Pos{1,2}), ecs.C(mmo.Body{1}), ecs.C(mmo.Input{})},
+	}
+
+	update := WorldUpdate{Tick: 0, WorldData: state.Clone()}
+	serverHistory.Encode(&update, 0, false)
+
+	// The client acknowledged a tick that is too old, so we should send a full snapshot
+	update = WorldUpdate{Tick: mmo.MaxSnapshotAge, WorldData: state.Clone()}
+	serverHistory.Encode(&update, 0, true)
+	if update.Delta {
+		t.Errorf("Expected a full snapshot when the baseline is too old")
+	}
+	if !reflect.DeepEqual(map[ecs.Id][]ecs.Component(state), update.WorldData) {
+		t.Errorf("Full snapshot doesn't match: %v", update.WorldData)
+	}
+
+	// The client acknowledged a tick that we never sent, so we should send a full snapshot
+	update = WorldUpdate{Tick: mmo.MaxSnapshotAge + 1, WorldData: state.Clone()}
+	serverHistory.Encode(&update, 5, true)
+	if update.Delta {
+		t.Errorf("Expected a full snapshot when the baseline is missing")
+	}
+
+	// The client can't reconstruct a delta if it never received the baseline
+	clientHistory := NewSnapshotBuffer(mmo.MaxSnapshotAge)
+	delta := WorldUpdate{Tick: 10, BaseTick: 9, Delta: true, WorldData: state.Clone()}
+	err := clientHistory.Decode(&delta)
+	if err != ErrMissingBaseline {
+		t.Errorf("Expected ErrMissingBaseline, got: %v", err)
+	}
+}
+
+// Server ticks wrap at mmo.TickModulus, so a baseline from just before the wrap is still recent
+func TestDeltaTickWrap(t *testing.T) {
+	// Note: The buffer size doesn't divide the wrap, so the ticks on either side of it land in different slots
+	serverHistory := NewSnapshotBuffer(3)
+
+	state := Snapshot{
+		1: []ecs.Component{ecs.C(phy2.Pos{1,2}), ecs.C(mmo.Body{1}), ecs.C(mmo.Input{})},
+	}
+	for _, tick := range []uint16{mmo.TickModulus - 2, mmo.TickModulus - 1} {
+		update := WorldUpdate{Tick: tick, WorldData: state.Clone()}
+		serverHistory.Encode(&update, 0, false)
+	}
+
+	// The baseline is 2 ticks old
+	update := WorldUpdate{Tick: 0, WorldData: state.Clone()}
+	serverHistory.Encode(&update, mmo.TickModulus - 2, true)
+	if !update.Delta || update.BaseTick != mmo.TickModulus - 2 {
+		t.Errorf("Expected a delta against the tick before the wrap: %+v", update)
+	}
+
+	// The client can't acknowledge a tick that we haven't sent yet
+	update = WorldUpdate{Tick: 1, WorldData: state.Clone()}
+	serverHistory.Encode(&update, 2, true)
+	if update.Delta {
+		t.Errorf("Expected a full snapshot when the acknowledged tick is in the future")
+	}
+}
@@ -1,12 +1,15 @@
 package serdes
 
 import (
+	"reflect"
+
 	"github.com/unitoftime/binary"
 	"github.com/unitoftime/ecs"
 	"github.com/unitoftime/flow/net"
 
 	"github.com/unitoftime/flow/phy2"
 	"github.com/unitoftime/mmo"
+	"github.com/unitoftime/mmo/stat"
 )
 
 // type MessageRouter struct {
@@ -24,16 +27,23 @@ import (
 // Json:   411 Kb/s
 
 // TODO! - should I just have one big union object that everything is in? That'll greatly simplify a recursive serializer. Kindoflike gob where if you hit an interface you just try to unionize it. Then when you pull it out you do the opposite...
+// Note: Changing either of these lists changes the protocol fingerprint (See Hello). The first four messages are pinned so that a mismatched remote can still read the handshake
+var componentTypes = []any{ecs.C(phy2.Pos{}), ecs.C(mmo.Input{}), ecs.C(mmo.Body{}), ecs.C(mmo.Speech{}), ecs.C(mmo.ZoneId(0))}
+var messageTypes = []any{Hello{}, HelloReject{}, Reliable{}, ReliableAck{}, WorldUpdate{}, ClientLogin{}, ClientLoginResp{}, ClientLogout{}, ClientLogoutResp{}, ClientAuth{}, ClientAuthReject{}, PlayerInput{}, ChatMessage{}, ZoneTransfer{}, ChatReject{}, ServerShutdown{}, UserKick{}}
+
 var componentUnion *net.UnionBuilder
 func init() {
 	// componentUnion = NewUnion(phy2.Transform{}, phy2.Input{}, game.Body{}, game.Speech{})
-	componentUnion = net.NewUnion(ecs.C(phy2.Pos{}), ecs.C(mmo.Input{}), ecs.C(mmo.Body{}), ecs.C(mmo.Speech{}))
+	componentUnion = net.NewUnion(componentTypes...)
 }
 
 // TODO - for delta encoding of things that have to be different like ecs.Ids, if you encode the number as 0 then that could indicate that "we needed more bytes to encode the delta"
+// Note: If Delta is set, then WorldData only holds the components that changed since BaseTick. See SnapshotBuffer
 type WorldUpdate struct {
 	Tick uint16
 	PlayerTick uint16
+	BaseTick uint16 // The server tick that WorldData was delta encoded against, only valid if Delta is set
+	Delta bool
 	UserId uint64
 	WorldData map[ecs.Id][]ecs.Component
 	// WorldData EntityMap // TODO - might be nice to reduce the BinWorldUpdate to just the entity map
@@ -42,6 +52,8 @@ type WorldUpdate struct {
 type BinWorldUpdate struct {
 	Tick uint16
 	PlayerTick uint16
+	BaseTick uint16
+	Delta bool
 	UserId uint64
 	WorldData map[uint32][]net.Union
 	Delete []ecs.Id
@@ -51,6 +63,8 @@ func (w WorldUpdate) MarshalBinary() ([]byte, error) {
 	wu := BinWorldUpdate{
 		Tick: w.Tick,
 		PlayerTick: w.PlayerTick,
+		BaseTick: w.BaseTick,
+		Delta: w.Delta,
 		UserId: w.UserId,
 		// WorldData: make(map[ecs.Id][]BinaryComponent), // TODO the binary serdes package I'm using doesn't support ecs.Id as a key panic: reflect.Value.SetMapIndex: value of type uint32 is not assignable to type ecs.Id [recovered] panic: reflect.Value.SetMapIndex: value of type uint32 is not assignable to type ecs.Id
 		WorldData: make(map[uint32][]net.Union),
@@ -76,6 +90,8 @@ func (w *WorldUpdate) UnmarshalBinary(data []byte) error {
 
 	w.Tick = wu.Tick
 	w.PlayerTick = wu.PlayerTick
+	w.BaseTick = wu.BaseTick
+	w.Delta = wu.Delta
 	w.UserId = wu.UserId
 	w.Delete = wu.Delete
 	// w.Messages = wu.Messages
@@ -100,6 +116,64 @@ func (w *WorldUpdate) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// An input and the player tick that it was captured on
+type TickInput struct {
+	Tick uint16
+	Input mmo.Input
+}
+
+// Sent by the client every network tick with their recent inputs
+// Note: Each message repeats the inputs that the server hasn't acknowledged yet (up to mmo.InputRedundancy), so that a lost message doesn't lose any inputs
+type PlayerInput struct {
+	UserId uint64 // Note: The proxy sets this, so the server can trust it
+	AckTick uint16 // The last server tick that the client has fully received, only valid if Acked is set
+	RenderTick uint16 // The server tick that the client is rendering other entities at, this is behind AckTick by the client's interpolation delay. Only valid if Acked is set
+	Acked bool
+	Inputs []TickInput // Oldest first, the last one is the input for the current player tick
+}
+
+// Sent by the client when their player says something, and by the server to each user that should receive it
+type ChatMessage struct {
+	UserId uint64 // Note: The proxy sets this to the sender, so the server can trust it. The server sets this to the receiver
+	Channel mmo.ChatChannel
+	From uint64 // The user that sent the message, set by the server
+	Target uint64 // The user that a whisper is sent to
+	Text string
+}
+
+// Sent by the proxy back to the sender when their chat message was rejected by moderation
+type ChatReject struct {
+	Reason mmo.ChatRejectReason
+}
+
+// Serializes a list of components on their own, this is used to persist entities
+func MarshalComponents(comps []ecs.Component) ([]byte, error) {
+	unions := make([]net.Union, 0, len(comps))
+	for _, c := range comps {
+		union, err := componentUnion.Make(c)
+		if err != nil { return nil, err }
+		unions = append(unions, union)
+	}
+	return binary.Marshal(unions)
+}
+
+func UnmarshalComponents(data []byte) ([]ecs.Component, error) {
+	unions := make([]net.Union, 0)
+	err := binary.Unmarshal(data, &unions)
+	if err != nil { return nil, err }
+
+	comps := make([]ecs.Component, 0, len(unions))
+	for _, union := range unions {
+		anyComp, err := componentUnion.Unmake(union)
+		if err != nil { return nil, err }
+		comp, ok := anyComp.(ecs.Component)
+		if ok {
+			comps = append(comps, comp)
+		}
+	}
+	return comps, nil
+}
+
 type ClientLogin struct {
 	UserId uint64
 }
@@ -107,6 +181,7 @@ type ClientLogin struct {
 type ClientLoginResp struct {
 	UserId uint64
 	Id ecs.Id
+	Zone mmo.ZoneId // The zone that the user's entity is in
 }
 
 type ClientLogout struct {
@@ -117,20 +192,96 @@ type ClientLogoutResp struct {
 	Id ecs.Id
 }
 
+// Sent by a server to the proxy when a user needs to move to a different zone. The proxy then forwards it to the server that owns the target zone, which logs the user in with the transferred character
+type ZoneTransfer struct {
+	UserId uint64
+	Zone mmo.ZoneId // The zone that the user is moving to
+	Character []byte // The user's character, serialized with MarshalComponents
+}
+
+// Sent by a server to each proxy while it is shutting down, the proxy passes it on to the users in that server's zone
+type ServerShutdown struct {
+	Countdown uint16 // The number of seconds until the server shuts down, 0 means that it has shut down
+}
+
+// Sent by the client to the proxy to log in
+type ClientAuth struct {
+	Token string
+}
+
+// Sent by a server to the proxy when an admin kicks a user. The server has already logged them out, so the proxy forwards it to the user and disconnects them
+type UserKick struct {
+	UserId uint64
+	Reason string
+}
+
+// Sent by the proxy to the client when the login was rejected. The proxy closes the connection afterwards
+type ClientAuthReject struct {
+	Reason string
+}
+
+// Wraps a message that was sent on the reliable channel (See: reliable.Conn)
+type Reliable struct {
+	Seq uint32 // Starts at 1 for every connection
+	Ack uint32 // The sender has received every reliable message up to and including this seq
+	Message []byte // The wrapped message, serialized with Serdes
+}
+
+// Sent back whenever a reliable message is received, so that the sender can stop retransmitting it
+type ReliableAck struct {
+	Ack uint32
+}
+
+// Returns true if the message has to arrive, and in order (ie logins and chat). Everything else is latest-wins (ie inputs and world updates) and is fine to drop, because a newer one is always on the way
+// Note: Hello and HelloReject are reliable so that ClientAuth can't arrive before them. They are still readable by a remote that speaks a different protocol, because Reliable and ReliableAck are pinned in the union right after them
+func IsReliable(msg any) bool {
+	switch msg.(type) {
+	case Hello, HelloReject, ClientLogin, ClientLoginResp, ClientLogout, ClientLogoutResp, ClientAuth, ClientAuthReject, ChatMessage, ChatReject, ZoneTransfer, UserKick:
+		return true
+	}
+	return false
+}
+
 type Serdes struct {
 	union *net.UnionBuilder
 }
 
 func New() *Serdes {
+	return newSerdes(messageTypes)
+}
+
+func newSerdes(messages []any) *Serdes {
 	return &Serdes{
-		union: net.NewUnion(WorldUpdate{}, ClientLogin{}, ClientLoginResp{}, ClientLogout{}, ClientLogoutResp{}),
+		union: net.NewUnion(messages...),
 	}
 }
 
 func (s *Serdes) Marshal(v any) ([]byte, error) {
-	return s.union.Serialize(v)
+	dat, err := s.union.Serialize(v)
+	if err != nil {
+		stat.SerdesErrors.With(stat.Sent).Inc()
+		return nil, err
+	}
+	countMessage(stat.Sent, v, dat)
+	return dat, nil
 }
 
 func (s *Serdes) Unmarshal(dat []byte) (any, error) {
-	return s.union.Deserialize(dat)
+	v, err := s.union.Deserialize(dat)
+	if err != nil {
+		stat.SerdesErrors.With(stat.Received).Inc()
+		return nil, err
+	}
+	countMessage(stat.Received, v, dat)
+	return v, nil
+}
+
+// Counts the message in the metrics, by its type
+// Note: The message inside of a Reliable wrapper goes through Serdes on its own, so the wrapper isn't counted. Otherwise every reliable message would be counted twice
+func countMessage(direction string, v any, dat []byte) {
+	if _, ok := v.(Reliable); ok { return }
+
+	name := reflect.TypeOf(v).Name()
+	stat.Messages.With(direction, name).Inc()
+	stat.MessageBytes.With(direction, name).Add(float64(len(dat)))
 }
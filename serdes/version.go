@@ -0,0 +1,118 @@
+package serdes
+
+import (
+	"fmt"
+	"errors"
+	"reflect"
+	"strings"
+	"hash/fnv"
+)
+
+// Increment this whenever the protocol changes in a way that the fingerprint can't detect (ie the meaning of a field changes)
+const ProtocolVersion uint32 = 1
+
+var ErrVersionMismatch = errors.New("protocol version mismatch")
+var ErrFingerprintMismatch = errors.New("protocol fingerprint mismatch")
+
+// Sent by both sides at the start of every connection (client <-> proxy and proxy <-> server)
+// Note: Hello and HelloReject are pinned to the first two union slots (and the Reliable wrapper to the next two) so that they can always be decoded, even if the rest of the union is different
+type Hello struct {
+	Version uint32
+	Fingerprint uint64 // A hash of every message and component type that can be sent over the network
+}
+
+// Sent when the remote's Hello is incompatible. The connection gets closed afterwards
+type HelloReject struct {
+	Reason string
+	Version uint32
+	Fingerprint uint64
+}
+
+var protocolFingerprint uint64
+func init() {
+	protocolFingerprint = Fingerprint(messageTypes, componentTypes)
+}
+
+// Returns the Hello message for this build of the protocol
+func NewHello() Hello {
+	return Hello{
+		Version: ProtocolVersion,
+		Fingerprint: protocolFingerprint,
+	}
+}
+
+// Returns nil if the remote's Hello is compatible with this build of the protocol
+func CheckHello(remote Hello) error {
+	return checkHello(NewHello(), remote)
+}
+
+// Builds the rejection message to send back when CheckHello fails
+func NewHelloReject(err error) HelloReject {
+	hello := NewHello()
+	return HelloReject{
+		Reason: err.Error(),
+		Version: hello.Version,
+		Fingerprint: hello.Fingerprint,
+	}
+}
+
+func checkHello(local, remote Hello) error {
+	if local.Version != remote.Version {
+		return fmt.Errorf("%w: local %d, remote %d", ErrVersionMismatch, local.Version, remote.Version)
+	}
+	if local.Fingerprint != remote.Fingerprint {
+		return fmt.Errorf("%w: local %x, remote %x", ErrFingerprintMismatch, local.Fingerprint, remote.Fingerprint)
+	}
+	return nil
+}
+
+// Hashes the layout of the union type registries. Changing the order of the types, adding or removing a type, or changing any field of a type changes the fingerprint
+func Fingerprint(messages []any, components []any) uint64 {
+	var b strings.Builder
+	b.WriteString("messages:")
+	for i := range messages {
+		fmt.Fprintf(&b, "%d=", i)
+		describeType(&b, reflect.TypeOf(messages[i]), 0)
+		b.WriteString(";")
+	}
+	b.WriteString("components:")
+	for i := range components {
+		fmt.Fprintf(&b, "%d=", i)
+		describeType(&b, reflect.TypeOf(components[i]), 0)
+		b.WriteString(";")
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(b.String()))
+	return h.Sum64()
+}
+
+// Writes out the name and serialized layout of a type
+// Note: Unexported fields are skipped because they aren't serialized
+func describeType(b *strings.Builder, t reflect.Type, depth int) {
+	b.WriteString(t.String())
+	if depth > 8 { return } // Guard against recursive types
+
+	switch t.Kind() {
+	case reflect.Struct:
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() { continue }
+			b.WriteString(field.Name)
+			b.WriteString(" ")
+			describeType(b, field.Type, depth+1)
+			b.WriteString(",")
+		}
+		b.WriteString("}")
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		b.WriteString("[")
+		describeType(b, t.Elem(), depth+1)
+		b.WriteString("]")
+	case reflect.Map:
+		b.WriteString("[")
+		describeType(b, t.Key(), depth+1)
+		b.WriteString("]")
+		describeType(b, t.Elem(), depth+1)
+	}
+}
@@ -0,0 +1,108 @@
+package serdes
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/unitoftime/ecs"
+	"github.com/unitoftime/flow/phy2"
+
+	"github.com/unitoftime/mmo"
+)
+
+// An older version of a message, with a field missing
+type oldChatMessage struct {
+	UserId uint64
+}
+
+func TestHelloCompatible(t *testing.T) {
+	err := CheckHello(NewHello())
+	if err != nil {
+		t.Errorf("Expected matching hello to be compatible: %v", err)
+	}
+
+	err = CheckHello(Hello{ProtocolVersion + 1, NewHello().Fingerprint})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected version mismatch, got: %v", err)
+	}
+}
+
+func TestFingerprintDetectsMismatchedUnions(t *testing.T) {
+	base := Fingerprint(messageTypes, componentTypes)
+	if base != NewHello().Fingerprint {
+		t.Errorf("Fingerprint isn't deterministic")
+	}
+
+	reordered := append([]any{}, messageTypes...)
+	reordered[2], reordered[3] = reordered[3], reordered[2]
+
+	changedField := append([]any{}, messageTypes...)
+	changedField[len(changedField)-1] = oldChatMessage{}
+
+	changedComponent := append([]any{}, componentTypes...)
+	changedComponent[0] = ecs.C(phy2.Rigidbody{})
+
+	tests := map[string]uint64{
+		"Added Message": Fingerprint(append(append([]any{}, messageTypes...), ClientLogin{}), componentTypes),
+		"Removed Message": Fingerprint(messageTypes[:len(messageTypes)-1], componentTypes),
+		"Reordered Messages": Fingerprint(reordered, componentTypes),
+		"Changed Field": Fingerprint(changedField, componentTypes),
+		"Added Component": Fingerprint(messageTypes, append(append([]any{}, componentTypes...), ecs.C(mmo.Input{}))),
+		"Changed Component": Fingerprint(messageTypes, changedComponent),
+	}
+	for name, fingerprint := range tests {
+		err := CheckHello(Hello{ProtocolVersion, fingerprint})
+		if !errors.Is(err, ErrFingerprintMismatch) {
+			t.Errorf("%s: Expected fingerprint mismatch, got: %v", name, err)
+		}
+	}
+}
+
+// Wraps the message like the reliable channel does
+func wrapReliable(s *Serdes, msg any) []byte {
+	inner, err := s.Marshal(msg)
+	if err != nil { panic(err) }
+	dat, err := s.Marshal(Reliable{1, 0, inner})
+	if err != nil { panic(err) }
+	return dat
+}
+
+// Unwraps a message that was sent over the reliable channel
+func unwrapReliable(t *testing.T, s *Serdes, dat []byte) any {
+	v, err := s.Unmarshal(dat)
+	if err != nil { panic(err) }
+	wrapped, ok := v.(Reliable)
+	if !ok {
+		t.Fatalf("Expected Reliable, got %T", v)
+	}
+	v, err = s.Unmarshal(wrapped.Message)
+	if err != nil { panic(err) }
+	return v
+}
+
+// Even if the rest of the union is different, both sides must be able to read each other's Hello and HelloReject, which are sent over the reliable channel
+func TestHelloDecodesAcrossMismatchedUnions(t *testing.T) {
+	local := New()
+	remoteTypes := []any{Hello{}, HelloReject{}, Reliable{}, ReliableAck{}, ChatMessage{}, PlayerInput{}}
+	remote := newSerdes(remoteTypes)
+	remoteHello := Hello{ProtocolVersion, Fingerprint(remoteTypes, componentTypes)}
+
+	v := unwrapReliable(t, local, wrapReliable(remote, remoteHello))
+	hello, ok := v.(Hello)
+	if !ok {
+		t.Fatalf("Expected Hello, got %T", v)
+	}
+	err := CheckHello(hello)
+	if !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("Expected fingerprint mismatch, got: %v", err)
+	}
+
+	v = unwrapReliable(t, remote, wrapReliable(local, NewHelloReject(err)))
+	reject, ok := v.(HelloReject)
+	if !ok {
+		t.Fatalf("Expected HelloReject, got %T", v)
+	}
+	if reject.Fingerprint != NewHello().Fingerprint {
+		t.Errorf("Mismatched reject fingerprint: %v", reject)
+	}
+}
@@ -0,0 +1,347 @@
+package stat
+
+// A minimal metrics registry that is served in the Prometheus text format (See: https://prometheus.io/docs/instrumenting/exposition_formats/)
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"strings"
+	"net/http"
+	"sync/atomic"
+)
+
+// A metric that can be written in the text format
+type family interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Holds every registered metric
+type Registry struct {
+	mu sync.Mutex
+	families []family // In the order they were registered
+	collectors []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make([]family, 0),
+		collectors: make([]func(), 0),
+	}
+}
+
+// The registry that the package level metrics are registered to
+var Default = NewRegistry()
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.families {
+		if r.families[i].name() == f.name() {
+			panic(fmt.Sprintf("metric already registered: %s", f.name()))
+		}
+	}
+	r.families = append(r.families, f)
+}
+
+// Runs the function right before every scrape, this is for metrics that are easier to gather all at once (ie the users on each proxy)
+func (r *Registry) OnCollect(f func()) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, f)
+	r.mu.Unlock()
+}
+
+// Writes every metric in the text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	families := append([]family{}, r.families...)
+	r.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// Serves the default registry on /metrics
+// Note: This blocks, and there is no authentication, so it should only listen on a local address
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default)
+	return http.ListenAndServe(addr, mux)
+}
+
+// --------------------------------------------------------------------------------
+// - Metric types
+// --------------------------------------------------------------------------------
+
+// Stores a float64 that can be changed from multiple goroutines
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if f.bits.CompareAndSwap(old, next) { return }
+	}
+}
+
+func (f *atomicFloat) Set(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Get() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// A value that only goes up (ie messages sent)
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc() { c.value.Add(1) }
+func (c *Counter) Add(v float64) { c.value.Add(v) }
+func (c *Counter) Get() float64 { return c.value.Get() }
+
+func (c *Counter) writeSamples(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, braces(labels), formatFloat(c.Get()))
+}
+
+// A value that can go up and down (ie users that are logged in)
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Set(v float64) { g.value.Set(v) }
+func (g *Gauge) Add(v float64) { g.value.Add(v) }
+func (g *Gauge) Get() float64 { return g.value.Get() }
+
+func (g *Gauge) writeSamples(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, braces(labels), formatFloat(g.Get()))
+}
+
+// Counts observations into buckets (ie how long a system took to run)
+type Histogram struct {
+	mu sync.Mutex
+	bounds []float64 // The upper bound of each bucket, in increasing order
+	counts []uint64 // The observations that fell into each bucket (not cumulative)
+	count uint64
+	sum float64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v) // Note: Values past the last bound only show up in the +Inf bucket
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// Returns the number of observations and their sum
+func (h *Histogram) Get() (uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+func (h *Histogram) writeSamples(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, prefix, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+// Returns count buckets that start at start and each grow by factor
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	ret := make([]float64, count)
+	for i := range ret {
+		ret[i] = start
+		start *= factor
+	}
+	return ret
+}
+
+// --------------------------------------------------------------------------------
+// - Labels
+// --------------------------------------------------------------------------------
+
+type sample interface {
+	*Counter | *Gauge | *Histogram
+	writeSamples(w io.Writer, name, labels string)
+}
+
+// A metric that is split up by its labels, each set of label values gets its own child
+type Vec[T sample] struct {
+	metricName, help, kind string
+	labels []string
+
+	mu sync.Mutex
+	children map[string]T // Keyed by the formatted labels
+	newChild func() T
+}
+
+type CounterVec = Vec[*Counter]
+type GaugeVec = Vec[*Gauge]
+type HistogramVec = Vec[*Histogram]
+
+// Returns the child for the label values, creating it if this is the first time they've been used. The values must be in the same order as the labels
+func (v *Vec[T]) With(values ...string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("%s: expected %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	key := formatLabels(v.labels, values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+	}
+	return child
+}
+
+// Removes every child (ie so that proxies that disconnected stop showing up)
+func (v *Vec[T]) Reset() {
+	v.mu.Lock()
+	v.children = make(map[string]T)
+	v.mu.Unlock()
+}
+
+func (v *Vec[T]) name() string {
+	return v.metricName
+}
+
+func (v *Vec[T]) write(w io.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	children := make(map[string]T, len(v.children))
+	for key, child := range v.children {
+		children[key] = child
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.kind)
+	for _, key := range keys {
+		children[key].writeSamples(w, v.metricName, key)
+	}
+}
+
+func newVec[T sample](r *Registry, name, help, kind string, newChild func() T, labels []string) *Vec[T] {
+	v := &Vec[T]{
+		metricName: name,
+		help: help,
+		kind: kind,
+		labels: labels,
+		children: make(map[string]T),
+		newChild: newChild,
+	}
+	r.register(v)
+	return v
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return newVec(r, name, help, "counter", func() *Counter { return &Counter{} }, labels)
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return newVec(r, name, help, "gauge", func() *Gauge { return &Gauge{} }, labels)
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return newVec(r, name, help, "histogram", func() *Histogram { return newHistogram(buckets) }, labels)
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// A gauge that is read from the function on every scrape (ie the length of a channel)
+type gaugeFunc struct {
+	metricName, help string
+	f func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.register(&gaugeFunc{name, help, f})
+}
+
+func (g *gaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.metricName, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.metricName)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.f()))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Returns the labels as a="1",b="2"
+func formatLabels(labels, values []string) string {
+	parts := make([]string, len(labels))
+	for i := range labels {
+		parts[i] = fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(values[i]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func braces(labels string) string {
+	if labels == "" { return "" }
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) { return "+Inf" }
+	if math.IsInf(v, -1) { return "-Inf" }
+	if math.IsNaN(v) { return "NaN" }
+	return fmt.Sprintf("%g", v)
+}
@@ -0,0 +1,57 @@
+package stat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	messages := r.NewCounterVec("test_messages_total", "Messages", "direction", "type")
+	users := r.NewGauge("test_users", "Users")
+	duration := r.NewHistogramVec("test_duration_seconds", "Duration", []float64{0.1, 1}, "system")
+	depth := 0
+	r.NewGaugeFunc("test_depth", "Depth", func() float64 { return float64(depth) })
+
+	messages.With("sent", "WorldUpdate").Add(3)
+	messages.With("sent", "WorldUpdate").Inc()
+	messages.With("received", `Chat"Message`).Inc()
+	users.Set(5)
+	duration.With("Physics").Observe(0.05)
+	duration.With("Physics").Observe(0.5)
+	duration.With("Physics").Observe(5)
+	r.OnCollect(func() { depth = 7 })
+
+	buf := &bytes.Buffer{}
+	r.Write(buf)
+	out := buf.String()
+
+	expected := []string{
+		"# TYPE test_messages_total counter",
+		`test_messages_total{direction="sent",type="WorldUpdate"} 4`,
+		`test_messages_total{direction="received",type="Chat\"Message"} 1`,
+		"# TYPE test_users gauge",
+		"test_users 5",
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{system="Physics",le="0.1"} 1`,
+		`test_duration_seconds_bucket{system="Physics",le="1"} 2`,
+		`test_duration_seconds_bucket{system="Physics",le="+Inf"} 3`,
+		`test_duration_seconds_sum{system="Physics"} 5.55`,
+		`test_duration_seconds_count{system="Physics"} 3`,
+		"test_depth 7",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line + "\n") {
+			t.Errorf("Missing line: %s\n%s", line, out)
+		}
+	}
+
+	// Metrics can only be registered once
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering a duplicate metric to panic")
+		}
+	}()
+	r.NewGauge("test_users", "Users")
+}
@@ -4,4 +4,35 @@ package stat
 
 const(
 	UserId = "UserId"
+	PlayerTick = "PlayerTick"
+	Violations = "Violations"
+)
+
+// Metric names (See: metrics.go)
+const(
+	SystemDurationKey = "mmo_system_duration_seconds"
+	EntitiesKey = "mmo_entities"
+	UsersKey = "mmo_users"
+	MessagesKey = "mmo_messages_total"
+	MessageBytesKey = "mmo_message_bytes_total"
+	SerdesErrorsKey = "mmo_serdes_errors_total"
+	NetworkChannelDepthKey = "mmo_network_channel_depth"
+	RoomSizeKey = "mmo_proxy_room_users"
+)
+
+// Directions for the message metrics
+const(
+	Sent = "sent"
+	Received = "received"
+)
+
+// Metrics that are shared by the server, the proxy, and the client
+// Note: Metrics that need something from a specific app (ie the length of the server's networkChannel) are registered by that app
+var(
+	SystemDuration = Default.NewHistogramVec(SystemDurationKey, "How long each ecs system takes to run", ExponentialBuckets(0.00001, 4, 8), "system")
+	Entities = Default.NewGauge(EntitiesKey, "The number of entities that are replicated to users")
+	Users = Default.NewGaugeVec(UsersKey, "The number of logged in users on each proxy", "proxy")
+	Messages = Default.NewCounterVec(MessagesKey, "The number of messages serialized for the network, by direction and message type", "direction", "type")
+	MessageBytes = Default.NewCounterVec(MessageBytesKey, "The size of the serialized messages, by direction and message type", "direction", "type")
+	SerdesErrors = Default.NewCounterVec(SerdesErrorsKey, "The number of messages that failed to serialize or deserialize", "direction")
 )
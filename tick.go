@@ -0,0 +1,17 @@
+package mmo
+
+import (
+	"math"
+)
+
+// Server ticks and player ticks both wrap at this value
+const TickModulus = math.MaxUint16
+
+// Returns the number of ticks from tick b to tick a, taking the tick wrap into account. This is negative if a is before b
+func TickDiff(a, b uint16) int {
+	diff := (int(a) - int(b) + TickModulus) % TickModulus
+	if diff > TickModulus/2 {
+		diff -= TickModulus
+	}
+	return diff
+}
@@ -0,0 +1,458 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strconv"
+	gonet "net"
+	"net/url"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/unitoftime/flow/net"
+)
+
+var ErrMemClosed = fmt.Errorf("%w: mem socket closed", net.ErrNetwork)
+var ErrMemListenerClosed = fmt.Errorf("mem listener: %w", gonet.ErrClosed)
+
+// How long a dialed mem socket waits before trying to connect again
+// Note: This is a lot shorter than flow/net's reconnect time, because there is no network to wait on
+const memRetryInterval = 50 * time.Millisecond
+
+// The number of connections that can wait to be accepted
+const memBacklog = 64
+
+// The network conditions of an in-memory connection. They come from the dialed url's query (ie mem://server?latency=50ms&jitter=10ms&loss=0.05&seed=1), and apply in both directions
+//   - Every message is delayed by Latency, plus a random amount up to Jitter. Messages always arrive in the order that they were sent
+//   - Loss is the chance that a message is silently dropped. Only use this on connections that can handle it (ie under a reliable.Conn)
+type MemConditions struct {
+	Latency time.Duration
+	Jitter time.Duration
+	Loss float64
+	Seed int64 // Seeds the jitter and loss, defaults to the current time
+}
+
+func parseMemConditions(u *url.URL) (MemConditions, error) {
+	c := MemConditions{
+		Seed: time.Now().UnixNano(),
+	}
+	query := u.Query()
+	var err error
+	if v := query.Get("latency"); v != "" {
+		c.Latency, err = time.ParseDuration(v)
+		if err != nil { return c, fmt.Errorf("invalid latency: %w", err) }
+	}
+	if v := query.Get("jitter"); v != "" {
+		c.Jitter, err = time.ParseDuration(v)
+		if err != nil { return c, fmt.Errorf("invalid jitter: %w", err) }
+	}
+	if v := query.Get("loss"); v != "" {
+		c.Loss, err = strconv.ParseFloat(v, 64)
+		if err != nil { return c, fmt.Errorf("invalid loss: %w", err) }
+		if c.Loss < 0 || c.Loss > 1 {
+			return c, fmt.Errorf("loss must be between 0 and 1")
+		}
+	}
+	if v := query.Get("seed"); v != "" {
+		c.Seed, err = strconv.ParseInt(v, 10, 64)
+		if err != nil { return c, fmt.Errorf("invalid seed: %w", err) }
+	}
+	return c, nil
+}
+
+// The listeners in this process, by name
+var memListeners = struct {
+	sync.Mutex
+	byName map[string]*memListener
+}{byName: make(map[string]*memListener)}
+
+// Returns the name of the listener that the url refers to (ie mem://server0 is server0)
+func memName(u *url.URL) string {
+	return u.Host + u.Path
+}
+
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string { return "mem://" + string(a) }
+
+//--------------------------------------------------------------------------------
+// - Queue
+//--------------------------------------------------------------------------------
+
+type memMessage struct {
+	dat []byte
+	deliverAt time.Time
+}
+
+// One direction of an in-memory connection
+type memQueue struct {
+	mu sync.Mutex
+	cond *sync.Cond
+	messages []memMessage // Oldest first, so deliverAt is always increasing
+	closed bool // Nothing else can be sent, but the messages that were already sent still arrive
+	aborted bool // Nothing else arrives either
+}
+
+func newMemQueue() *memQueue {
+	q := &memQueue{messages: make([]memMessage, 0)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *memQueue) push(dat []byte, deliverAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrMemClosed
+	}
+
+	// Note: Messages can't pass each other, so a message never arrives before the one in front of it
+	if len(q.messages) > 0 {
+		last := q.messages[len(q.messages) - 1].deliverAt
+		if deliverAt.Before(last) {
+			deliverAt = last
+		}
+	}
+	q.messages = append(q.messages, memMessage{dat, deliverAt})
+	q.cond.Broadcast()
+	return nil
+}
+
+// Blocks until the oldest message is due, then returns it. Fails once the queue is closed and empty, or aborted
+func (q *memQueue) pop() ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.aborted || (q.closed && len(q.messages) == 0) {
+			return nil, ErrMemClosed
+		}
+		if len(q.messages) == 0 {
+			q.cond.Wait()
+			continue
+		}
+
+		wait := time.Until(q.messages[0].deliverAt)
+		if wait > 0 {
+			// Note: Wake up when the message is due. Aborting the queue also wakes us up
+			timer := time.AfterFunc(wait, func() {
+				q.mu.Lock()
+				q.cond.Broadcast()
+				q.mu.Unlock()
+			})
+			q.cond.Wait()
+			timer.Stop()
+			continue
+		}
+
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		return msg.dat, nil
+	}
+}
+
+// Returns the oldest message if it is due, or false if there isn't one yet. This never blocks
+func (q *memQueue) tryPop() ([]byte, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.aborted || (q.closed && len(q.messages) == 0) {
+		return nil, false, ErrMemClosed
+	}
+	if len(q.messages) == 0 || time.Now().Before(q.messages[0].deliverAt) {
+		return nil, false, nil
+	}
+
+	msg := q.messages[0]
+	q.messages = q.messages[1:]
+	return msg.dat, true, nil
+}
+
+func (q *memQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *memQueue) abort() {
+	q.mu.Lock()
+	q.closed = true
+	q.aborted = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+//--------------------------------------------------------------------------------
+// - Socket
+//--------------------------------------------------------------------------------
+
+// One end of an in-memory connection
+type memSocket struct {
+	serdes net.Serdes
+	conditions MemConditions
+
+	mu sync.Mutex
+	in, out *memQueue // Nil until the socket has connected
+	rng *rand.Rand
+
+	connected atomic.Bool
+	closed atomic.Bool // Set once the user closes the socket, a dialed socket stops reconnecting
+}
+
+func newMemSocket(serdes net.Serdes, conditions MemConditions) *memSocket {
+	return &memSocket{
+		serdes: serdes,
+		conditions: conditions,
+		rng: rand.New(rand.NewSource(conditions.Seed)),
+	}
+}
+
+// Returns false if the socket was closed, in which case the new connection is closed too
+func (s *memSocket) connect(in, out *memQueue) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() {
+		in.abort()
+		out.close()
+		return false
+	}
+	s.in = in
+	s.out = out
+	s.connected.Store(true)
+	return true
+}
+
+// Closes the current connection in both directions. Like tcp, the peer still receives what we already sent
+func (s *memSocket) disconnect() {
+	s.connected.Store(false)
+	s.mu.Lock()
+	in, out := s.in, s.out
+	s.mu.Unlock()
+	if in != nil { in.abort() }
+	if out != nil { out.close() }
+}
+
+func (s *memSocket) queues() (*memQueue, *memQueue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.in, s.out
+}
+
+// Returns how long the next message is delayed for, or false if it gets dropped
+func (s *memSocket) roll() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conditions.Loss > 0 && s.rng.Float64() < s.conditions.Loss {
+		return 0, false
+	}
+	delay := s.conditions.Latency
+	if s.conditions.Jitter > 0 {
+		delay += time.Duration(s.rng.Int63n(int64(s.conditions.Jitter)))
+	}
+	return delay, true
+}
+
+func (s *memSocket) Send(msg any) error {
+	_, out := s.queues()
+	if out == nil {
+		return fmt.Errorf("Send Socket Closed")
+	}
+
+	dat, err := s.serdes.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	delay, ok := s.roll()
+	if !ok { return nil } // Dropped
+	return out.push(dat, time.Now().Add(delay))
+}
+
+func (s *memSocket) Recv() (any, error) {
+	in, _ := s.queues()
+	if in == nil {
+		return nil, fmt.Errorf("Recv Socket Closed")
+	}
+
+	dat, err := in.pop()
+	if err != nil {
+		return nil, err
+	}
+	return s.unmarshal(dat)
+}
+
+// Returns the oldest message that has arrived, or false if there isn't one. This never blocks
+func (s *memSocket) Poll() (any, bool, error) {
+	in, _ := s.queues()
+	if in == nil {
+		return nil, false, fmt.Errorf("Recv Socket Closed")
+	}
+
+	dat, ok, err := in.tryPop()
+	if !ok {
+		return nil, false, err
+	}
+	msg, err := s.unmarshal(dat)
+	return msg, true, err
+}
+
+func (s *memSocket) unmarshal(dat []byte) (any, error) {
+	msg, err := s.serdes.Unmarshal(dat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", net.ErrSerdes, err)
+	}
+	return msg, nil
+}
+
+func (s *memSocket) Close() error {
+	s.mu.Lock()
+	s.closed.Store(true)
+	s.mu.Unlock()
+	s.disconnect()
+	return nil
+}
+
+func (s *memSocket) IsConnected() bool {
+	return s.connected.Load()
+}
+
+// Note: This keeps fmt from reading the socket's fields while they are being used
+func (s *memSocket) String() string {
+	return fmt.Sprintf("memSocket{Connected: %v, Conditions: %+v}", s.connected.Load(), s.conditions)
+}
+
+//--------------------------------------------------------------------------------
+// - Pipe
+//--------------------------------------------------------------------------------
+
+// A socket that can also be read without blocking
+type PollSocket interface {
+	Socket
+	Poll() (any, bool, error) // Returns the oldest message that has arrived, or false if there isn't one. The error is set once the connection has closed
+}
+
+// Returns both ends of an in-memory connection that doesn't go through a listener. There are no network conditions, so every message arrives as soon as it is sent
+// Note: Both ends can be polled, so one goroutine can drive both sides of the connection (ie the server's Sim)
+func NewMemPipe(serdes net.Serdes) (PollSocket, PollSocket) {
+	aToB := newMemQueue()
+	bToA := newMemQueue()
+	a := newMemSocket(serdes, MemConditions{})
+	b := newMemSocket(serdes, MemConditions{})
+	a.connect(bToA, aToB)
+	b.connect(aToB, bToA)
+	return a, b
+}
+
+//--------------------------------------------------------------------------------
+// - Listener
+//--------------------------------------------------------------------------------
+
+type memListener struct {
+	name string
+	serdes net.Serdes
+	pending chan *memSocket
+	done chan struct{}
+	closeOnce sync.Once
+}
+
+func listenMem(u *url.URL, serdes net.Serdes) (*memListener, error) {
+	name := memName(u)
+	memListeners.Lock()
+	defer memListeners.Unlock()
+	if _, ok := memListeners.byName[name]; ok {
+		return nil, fmt.Errorf("mem://%s is already being listened on", name)
+	}
+
+	l := &memListener{
+		name: name,
+		serdes: serdes,
+		pending: make(chan *memSocket, memBacklog),
+		done: make(chan struct{}),
+	}
+	memListeners.byName[name] = l
+	return l, nil
+}
+
+func (l *memListener) Accept() (Socket, error) {
+	select {
+	case sock := <-l.pending:
+		return sock, nil
+	case <-l.done:
+		return nil, ErrMemListenerClosed
+	}
+}
+
+// Stops accepting connections. Like a tcp listener, the connections that were already accepted stay open
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		memListeners.Lock()
+		delete(memListeners.byName, l.name)
+		memListeners.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *memListener) Addr() gonet.Addr {
+	return memAddr(l.name)
+}
+
+// Connects the dialed socket to a new socket on the listener's side
+func (l *memListener) connect(dialer *memSocket) error {
+	toListener := newMemQueue()
+	toDialer := newMemQueue()
+
+	// Note: The accepted end uses the same conditions, so that both directions behave the same
+	accepted := newMemSocket(l.serdes, dialer.conditions)
+	accepted.rng = rand.New(rand.NewSource(dialer.conditions.Seed + 1))
+	accepted.connect(toListener, toDialer)
+
+	select {
+	case l.pending <- accepted:
+	case <-l.done:
+		return ErrMemListenerClosed
+	default:
+		return fmt.Errorf("mem://%s has too many connections waiting to be accepted", l.name)
+	}
+	if !dialer.connect(toDialer, toListener) {
+		return ErrMemClosed
+	}
+	return nil
+}
+
+func findMemListener(name string) (*memListener, bool) {
+	memListeners.Lock()
+	defer memListeners.Unlock()
+	l, ok := memListeners.byName[name]
+	return l, ok
+}
+
+func dialMem(u *url.URL, serdes net.Serdes, handler func(Socket) error) (*memSocket, error) {
+	conditions, err := parseMemConditions(u)
+	if err != nil {
+		return nil, err
+	}
+	sock := newMemSocket(serdes, conditions)
+	go memReconnectLoop(sock, memName(u), handler)
+	return sock, nil
+}
+
+// Connects whenever the listener is there, and runs the handler until the connection breaks
+func memReconnectLoop(sock *memSocket, name string, handler func(Socket) error) {
+	for !sock.closed.Load() {
+		listener, ok := findMemListener(name)
+		if !ok {
+			time.Sleep(memRetryInterval)
+			continue
+		}
+		err := listener.connect(sock)
+		if err != nil {
+			time.Sleep(memRetryInterval)
+			continue
+		}
+
+		handler(sock)
+		sock.disconnect()
+	}
+}
@@ -0,0 +1,216 @@
+package transport
+
+import (
+	"time"
+	"errors"
+	"testing"
+
+	"github.com/unitoftime/flow/net"
+
+	"github.com/unitoftime/mmo/serdes"
+)
+
+// Dials the url and returns each socket that the handler gets, once it connects
+func dialTest(t *testing.T, uri string) (Socket, chan Socket) {
+	connected := make(chan Socket, 8)
+	config := Config{
+		Url: uri,
+		Serdes: serdes.New(),
+		ReconnectHandler: func(sock Socket) error {
+			connected <- sock
+			// Note: Like the real handlers, this runs until the connection breaks
+			for {
+				_, err := sock.Recv()
+				if err != nil {
+					return err
+				}
+			}
+		},
+	}
+	sock, err := config.Dial()
+	if err != nil { t.Fatal(err) }
+	return sock, connected
+}
+
+func listenTest(t *testing.T, uri string) Listener {
+	config := Config{Url: uri, Serdes: serdes.New()}
+	listener, err := config.Listen()
+	if err != nil { t.Fatal(err) }
+	return listener
+}
+
+func TestMemConnect(t *testing.T) {
+	listener := listenTest(t, "mem://connect")
+	defer listener.Close()
+
+	if _, err := (&Config{Url: "mem://connect", Serdes: serdes.New()}).Listen(); err == nil {
+		t.Errorf("Expected listening on the same name twice to fail")
+	}
+
+	// The dialed socket waits for the listener, so this connects, and the dialer's handler drains everything that we send
+	dialed, connected := dialTest(t, "mem://connect")
+	defer dialed.Close()
+	<-connected
+	accepted, err := listener.Accept()
+	if err != nil { t.Fatal(err) }
+	if !dialed.IsConnected() || !accepted.IsConnected() {
+		t.Errorf("Expected both ends to be connected")
+	}
+
+	err = dialed.Send(serdes.ClientLogin{42})
+	if err != nil { t.Fatal(err) }
+	msg, err := accepted.Recv()
+	if login, ok := msg.(serdes.ClientLogin); !ok || login.UserId != 42 || err != nil {
+		t.Errorf("Expected the login, got %v %v", msg, err)
+	}
+
+	// Like tcp, what was sent before closing still arrives
+	for i := uint64(0); i < 3; i++ {
+		err = dialed.Send(serdes.ClientLogin{i})
+		if err != nil { t.Fatal(err) }
+	}
+	dialed.Close()
+	for i := uint64(0); i < 3; i++ {
+		msg, err := accepted.Recv()
+		if login, ok := msg.(serdes.ClientLogin); !ok || login.UserId != i || err != nil {
+			t.Errorf("Expected login %d, got %v %v", i, msg, err)
+		}
+	}
+	_, err = accepted.Recv()
+	if !errors.Is(err, net.ErrNetwork) {
+		t.Errorf("Expected a network error once the connection closed, got %v", err)
+	}
+	if dialed.IsConnected() {
+		t.Errorf("Expected the closed socket to be disconnected")
+	}
+}
+
+func TestMemReconnect(t *testing.T) {
+	dialed, connected := dialTest(t, "mem://reconnect")
+	defer dialed.Close()
+
+	// Nothing is listening yet
+	time.Sleep(2 * memRetryInterval)
+	if dialed.IsConnected() {
+		t.Errorf("Expected the socket to wait for a listener")
+	}
+
+	listener := listenTest(t, "mem://reconnect")
+	<-connected
+	accepted, err := listener.Accept()
+	if err != nil { t.Fatal(err) }
+
+	// The listener restarts, and the socket connects to the new one
+	listener.Close()
+	if _, err := listener.Accept(); err == nil {
+		t.Errorf("Expected accepting on a closed listener to fail")
+	}
+	accepted.Close()
+	listener = listenTest(t, "mem://reconnect")
+	defer listener.Close()
+
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the socket to reconnect")
+	}
+	accepted, err = listener.Accept()
+	if err != nil { t.Fatal(err) }
+	err = accepted.Send(serdes.ClientLogin{1})
+	if err != nil {
+		t.Errorf("Expected the new connection to work: %v", err)
+	}
+}
+
+func TestMemConditions(t *testing.T) {
+	latency := 50 * time.Millisecond
+	listener := listenTest(t, "mem://conditions")
+	defer listener.Close()
+	dialed, connected := dialTest(t, "mem://conditions?latency=50ms&jitter=20ms&loss=0.5&seed=1")
+	defer dialed.Close()
+	<-connected
+	accepted, err := listener.Accept()
+	if err != nil { t.Fatal(err) }
+
+	start := time.Now()
+	sent := 100
+	for i := 0; i < sent; i++ {
+		err := dialed.Send(serdes.ClientLogin{uint64(i)})
+		if err != nil { t.Fatal(err) }
+	}
+	dialed.Close()
+
+	received := make([]uint64, 0)
+	for {
+		msg, err := accepted.Recv()
+		if err != nil { break }
+		if len(received) == 0 && time.Since(start) < latency {
+			t.Errorf("Expected the first message to take at least %s, took %s", latency, time.Since(start))
+		}
+		received = append(received, msg.(serdes.ClientLogin).UserId)
+	}
+
+	if len(received) < sent / 4 || len(received) > 3 * sent / 4 {
+		t.Errorf("Expected about half of the messages to be dropped, %d of %d arrived", len(received), sent)
+	}
+	for i := 1; i < len(received); i++ {
+		if received[i] <= received[i-1] {
+			t.Errorf("Expected the messages to arrive in order, got %v", received)
+			break
+		}
+	}
+
+	_, err = (&Config{Url: "mem://conditions?loss=2", Serdes: serdes.New()}).Dial()
+	if err == nil {
+		t.Errorf("Expected an invalid loss to fail")
+	}
+}
+
+func TestMemPipe(t *testing.T) {
+	a, b := NewMemPipe(serdes.New())
+
+	_, ok, err := b.Poll()
+	if ok || err != nil {
+		t.Errorf("Expected nothing to poll yet, got %v %v", ok, err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		err = a.Send(serdes.ClientLogin{i})
+		if err != nil { t.Fatal(err) }
+	}
+	msg, err := b.Recv()
+	if login, ok := msg.(serdes.ClientLogin); !ok || login.UserId != 0 || err != nil {
+		t.Errorf("Expected login 0, got %v %v", msg, err)
+	}
+	for i := uint64(1); i < 3; i++ {
+		msg, ok, err := b.Poll()
+		if login, isLogin := msg.(serdes.ClientLogin); !ok || !isLogin || login.UserId != i || err != nil {
+			t.Errorf("Expected to poll login %d, got %v %v %v", i, msg, ok, err)
+		}
+	}
+	_, ok, err = b.Poll()
+	if ok || err != nil {
+		t.Errorf("Expected the pipe to be empty, got %v %v", ok, err)
+	}
+
+	// Like tcp, what was sent before closing still arrives
+	err = b.Send(serdes.ClientLogin{42})
+	if err != nil { t.Fatal(err) }
+	b.Close()
+	msg, ok, err = a.Poll()
+	if login, isLogin := msg.(serdes.ClientLogin); !ok || !isLogin || login.UserId != 42 || err != nil {
+		t.Errorf("Expected the login sent before closing, got %v %v %v", msg, ok, err)
+	}
+	_, ok, err = a.Poll()
+	if ok || !errors.Is(err, net.ErrNetwork) {
+		t.Errorf("Expected a network error once the pipe closed, got %v %v", ok, err)
+	}
+	_, err = a.Recv()
+	if !errors.Is(err, net.ErrNetwork) {
+		t.Errorf("Expected a network error once the pipe closed, got %v", err)
+	}
+	err = a.Send(serdes.ClientLogin{1})
+	if !errors.Is(err, net.ErrNetwork) {
+		t.Errorf("Expected sending to a closed pipe to fail, got %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package transport
+
+// This package lets the apps dial and listen without caring whether the connection is a real network connection (See: flow/net) or an in-memory one (See: mem.go)
+
+import (
+	"fmt"
+	gonet "net"
+	"net/url"
+	"net/http"
+	"crypto/tls"
+	"sync/atomic"
+
+	"github.com/unitoftime/flow/net"
+)
+
+// A connection that sends and receives whole messages
+type Socket interface {
+	Send(any) error
+	Recv() (any, error)
+	Close() error
+	IsConnected() bool // False while a dialed socket is waiting to reconnect
+}
+
+type Listener interface {
+	Accept() (Socket, error) // Blocks until the next connection arrives. Fails once the listener is closed
+	Close() error
+	Addr() gonet.Addr
+}
+
+// The same as net.Config, except that it also supports mem:// urls
+type Config struct {
+	Url string // ie tcp://127.0.0.1:9000, webrtc://localhost:7777, or mem://server?latency=50ms&jitter=10ms&loss=0.05 (See: MemConditions)
+	Serdes net.Serdes
+	TlsConfig *tls.Config
+	ReconnectHandler func(Socket) error // Runs every time a dialed socket connects, the socket reconnects once it returns
+
+	HttpServer *http.Server // For the websocket and webrtc listeners
+	OriginPatterns []string
+}
+
+func (c *Config) netConfig() *net.Config {
+	return &net.Config{
+		Url: c.Url,
+		Serdes: c.Serdes,
+		TlsConfig: c.TlsConfig,
+		HttpServer: c.HttpServer,
+		OriginPatterns: c.OriginPatterns,
+	}
+}
+
+// Returns true if the url uses the in-memory transport, which doesn't need tls
+func IsMem(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && u.Scheme == "mem"
+}
+
+func (c *Config) Listen() (Listener, error) {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "mem" {
+		return listenMem(u, c.Serdes)
+	}
+
+	listener, err := c.netConfig().Listen()
+	if err != nil {
+		return nil, err
+	}
+	return &netListener{listener}, nil
+}
+
+// Returns a socket that keeps reconnecting in the background until it is closed, calling the ReconnectHandler every time it connects
+func (c *Config) Dial() (Socket, error) {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "mem" {
+		return dialMem(u, c.Serdes, c.ReconnectHandler)
+	}
+
+	// Note: The reconnect loop starts inside of Dial, so the handler might run before Dial returns
+	sock := &netSocket{}
+	config := c.netConfig()
+	config.ReconnectHandler = func(s *net.Socket) error {
+		sock.sock.Store(s)
+		return c.ReconnectHandler(sock)
+	}
+	s, err := config.Dial()
+	if err != nil {
+		return nil, err
+	}
+	sock.sock.Store(s)
+	return sock, nil
+}
+
+//--------------------------------------------------------------------------------
+// - flow/net
+//--------------------------------------------------------------------------------
+
+type netSocket struct {
+	sock atomic.Pointer[net.Socket]
+}
+
+func (s *netSocket) Send(msg any) error { return s.sock.Load().Send(msg) }
+func (s *netSocket) Recv() (any, error) { return s.sock.Load().Recv() }
+func (s *netSocket) Close() error { return s.sock.Load().Close() }
+func (s *netSocket) IsConnected() bool { return s.sock.Load().Connected.Load() }
+func (s *netSocket) String() string { return fmt.Sprint(s.sock.Load()) }
+
+type netListener struct {
+	listener net.Listener
+}
+
+func (l *netListener) Accept() (Socket, error) {
+	sock, err := l.listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ret := &netSocket{}
+	ret.sock.Store(sock)
+	return ret, nil
+}
+
+func (l *netListener) Close() error { return l.listener.Close() }
+func (l *netListener) Addr() gonet.Addr { return l.listener.Addr() }
@@ -0,0 +1,79 @@
+package mmo
+
+import (
+	"github.com/unitoftime/flow/tile"
+	"github.com/unitoftime/flow/phy2"
+)
+
+// Identifies a zone. Each zone is owned by exactly one game server
+// Note: This is also stored on each logged in user so that their character gets saved with the zone that they were in
+type ZoneId uint32
+
+// This is the zone that users log into if they have never played before
+const DefaultZone ZoneId = 0
+
+// Stepping onto the portal's tile transfers the user to the target tile in the target zone
+type Portal struct {
+	Tile tile.TilePosition
+	TargetZone ZoneId
+	TargetTile tile.TilePosition
+}
+
+type Zone struct {
+	Id ZoneId
+	Seed int64 // The seed used to generate the terrain
+	Portals []Portal
+}
+
+// TODO - load these from a file
+var zones = map[ZoneId]Zone{
+	0: Zone{
+		Id: 0,
+		Seed: 12345,
+		Portals: []Portal{
+			Portal{
+				Tile: tile.TilePosition{mapSize/2 + 3, mapSize/2 + 3},
+				TargetZone: 1,
+				TargetTile: tile.TilePosition{mapSize/2, mapSize/2},
+			},
+		},
+	},
+	1: Zone{
+		Id: 1,
+		Seed: 54321,
+		Portals: []Portal{
+			Portal{
+				Tile: tile.TilePosition{mapSize/2 - 3, mapSize/2 + 3},
+				TargetZone: 0,
+				TargetTile: tile.TilePosition{mapSize/2, mapSize/2},
+			},
+		},
+	},
+}
+
+// Returns the zone definition, unknown zones fall back to the default zone
+func GetZone(zoneId ZoneId) Zone {
+	zone, ok := zones[zoneId]
+	if !ok {
+		return zones[DefaultZone]
+	}
+	return zone
+}
+
+// Returns the portal that the position is standing on, if any
+func (z Zone) PortalAt(tilemap *tile.Tilemap, pos phy2.Pos) (Portal, bool) {
+	tilePos := tilemap.PositionToTile(float32(pos.X), float32(pos.Y))
+	for _, portal := range z.Portals {
+		if portal.Tile == tilePos {
+			return portal, true
+		}
+	}
+	return Portal{}, false
+}
+
+// Returns the position in the target zone that a user arrives at after going through the portal
+// Note: Every zone uses the same tile size, so the tilemap of any zone works here
+func (p Portal) TargetPos(tilemap *tile.Tilemap) phy2.Pos {
+	x, y := tilemap.TileToPosition(p.TargetTile)
+	return phy2.Pos{float64(x), float64(y)}
+}